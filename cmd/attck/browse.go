@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	modelcomp "audit-workflow/internal/components/model"
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/orchestrator"
+)
+
+// loadTaxonomy loads csvPath when given, otherwise the taxonomy configured
+// for the AI stage (including STIX and aliases).
+func loadTaxonomy(csvPath string) (*taxonomy.Taxonomy, error) {
+	if csvPath != "" {
+		return taxonomy.Load(csvPath)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load config failed: %w", err)
+	}
+	return orchestrator.LoadTaxonomy(cfg)
+}
+
+func runTactics(args []string) error {
+	fs := flag.NewFlagSet("tactics", flag.ExitOnError)
+	csvPath := fs.String("csv", "", "ATT&CK.csv (default: ai.attck.csv_path)")
+	_ = fs.Parse(args)
+
+	tax, err := loadTaxonomy(*csvPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range tax.ListTactics() {
+		id, _ := tax.LookupTacticID(name)
+		fmt.Printf("%3d  %s  (%d techniques)\n", id, name, len(tax.Techniques(name)))
+	}
+	return nil
+}
+
+func runTree(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	csvPath := fs.String("csv", "", "ATT&CK.csv (default: ai.attck.csv_path)")
+	tactic := fs.String("tactic", "", "tactic name (required)")
+	_ = fs.Parse(args)
+
+	if strings.TrimSpace(*tactic) == "" {
+		return fmt.Errorf("-tactic is required")
+	}
+	tax, err := loadTaxonomy(*csvPath)
+	if err != nil {
+		return err
+	}
+	id, ok := tax.LookupTacticID(*tactic)
+	if !ok {
+		return fmt.Errorf("tactic %q not found", *tactic)
+	}
+
+	fmt.Printf("%d %s\n", id, strings.TrimSpace(*tactic))
+	for _, tn := range tax.Techniques(*tactic) {
+		fmt.Printf("├─ %4d  %-10s %s%s\n", tn.TechniqueID, tn.CodeOfficial, tn.TechniqueName, browseSuffix(tn.NameEn, tn.Deprecated))
+		for _, sub := range tn.Subs {
+			fmt.Printf("│   └─ %4d  %-10s %s%s\n", sub.SubTechniqueID, sub.CodeOfficial, sub.SubTechniqueName, browseSuffix(sub.NameEn, sub.Deprecated))
+		}
+	}
+	return nil
+}
+
+func browseSuffix(nameEn string, deprecated bool) string {
+	var s string
+	if nameEn != "" {
+		s += " / " + nameEn
+	}
+	if deprecated {
+		s += " [deprecated]"
+	}
+	return s
+}
+
+func runCandidates(args []string) error {
+	fs := flag.NewFlagSet("candidates", flag.ExitOnError)
+	csvPath := fs.String("csv", "", "ATT&CK.csv (default: ai.attck.csv_path)")
+	tactic := fs.String("tactic", "", "tactic name (default: tactic_name of the record's result)")
+	text := fs.String("text", "", "arbitrary query text")
+	id := fs.String("id", "", "record ID from pending_audits.jsonl; its context, with the hint of a matching rule, is the query")
+	semantic := fs.Bool("semantic", false, "blend embedding similarity as the AI stage does (needs ai.attck.embedding)")
+	all := fs.Bool("all", false, "list every technique of the tactic, not only the offered ones")
+	_ = fs.Parse(args)
+
+	if (*text == "") == (*id == "") {
+		return fmt.Errorf("exactly one of -text or -id is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config failed: %w", err)
+	}
+	var tax *taxonomy.Taxonomy
+	indexPath := cfg.ATTCKEmbeddingIndexPath(cfg.ATTCKCSVPath())
+	if *csvPath != "" {
+		tax, err = taxonomy.Load(*csvPath)
+		// Never touch the configured index with vectors of another CSV.
+		indexPath = *csvPath + ".embeddings.json"
+	} else {
+		tax, err = orchestrator.LoadTaxonomy(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	query := *text
+	tacticName := strings.TrimSpace(*tactic)
+	if *id != "" {
+		rec, err := orchestrator.FindRecord(cfg.PendingAuditsPath(), *id)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			return fmt.Errorf("ID %s not found in %s", *id, cfg.PendingAuditsPath())
+		}
+		ruleSet, err := orchestrator.LoadRules(cfg, tax)
+		if err != nil {
+			return fmt.Errorf("load rules failed: %w", err)
+		}
+		s, err := orchestrator.PrepareRecord(context.Background(), cfg, tax, ruleSet, rec.ID, rec.Data)
+		if err != nil {
+			return err
+		}
+		query = s.Context
+		if s.Rule != nil {
+			fmt.Printf("rule %s (%s) matches this record\n", s.Rule.ID, s.Rule.Action)
+			if s.ScoreSource == "rule" {
+				fmt.Println("the AI stage assigns its verdict without ranking candidates")
+			}
+		}
+		if tacticName == "" && s.TacticSource == "hint" {
+			tacticName = s.Tactic
+		}
+		if tacticName == "" {
+			res, err := orchestrator.FindRecord(cfg.PendingAuditsResultsPath(), *id)
+			if err != nil {
+				return err
+			}
+			if res != nil {
+				tacticName, _ = res.Data["tactic_name"].(string)
+				tacticName = strings.TrimSpace(tacticName)
+			}
+		}
+	}
+	if tacticName == "" {
+		return fmt.Errorf("no tactic: pass -tactic")
+	}
+	if _, ok := tax.LookupTacticID(tacticName); !ok {
+		return fmt.Errorf("tactic %q not found", tacticName)
+	}
+
+	var queryVec []float64
+	if *semantic {
+		queryVec, err = embedQuery(cfg, tax, indexPath, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	topK := cfg.AI.ATTCK.TechniqueTopK
+	subMax := cfg.AI.ATTCK.SubMaxPerTechnique
+	cands := tax.GenerateTechniqueCandidatesWithVector(tacticName, query, queryVec, topK, subMax)
+	offered := map[string]bool{}
+	for _, c := range cands {
+		offered[c.TechniqueName] = true
+	}
+
+	fmt.Printf("=== QUERY (%d runes) ===\n%s\n\n", len([]rune(query)), query)
+	fmt.Printf("=== RANKING (tactic: %s, top_k: %d) ===\n", tacticName, topK)
+	fmt.Printf("    %-4s %8s %8s %8s  %-10s %s\n", "rank", "score", "lexical", "semantic", "code", "technique")
+	for i, s := range tax.ScoreTechniques(tacticName, query, queryVec) {
+		mark := " "
+		if offered[s.TechniqueName] {
+			mark = "*"
+		} else if !*all {
+			continue
+		}
+		line := fmt.Sprintf("  %s %-4d %8.3f %8.3f %8.3f  %-10s %s", mark, i+1, s.Score, s.Lexical, s.Semantic, s.CodeOfficial, s.TechniqueName)
+		if len(s.MatchedSubs) > 0 {
+			line += " [" + strings.Join(s.MatchedSubs, ", ") + "]"
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("\n=== PROMPT CANDIDATES (max %d runes) ===\n", cfg.AI.ATTCK.CandidateMaxRunes)
+	fmt.Println(taxonomy.FormatTechniqueCandidates(tacticName, cands, cfg.AI.ATTCK.CandidateMaxRunes))
+	return nil
+}
+
+// embedQuery embeds query, building or loading the vector index of tax at
+// indexPath first so that techniques carry their vectors.
+func embedQuery(cfg *config.RootConfig, tax *taxonomy.Taxonomy, indexPath, query string) ([]float64, error) {
+	ctx := context.Background()
+	emb, err := modelcomp.NewEmbedder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if emb == nil {
+		return nil, fmt.Errorf("-semantic needs ai.attck.embedding.model")
+	}
+	if _, err := tax.BuildVectorIndex(ctx, emb, taxonomy.VectorIndexOptions{
+		Model:  cfg.AI.ATTCK.Embedding.Model,
+		Path:   indexPath,
+		Weight: cfg.AI.ATTCK.Embedding.SemanticWeight(),
+	}); err != nil {
+		return nil, err
+	}
+	vecs, err := emb.EmbedStrings(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vecs) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors", len(vecs))
+	}
+	return vecs[0], nil
+}
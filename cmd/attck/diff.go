@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+)
+
+func loadPair(oldPath, newPath string) (*taxonomy.DiffReport, error) {
+	if oldPath == "" || newPath == "" {
+		return nil, fmt.Errorf("-old and -new are required")
+	}
+	oldTax, err := taxonomy.Load(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("old: %w", err)
+	}
+	newTax, err := taxonomy.Load(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("new: %w", err)
+	}
+	return taxonomy.Diff(oldTax, newTax), nil
+}
+
+func formatMapping(m taxonomy.Mapping) string {
+	return fmt.Sprintf("%s/%s/%s (%d,%d,%d)", m.TacticName, m.TechniqueName, m.SubTechniqueName, m.TacticID, m.TechniqueID, m.SubTechniqueID)
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	oldPath := fs.String("old", "", "previous ATT&CK.csv")
+	newPath := fs.String("new", "", "current ATT&CK.csv")
+	mappingOut := fs.String("mapping-out", "", "write the old→new name mapping as JSON to this file")
+	_ = fs.Parse(args)
+
+	d, err := loadPair(*oldPath, *newPath)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range d.Added {
+		fmt.Printf("+ %s\n", formatMapping(m))
+	}
+	for _, m := range d.Removed {
+		fmt.Printf("- %s\n", formatMapping(m))
+	}
+	for _, c := range d.Renamed {
+		fmt.Printf("~ renamed %s -> %s\n", formatMapping(c.Old), formatMapping(c.New))
+	}
+	for _, c := range d.IDChanged {
+		fmt.Printf("~ id      %s -> %s\n", formatMapping(c.Old), formatMapping(c.New))
+	}
+	fmt.Printf("[Summary] Added: %d, Removed: %d, Renamed: %d, ID changed: %d, Unchanged: %d\n",
+		len(d.Added), len(d.Removed), len(d.Renamed), len(d.IDChanged), d.Unchanged)
+
+	if *mappingOut != "" {
+		b, _ := json.MarshalIndent(d.NameMapping(), "", "  ")
+		if err := os.WriteFile(*mappingOut, b, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("[Info] Mapping written to %s\n", *mappingOut)
+	}
+	return nil
+}
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	oldPath := fs.String("old", "", "ATT&CK.csv the results were produced with")
+	newPath := fs.String("new", "", "ATT&CK.csv to migrate to")
+	in := fs.String("in", "", "results JSONL (default: pending_audits_results.jsonl in the state dir)")
+	out := fs.String("out", "", "output JSONL (default: rewrite -in in place, keeping a .bak copy)")
+	dryRun := fs.Bool("dry-run", false, "report only, do not write")
+	_ = fs.Parse(args)
+
+	d, err := loadPair(*oldPath, *newPath)
+	if err != nil {
+		return err
+	}
+
+	inPath := *in
+	if inPath == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("load config failed: %w", err)
+		}
+		inPath = cfg.PendingAuditsResultsPath()
+	}
+	outPath := *out
+	inPlace := outPath == ""
+	if inPlace {
+		outPath = inPath + ".migrating"
+	}
+	if *dryRun {
+		outPath = os.DevNull
+	}
+
+	src, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	// The in-place temp file must not outlive a failed migrate.
+	tmp := inPlace && !*dryRun
+	defer func() {
+		if tmp {
+			os.Remove(outPath)
+		}
+	}()
+	st, err := taxonomy.MigrateResults(src, dst, d)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, id := range st.Unmapped {
+		fmt.Printf("[Warning] ID %s: selection no longer exists in %s, left unchanged\n", id, filepath.Base(*newPath))
+	}
+	fmt.Printf("[Summary] Records: %d, Rewritten: %d, Unmapped: %d\n", st.Total, st.Changed, len(st.Unmapped))
+
+	if *dryRun || !inPlace {
+		return nil
+	}
+	if err := os.Rename(inPath, inPath+".bak"); err != nil {
+		return err
+	}
+	if err := os.Rename(outPath, inPath); err != nil {
+		os.Rename(inPath+".bak", inPath)
+		return err
+	}
+	tmp = false
+	fmt.Printf("[Success] %s rewritten (previous version at %s)\n", filepath.Base(inPath), filepath.Base(inPath)+".bak")
+	return nil
+}
@@ -0,0 +1,54 @@
+// Command attck inspects and maintains the ATT&CK taxonomy used by the
+// audit workflow.
+//
+//	go run ./cmd/attck tactics
+//	go run ./cmd/attck tree -tactic 初始访问
+//	go run ./cmd/attck candidates -id 12345 | -tactic 初始访问 -text "..."
+//	go run ./cmd/attck diff -old old.csv -new ATT&CK.csv
+//	go run ./cmd/attck migrate -old old.csv -new ATT&CK.csv [-in results.jsonl]
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"tactics", "list tactics with IDs", runTactics},
+	{"tree", "show the technique/sub-technique tree of a tactic", runTree},
+	{"candidates", "rank technique candidates for a text or a pending record", runCandidates},
+	{"diff", "compare two ATT&CK.csv versions", runDiff},
+	{"migrate", "rewrite result records from an old ATT&CK.csv version to a new one", runMigrate},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, sc := range subcommands {
+		if sc.name == os.Args[1] {
+			if err := sc.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "[Error] %s: %v\n", sc.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: attck <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, sc := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", sc.name, sc.usage)
+	}
+}
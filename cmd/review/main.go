@@ -0,0 +1,137 @@
+// Command review walks a reviewer through the records Submit held for human
+// review and records each decision; the next Submit run sends the accepted
+// and edited verdicts and skips the rejected ones.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/orchestrator"
+	"audit-workflow/internal/review"
+)
+
+func main() {
+	queue := flag.String("queue", "", "review queue (default: review_queue.jsonl in the state dir)")
+	decisions := flag.String("decisions", "", "decisions file (default: review_decisions.jsonl in the state dir)")
+	reviewer := flag.String("reviewer", os.Getenv("USER"), "name recorded with each decision")
+	list := flag.Bool("list", false, "list the queue and each record's decision, without reviewing")
+	all := flag.Bool("all", false, "also review records that already have a decision")
+	flag.Parse()
+
+	if err := run(*queue, *decisions, strings.TrimSpace(*reviewer), *list, *all); err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] review: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(queuePath, decisionsPath, reviewer string, list, all bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config failed: %w", err)
+	}
+	if queuePath == "" {
+		queuePath = cfg.ReviewQueuePath()
+	}
+	if decisionsPath == "" {
+		decisionsPath = cfg.ReviewDecisionsPath()
+	}
+	items, err := review.LoadQueue(queuePath)
+	if err != nil {
+		return err
+	}
+	decided, err := review.LoadDecisions(decisionsPath)
+	if err != nil {
+		return err
+	}
+
+	if list {
+		for _, it := range items {
+			status := "pending"
+			if d, ok := decided[review.Key(it.ID)]; ok {
+				status = d.Decision
+				if d.Hash != it.Hash {
+					status = "pending (verdict changed since " + d.Decision + ")"
+				}
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", review.Key(it.ID), it.QueuedAt, strings.Join(it.Reasons, ","), status)
+		}
+		return nil
+	}
+
+	todo := items[:0:0]
+	for _, it := range items {
+		if d, ok := decided[review.Key(it.ID)]; all || !ok || d.Hash != it.Hash {
+			todo = append(todo, it)
+		}
+	}
+	if len(todo) == 0 {
+		fmt.Printf("[Info] nothing to review in %s\n", queuePath)
+		return nil
+	}
+
+	w, err := jsonl.Open(decisionsPath, true)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	s := &review.Session{
+		In:       os.Stdin,
+		Out:      os.Stdout,
+		Reviewer: reviewer,
+		Context:  func(data map[string]any) string { return orchestrator.BuildTrimmedContext(cfg, data) },
+		Record: func(d review.Decision) error {
+			b, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := w.WriteLine(b); err != nil {
+				return err
+			}
+			return w.Sync()
+		},
+	}
+	if tax := loadTaxonomy(cfg); tax != nil {
+		s.CheckATTCK = func(tactic, technique, sub string) error {
+			m, ok := tax.Resolve(tactic, technique, sub)
+			if !ok {
+				return fmt.Errorf("tactic %q not found in ATT&CK.csv", tactic)
+			}
+			if m.Method() != string(taxonomy.MatchExact) {
+				return fmt.Errorf("ATT&CK names match inexactly (%s): submitted as %s / %s / %s", m.Method(), m.TacticName, m.TechniqueName, m.SubTechniqueName)
+			}
+			return nil
+		}
+	}
+
+	st, err := s.Run(todo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n[Summary] Accepted: %d, Edited: %d, Rejected: %d, Skipped: %d, Remaining: %d\n",
+		st.Accepted, st.Edited, st.Rejected, st.Skipped, len(todo)-st.Accepted-st.Edited-st.Rejected)
+	fmt.Printf("[Info] decisions written to %s; run Submit to send the approved records\n", decisionsPath)
+	return nil
+}
+
+func loadTaxonomy(cfg *config.RootConfig) *taxonomy.Taxonomy {
+	path := cfg.ATTCKCSVPath()
+	if path == "" {
+		return nil
+	}
+	tax, err := taxonomy.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Warning] load ATT&CK.csv failed, edited names are not checked: %v\n", err)
+		return nil
+	}
+	if aliases, err := taxonomy.LoadAliases(cfg.AI.ATTCK.AliasPath); err == nil {
+		tax.SetAliases(aliases)
+	}
+	return tax
+}
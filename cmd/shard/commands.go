@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/metrics"
+	"audit-workflow/internal/orchestrator"
+	"audit-workflow/internal/tracing"
+)
+
+func runShard(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	spec := fs.String("shard", "", "shard to analyse, i/n (required)")
+	resume := fs.Bool("resume", false, "skip IDs already in the shard's results file")
+	retryFailed := fs.Bool("retry-failed", false, "analyse only the IDs in the shard's failures file")
+	grace := fs.Duration("grace", 30*time.Second, "time in-flight records get to finish after SIGINT/SIGTERM")
+	fs.Parse(args)
+
+	shard, err := orchestrator.ParseShard(*spec)
+	if err != nil {
+		return err
+	}
+	if !shard.Enabled() {
+		return fmt.Errorf("-shard i/n with n > 1 is required")
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config failed: %w", err)
+	}
+	closeLog, err := logging.Setup(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	stopTracing, err := tracing.Setup(cfg)
+	if err != nil {
+		return err
+	}
+	defer stopTracing(context.Background())
+	stopMetrics, err := metrics.Serve(cfg.Metrics.Listen)
+	if err != nil {
+		return fmt.Errorf("start metrics listener failed: %w", err)
+	}
+	defer stopMetrics(context.Background())
+
+	sd := orchestrator.NewShutdown(context.Background(), *grace)
+	defer sd.Close()
+	err = orchestrator.RunRiskAnalysisWithOptions(sd.Context(), cfg, orchestrator.RiskAnalysisOptions{
+		Resume:      *resume,
+		RetryFailed: *retryFailed,
+		Stop:        sd.Stopping(),
+		Shard:       shard,
+	})
+	if err != nil {
+		if errors.Is(err, orchestrator.ErrInterrupted) {
+			fmt.Println("[Info] interrupted, rerun with -resume to continue")
+		}
+		return err
+	}
+	fmt.Printf("[Success] shard %s written to %s\n", shard, shard.ResultsPath(cfg))
+	return nil
+}
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("out", "", "merged results file (default: pending_audits_results.jsonl in the state dir)")
+	pending := fs.String("pending", "", "pending_audits.jsonl giving the record order and the expected IDs (default: from config)")
+	allowGaps := fs.Bool("allow-gaps", false, "write the merged file even when pending records have no result")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config failed: %w", err)
+	}
+	if *out == "" {
+		*out = cfg.PendingAuditsResultsPath()
+	}
+	if *pending == "" {
+		*pending = cfg.PendingAuditsPath()
+	}
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		base := cfg.PendingAuditsResultsPath()
+		ext := filepath.Ext(base)
+		inputs, err = filepath.Glob(strings.TrimSuffix(base, ext) + ".shard-*-of-*" + ext)
+		if err != nil {
+			return err
+		}
+		if len(inputs) == 0 {
+			return fmt.Errorf("no shard result files next to %s", filepath.Base(base))
+		}
+	}
+
+	rep, err := orchestrator.MergeShardResults(*pending, *out+".merging", inputs)
+	if err != nil {
+		return err
+	}
+	for _, in := range inputs {
+		fmt.Printf("[Info] input %s\n", in)
+	}
+	printIDs("overlap", "results in more than one file, kept the latest", rep.Overlaps)
+	printIDs("misplaced", "result stamped with a shard the ID does not hash to (different shard counts?)", rep.Misplaced)
+	printIDs("unknown", "result for an ID not in the pending file", rep.Unknown)
+	printIDs("gap", "pending record without a result", rep.Gaps)
+	if len(rep.GapsByShard) > 0 {
+		shards := make([]string, 0, len(rep.GapsByShard))
+		for s := range rep.GapsByShard {
+			shards = append(shards, s)
+		}
+		sort.Strings(shards)
+		for _, s := range shards {
+			fmt.Printf("[Warning] shard %s: %d missing\n", s, rep.GapsByShard[s])
+		}
+	}
+	fmt.Printf("[Summary] Files: %d, Lines: %d, Written: %d, Overlaps: %d, Gaps: %d, Misplaced: %d, Unknown: %d\n",
+		rep.Files, rep.Lines, rep.Written, len(rep.Overlaps), len(rep.Gaps), len(rep.Misplaced), len(rep.Unknown))
+
+	if len(rep.Gaps) > 0 && !*allowGaps {
+		return fmt.Errorf("%d pending records have no result; rerun the missing shards or pass -allow-gaps (merged file left at %s)", len(rep.Gaps), *out+".merging")
+	}
+	if err := os.Rename(*out+".merging", *out); err != nil {
+		return err
+	}
+	fmt.Printf("[Success] merged results written to %s\n", *out)
+	return nil
+}
+
+func runCount(args []string) error {
+	fs := flag.NewFlagSet("count", flag.ExitOnError)
+	n := fs.Int("shards", 0, "number of shards (required)")
+	pending := fs.String("pending", "", "pending_audits.jsonl (default: from config)")
+	fs.Parse(args)
+
+	if *pending == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("load config failed: %w", err)
+		}
+		*pending = cfg.PendingAuditsPath()
+	}
+	counts, err := orchestrator.CountShards(*pending, *n)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for i, c := range counts {
+		fmt.Printf("%d/%d\t%d\n", i+1, *n, c)
+		total += c
+	}
+	fmt.Printf("total\t%d\n", total)
+	return nil
+}
+
+func printIDs(kind, what string, ids []string) {
+	const limit = 20
+	for i, id := range ids {
+		if i == limit {
+			fmt.Printf("[Warning] ... %d more %s IDs\n", len(ids)-limit, kind)
+			break
+		}
+		fmt.Printf("[Warning] ID %s: %s\n", id, what)
+	}
+}
@@ -0,0 +1,53 @@
+// Command shard splits the AI stage across machines and merges the results.
+//
+//	go run ./cmd/shard run -shard 2/5 [-resume] [-retry-failed]
+//	go run ./cmd/shard merge [-out results.jsonl] [-allow-gaps] [shard files...]
+//	go run ./cmd/shard count -shards 5
+//
+// Every machine runs the same pending_audits.jsonl with its own API key and
+// shard; each writes pending_audits_results.shard-<i>-of-<n>.jsonl. Copy the
+// shard files into one state dir and merge them before submitting.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"run", "run the AI stage on one shard of pending_audits.jsonl", runShard},
+	{"merge", "merge shard result files into the results file submit reads", runMerge},
+	{"count", "show how many pending records fall into each shard", runCount},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, sc := range subcommands {
+		if sc.name == os.Args[1] {
+			if err := sc.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "[Error] %s: %v\n", sc.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: shard <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, sc := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", sc.name, sc.usage)
+	}
+}
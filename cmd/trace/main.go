@@ -0,0 +1,55 @@
+// Command trace pretty-prints the per-record trace written by the AI stage
+// when ai.record_trace is set.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/orchestrator"
+)
+
+func main() {
+	id := flag.String("id", "", "record ID (required)")
+	path := flag.String("file", "", "record_traces.jsonl or record_traces directory (default: per ai.record_trace)")
+	raw := flag.Bool("json", false, "print the trace as JSON")
+	flag.Parse()
+
+	if err := run(strings.TrimSpace(*id), *path, *raw); err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] trace: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(id, path string, raw bool) error {
+	if id == "" {
+		return fmt.Errorf("-id is required")
+	}
+	if path == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("load config failed: %w", err)
+		}
+		path = cfg.RecordTracesPath()
+		if cfg.AI.RecordTrace == "files" {
+			path = cfg.RecordTraceDir()
+		}
+	}
+
+	t, err := orchestrator.LoadRecordTrace(path, id)
+	if err != nil {
+		return err
+	}
+	if raw {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t)
+	}
+	orchestrator.FormatRecordTrace(os.Stdout, t)
+	return nil
+}
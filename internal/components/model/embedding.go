@@ -0,0 +1,116 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/config"
+
+	"github.com/cloudwego/eino/components/embedding"
+)
+
+// NewEmbedder returns an OpenAI-compatible embeddings client for
+// ai.attck.embedding, or nil when no embedding model is configured.
+func NewEmbedder(cfg *config.RootConfig) (embedding.Embedder, error) {
+	ec := cfg.AI.ATTCK.Embedding
+	if strings.TrimSpace(ec.Model) == "" {
+		return nil, nil
+	}
+	baseURL := strings.TrimRight(ec.BaseURL, "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("empty embedding base_url")
+	}
+	to := time.Duration(ec.TimeoutS * float64(time.Second))
+	if to <= 0 {
+		to = 60 * time.Second
+	}
+	return &openAICompatEmbedder{
+		baseURL: baseURL,
+		apiKey:  ec.APIKey,
+		model:   ec.Model,
+		hc:      &http.Client{Timeout: to},
+	}, nil
+}
+
+type openAICompatEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	hc      *http.Client
+}
+
+type openAICompatEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAICompatEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (e *openAICompatEmbedder) EmbedStrings(ctx context.Context, texts []string, _ ...embedding.Option) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	reqBody, _ := json.Marshal(openAICompatEmbeddingRequest{Model: e.model, Input: texts})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAICompatEmbeddingsURL(e.baseURL), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.TrimSpace(e.apiKey) != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("openai_compat embeddings http %d: %s", resp.StatusCode, string(b))
+	}
+
+	var out openAICompatEmbeddingResponse
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("decode embeddings response failed: %w", err)
+	}
+	if out.Error != nil && strings.TrimSpace(out.Error.Message) != "" {
+		return nil, fmt.Errorf("openai_compat embeddings error: %s", out.Error.Message)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("openai_compat embeddings: got %d vectors for %d inputs", len(out.Data), len(texts))
+	}
+
+	sort.Slice(out.Data, func(i, j int) bool { return out.Data[i].Index < out.Data[j].Index })
+	vecs := make([][]float64, len(out.Data))
+	for i, d := range out.Data {
+		vecs[i] = d.Embedding
+	}
+	return vecs, nil
+}
+
+func openAICompatEmbeddingsURL(base string) string {
+	b := strings.TrimRight(base, "/")
+	lb := strings.ToLower(b)
+	if strings.HasSuffix(lb, "/v1") {
+		return b + "/embeddings"
+	}
+	return b + "/v1/embeddings"
+}
@@ -0,0 +1,333 @@
+// Package policy decides, before the AI stage, which pending records are
+// not scored by the model at all: records to skip, to reject outright with
+// a reason, or to hand to a human reviewer.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ActionSkip leaves the record out of the run; nothing is written.
+	ActionSkip = "skip"
+	// ActionReject writes the record with its reason and no score; Submit
+	// does not send it.
+	ActionReject = "reject"
+	// ActionManual writes the record with no score; Submit puts it in the
+	// review queue for a human verdict.
+	ActionManual = "manual"
+)
+
+// Result data fields written for records a policy handled.
+const (
+	FieldID     = "policy_id"
+	FieldAction = "policy_action"
+	FieldReason = "policy_reason"
+)
+
+// Cond is a predicate over a pending record's data. Every condition that is
+// set must hold. Field conditions read Field, a data key or "_raw.<key>"
+// for the raw detail; values that are not strings are compared in their
+// JSON form.
+type Cond struct {
+	Field string `json:"field,omitempty"`
+	// Equals and In compare the trimmed value exactly.
+	Equals *string  `json:"equals,omitempty"`
+	In     []string `json:"in,omitempty"`
+	// Regex is matched case-insensitively.
+	Regex string `json:"regex,omitempty"`
+	// Empty holds when the field is missing or blank (true) or has a value
+	// (false).
+	Empty *bool `json:"empty,omitempty"`
+	// MinLen and MaxLen bound the length of the value in characters.
+	MinLen *int `json:"min_len,omitempty"`
+	MaxLen *int `json:"max_len,omitempty"`
+
+	All []*Cond `json:"all,omitempty"`
+	Any []*Cond `json:"any,omitempty"`
+	Not *Cond   `json:"not,omitempty"`
+
+	// RejectedDuplicate holds when the record is the same finding as one a
+	// reviewer rejected; see Env.
+	RejectedDuplicate bool `json:"rejected_duplicate,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Policy is one entry of the policy file.
+type Policy struct {
+	ID     string `json:"id"`
+	When   *Cond  `json:"when"`
+	Action string `json:"action"`
+	// Reason is written with the record; required for ActionReject.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Set is an ordered list of policies; the first matching policy applies.
+type Set struct {
+	Policies []*Policy `json:"policies"`
+}
+
+// Env supplies what conditions cannot read from the record itself.
+type Env struct {
+	// RejectedDuplicate reports whether data repeats a rejected finding; nil
+	// means no finding was rejected.
+	RejectedDuplicate func(data map[string]any) bool
+}
+
+// Load reads and compiles a policy file. An empty path yields an empty set.
+func Load(path string) (*Set, error) {
+	if strings.TrimSpace(path) == "" {
+		return &Set{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file failed: %w", err)
+	}
+	return Parse(b)
+}
+
+// Parse compiles policies from JSON content.
+func Parse(b []byte) (*Set, error) {
+	var s Set
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("decode policies failed: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, p := range s.Policies {
+		if p == nil {
+			return nil, fmt.Errorf("policy #%d is null", i+1)
+		}
+		if strings.TrimSpace(p.ID) == "" {
+			return nil, fmt.Errorf("policy #%d: missing id", i+1)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("policy %s: duplicate id", p.ID)
+		}
+		seen[p.ID] = true
+		if err := p.compile(); err != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.ID, err)
+		}
+	}
+	return &s, nil
+}
+
+func (p *Policy) compile() error {
+	switch p.Action {
+	case ActionSkip, ActionManual:
+	case ActionReject:
+		if strings.TrimSpace(p.Reason) == "" {
+			return fmt.Errorf("action reject needs a reason")
+		}
+	default:
+		return fmt.Errorf("unknown action %q (want skip, reject or manual)", p.Action)
+	}
+	if p.When == nil {
+		return fmt.Errorf("missing when")
+	}
+	return p.When.compile("when")
+}
+
+func (c *Cond) compile(path string) error {
+	if c == nil {
+		return fmt.Errorf("%s: null condition", path)
+	}
+	fieldConds := c.Equals != nil || len(c.In) > 0 || c.Regex != "" || c.Empty != nil || c.MinLen != nil || c.MaxLen != nil
+	if fieldConds && strings.TrimSpace(c.Field) == "" {
+		return fmt.Errorf("%s: field conditions need a field", path)
+	}
+	if !fieldConds && c.Field != "" {
+		return fmt.Errorf("%s: field %q has no condition", path, c.Field)
+	}
+	if !fieldConds && len(c.All) == 0 && len(c.Any) == 0 && c.Not == nil && !c.RejectedDuplicate {
+		return fmt.Errorf("%s: empty condition", path)
+	}
+	if c.Regex != "" {
+		re, err := regexp.Compile("(?i)" + c.Regex)
+		if err != nil {
+			return fmt.Errorf("%s.regex: %w", path, err)
+		}
+		c.re = re
+	}
+	for i, sub := range c.All {
+		if err := sub.compile(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, sub := range c.Any {
+		if err := sub.compile(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if c.Not != nil {
+		return c.Not.compile(path + ".not")
+	}
+	return nil
+}
+
+// Match returns the first policy whose condition holds for data, or nil.
+func (s *Set) Match(data map[string]any, env Env) *Policy {
+	if s == nil {
+		return nil
+	}
+	for _, p := range s.Policies {
+		if p.When.eval(data, env) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Empty reports whether s has no policies.
+func (s *Set) Empty() bool {
+	return s == nil || len(s.Policies) == 0
+}
+
+// RawFields returns the "_raw.<key>" keys the policies read, which the AI
+// stage must keep of the raw detail.
+func (s *Set) RawFields() []string {
+	var keys []string
+	seen := map[string]bool{}
+	var walk func(c *Cond)
+	walk = func(c *Cond) {
+		if k, ok := strings.CutPrefix(c.Field, "_raw."); ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+		for _, sub := range c.All {
+			walk(sub)
+		}
+		for _, sub := range c.Any {
+			walk(sub)
+		}
+		if c.Not != nil {
+			walk(c.Not)
+		}
+	}
+	if s != nil {
+		for _, p := range s.Policies {
+			walk(p.When)
+		}
+	}
+	return keys
+}
+
+// UsesRejectedDuplicate reports whether any policy has a rejected_duplicate
+// condition, so that the rejected findings need to be loaded.
+func (s *Set) UsesRejectedDuplicate() bool {
+	var walk func(c *Cond) bool
+	walk = func(c *Cond) bool {
+		if c.RejectedDuplicate || (c.Not != nil && walk(c.Not)) {
+			return true
+		}
+		for _, sub := range c.All {
+			if walk(sub) {
+				return true
+			}
+		}
+		for _, sub := range c.Any {
+			if walk(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	if s != nil {
+		for _, p := range s.Policies {
+			if walk(p.When) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Cond) eval(data map[string]any, env Env) bool {
+	if c.Field != "" {
+		v, present := value(data, c.Field)
+		if c.Empty != nil && (v == "") != *c.Empty {
+			return false
+		}
+		if c.Equals != nil && (!present || v != strings.TrimSpace(*c.Equals)) {
+			return false
+		}
+		if len(c.In) > 0 && !oneOf(v, present, c.In) {
+			return false
+		}
+		if c.re != nil && (!present || !c.re.MatchString(v)) {
+			return false
+		}
+		n := utf8.RuneCountInString(v)
+		if c.MinLen != nil && n < *c.MinLen {
+			return false
+		}
+		if c.MaxLen != nil && n > *c.MaxLen {
+			return false
+		}
+	}
+	for _, sub := range c.All {
+		if !sub.eval(data, env) {
+			return false
+		}
+	}
+	if len(c.Any) > 0 {
+		matched := false
+		for _, sub := range c.Any {
+			if sub.eval(data, env) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if c.Not != nil && c.Not.eval(data, env) {
+		return false
+	}
+	if c.RejectedDuplicate && (env.RejectedDuplicate == nil || !env.RejectedDuplicate(data)) {
+		return false
+	}
+	return true
+}
+
+// value returns the trimmed string form of field in data and whether it is
+// set.
+func value(data map[string]any, field string) (string, bool) {
+	var v any
+	var ok bool
+	if k, raw := strings.CutPrefix(field, "_raw."); raw {
+		m, _ := data["_raw"].(map[string]any)
+		v, ok = m[k]
+	} else {
+		v, ok = data[field]
+	}
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, isStr := v.(string); isStr {
+		return strings.TrimSpace(s), true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v), true
+	}
+	return string(b), true
+}
+
+func oneOf(v string, present bool, set []string) bool {
+	if !present {
+		return false
+	}
+	for _, s := range set {
+		if v == strings.TrimSpace(s) {
+			return true
+		}
+	}
+	return false
+}
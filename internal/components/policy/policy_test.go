@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicies = `{
+  "policies": [
+    {"id": "no-req", "when": {"field": "req_pkg", "empty": true}, "action": "skip"},
+    {"id": "blocklist", "when": {"field": "name", "regex": "测试|demo"}, "action": "reject", "reason": "名称在黑名单中"},
+    {"id": "out-of-scope", "when": {"any": [
+      {"field": "_raw.type", "in": ["弱口令", "信息泄露"]},
+      {"all": [{"field": "level", "equals": "1"}, {"not": {"field": "name", "regex": "rce"}}]}
+    ]}, "action": "reject", "reason": "不在范围内"},
+    {"id": "huge", "when": {"field": "resp_pkg", "min_len": 10}, "action": "manual"},
+    {"id": "seen", "when": {"rejected_duplicate": true}, "action": "reject", "reason": "重复的已驳回漏洞"}
+  ]
+}`
+
+func TestSet_Match(t *testing.T) {
+	s, err := Parse([]byte(testPolicies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Env{RejectedDuplicate: func(data map[string]any) bool { return data["name"] == "dup" }}
+	cases := []struct {
+		data map[string]any
+		want string
+	}{
+		{map[string]any{"name": "x", "req_pkg": "  "}, "no-req"},
+		{map[string]any{"name": "DEMO site", "req_pkg": "GET /"}, "blocklist"},
+		{map[string]any{"name": "x", "req_pkg": "GET /", "_raw": map[string]any{"type": "弱口令"}}, "out-of-scope"},
+		{map[string]any{"name": "x", "req_pkg": "GET /", "level": 1.0}, "out-of-scope"},
+		{map[string]any{"name": "RCE", "req_pkg": "GET /", "level": 1.0}, ""},
+		{map[string]any{"name": "x", "req_pkg": "GET /", "resp_pkg": "长度超过十个字符的响应包"}, "huge"},
+		{map[string]any{"name": "x", "req_pkg": "GET /", "resp_pkg": "短"}, ""},
+		{map[string]any{"name": "dup", "req_pkg": "GET /"}, "seen"},
+	}
+	for _, c := range cases {
+		got := ""
+		if p := s.Match(c.data, env); p != nil {
+			got = p.ID
+		}
+		if got != c.want {
+			t.Errorf("Match(%v) = %q, want %q", c.data, got, c.want)
+		}
+	}
+	if p := s.Match(map[string]any{"name": "dup", "req_pkg": "GET /"}, Env{}); p != nil {
+		t.Errorf("without rejected findings nothing is a duplicate, got %s", p.ID)
+	}
+	if got := s.RawFields(); !reflect.DeepEqual(got, []string{"type"}) {
+		t.Errorf("RawFields = %v", got)
+	}
+	if !s.UsesRejectedDuplicate() {
+		t.Error("UsesRejectedDuplicate = false")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, c := range []struct{ json, want string }{
+		{`{"policies":[{"when":{"field":"a","empty":true},"action":"skip"}]}`, "missing id"},
+		{`{"policies":[{"id":"a","when":{"field":"a","empty":true},"action":"drop"}]}`, "unknown action"},
+		{`{"policies":[{"id":"a","when":{"field":"a","empty":true},"action":"reject"}]}`, "needs a reason"},
+		{`{"policies":[{"id":"a","action":"skip"}]}`, "missing when"},
+		{`{"policies":[{"id":"a","when":{"field":"a"},"action":"skip"}]}`, "has no condition"},
+		{`{"policies":[{"id":"a","when":{"regex":"x"},"action":"skip"}]}`, "need a field"},
+		{`{"policies":[{"id":"a","when":{"any":[{"field":"a","regex":"("}]},"action":"skip"}]}`, "when.any[0].regex"},
+		{`{"policies":[{"id":"a","when":{"not":{}},"action":"skip"}]}`, "when.not: empty condition"},
+	} {
+		_, err := Parse([]byte(c.json))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("Parse(%s) = %v, want error containing %q", c.json, err, c.want)
+		}
+	}
+}
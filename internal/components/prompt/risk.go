@@ -2,6 +2,8 @@ package prompt
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -30,17 +32,31 @@ func BuildRiskTemplate(cfg *config.RootConfig) (ChatTemplate, error) {
 	return buildTemplateFromString(templateStr), nil
 }
 
+const tacticTemplate = "你将收到一条 HTTP 漏洞记录的精简上下文，以及候选战术列表。\n" +
+	"你必须从候选列表中选择一个最匹配的战术名称，并输出严格 JSON。\n\n" +
+	"漏洞上下文：\n{context}\n\n" +
+	"候选战术列表（只能从中选择，不要输出数值 ID）：\n{tactic_candidates}\n\n" +
+	"输出格式（严格 JSON，不要 Markdown）：\n" +
+	"{\n" +
+	"  \"tactic_name\": \"<string>\"\n" +
+	"}\n"
+
 func BuildATTCKTacticTemplate() ChatTemplate {
-	return buildTemplateFromString(
-		"你将收到一条 HTTP 漏洞记录的精简上下文，以及候选战术列表。\n" +
-			"你必须从候选列表中选择一个最匹配的战术名称，并输出严格 JSON。\n\n" +
-			"漏洞上下文：\n{context}\n\n" +
-			"候选战术列表（只能从中选择，不要输出数值 ID）：\n{tactic_candidates}\n\n" +
-			"输出格式（严格 JSON，不要 Markdown）：\n" +
-			"{\n" +
-			"  \"tactic_name\": \"<string>\"\n" +
-			"}\n",
-	)
+	return buildTemplateFromString(tacticTemplate)
+}
+
+// TemplateHash is the hex SHA-256 of the tactic and risk template sources,
+// so results can be tied to the exact prompts that produced them.
+func TemplateHash(cfg *config.RootConfig) (string, error) {
+	risk, err := loadPromptTemplate(cfg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(tacticTemplate))
+	h.Write([]byte{0})
+	h.Write([]byte(risk))
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func buildTemplateFromString(templateStr string) ChatTemplate {
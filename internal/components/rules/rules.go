@@ -0,0 +1,259 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	// ActionAssign fills the verdict from the rule and skips the model.
+	ActionAssign = "assign"
+	// ActionHint adds hint text to the prompt context and still calls the model.
+	ActionHint = "hint"
+)
+
+// Match lists the conditions of a rule; all non-empty conditions must hold.
+// Every condition is a regular expression matched case-insensitively.
+type Match struct {
+	Name   string `json:"name"`
+	PocID  string `json:"poc_id"`
+	CVE    string `json:"cve"`
+	ReqPkg string `json:"req_pkg"`
+}
+
+// Assign is the verdict written for ActionAssign. Text fields are
+// text/template strings over the record fields (e.g. {{.name}}, {{.cve}}).
+type Assign struct {
+	RiskScore        int    `json:"risk_score"`
+	LevelID          int    `json:"level_id"`
+	TacticName       string `json:"tactic_name"`
+	TechniqueName    string `json:"technique_name"`
+	SubTechniqueName string `json:"sub_technique_name"`
+	EvalDescription  string `json:"eval_description"`
+	Suggestion       string `json:"suggestion"`
+	ProductFeedback  string `json:"product_feedback"`
+}
+
+// Hint is prepended to the prompt context for ActionHint. TacticName, when
+// set, replaces the tactic selection call.
+type Hint struct {
+	Text       string `json:"text"`
+	TacticName string `json:"tactic_name"`
+}
+
+// Rule is one entry of the rules file.
+type Rule struct {
+	ID     string  `json:"id"`
+	Match  Match   `json:"match"`
+	Action string  `json:"action"`
+	Assign *Assign `json:"assign,omitempty"`
+	Hint   *Hint   `json:"hint,omitempty"`
+
+	name, pocID, cve, reqPkg *regexp.Regexp
+	templates                map[string]*template.Template
+}
+
+// Set is an ordered list of rules; the first matching rule fires.
+type Set struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Load reads and compiles a rules file. An empty path yields an empty set.
+func Load(path string) (*Set, error) {
+	if strings.TrimSpace(path) == "" {
+		return &Set{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules file failed: %w", err)
+	}
+	return Parse(b)
+}
+
+// Parse compiles rules from JSON content.
+func Parse(b []byte) (*Set, error) {
+	var s Set
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("decode rules failed: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, r := range s.Rules {
+		if r == nil {
+			return nil, fmt.Errorf("rule #%d is null", i+1)
+		}
+		if strings.TrimSpace(r.ID) == "" {
+			return nil, fmt.Errorf("rule #%d: missing id", i+1)
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("rule %s: duplicate id", r.ID)
+		}
+		seen[r.ID] = true
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+		}
+	}
+	return &s, nil
+}
+
+func (r *Rule) compile() error {
+	var err error
+	compile := func(field, expr string) *regexp.Regexp {
+		if err != nil || strings.TrimSpace(expr) == "" {
+			return nil
+		}
+		re, cerr := regexp.Compile("(?i)" + expr)
+		if cerr != nil {
+			err = fmt.Errorf("match.%s: %w", field, cerr)
+		}
+		return re
+	}
+	r.name = compile("name", r.Match.Name)
+	r.pocID = compile("poc_id", r.Match.PocID)
+	r.cve = compile("cve", r.Match.CVE)
+	r.reqPkg = compile("req_pkg", r.Match.ReqPkg)
+	if err != nil {
+		return err
+	}
+	if r.name == nil && r.pocID == nil && r.cve == nil && r.reqPkg == nil {
+		return fmt.Errorf("no match conditions")
+	}
+
+	switch r.Action {
+	case ActionAssign:
+		if r.Assign == nil {
+			return fmt.Errorf("action assign needs an assign block")
+		}
+		if r.Assign.RiskScore < 1 || r.Assign.RiskScore > 10 {
+			return fmt.Errorf("assign.risk_score must be 1..10")
+		}
+		r.templates = map[string]*template.Template{}
+		for field, text := range map[string]string{
+			"eval_description": r.Assign.EvalDescription,
+			"suggestion":       r.Assign.Suggestion,
+			"product_feedback": r.Assign.ProductFeedback,
+		} {
+			tmpl, terr := template.New(field).Option("missingkey=error").Parse(text)
+			if terr != nil {
+				return fmt.Errorf("assign.%s: %w", field, terr)
+			}
+			// Render once over empty fields so that a misspelt field fails
+			// here rather than as "<no value>" in submitted text.
+			if terr := tmpl.Execute(io.Discard, templateVars(nil)); terr != nil {
+				return fmt.Errorf("assign.%s: %w", field, terr)
+			}
+			r.templates[field] = tmpl
+		}
+	case ActionHint:
+		if r.Hint == nil || (strings.TrimSpace(r.Hint.Text) == "" && strings.TrimSpace(r.Hint.TacticName) == "") {
+			return fmt.Errorf("action hint needs hint.text or hint.tactic_name")
+		}
+	default:
+		return fmt.Errorf("unknown action %q (want assign or hint)", r.Action)
+	}
+	return nil
+}
+
+// RawFields are the keys of data["_raw"] that Fields reads; the AI stage
+// keeps only these of the raw detail in memory.
+var RawFields = []string{"cve_id", "poc_id"}
+
+// Fields extracts the values rules are matched against from a pending
+// record's data: name, poc_id, cve and req_pkg.
+func Fields(data map[string]any) map[string]string {
+	raw, _ := data["_raw"].(map[string]any)
+	f := map[string]string{
+		"name":    str(data["name"]),
+		"req_pkg": str(data["req_pkg"]),
+		"poc_id":  pocID(data, raw),
+		"cve":     str(raw["cve_id"]),
+	}
+	if f["cve"] == "" {
+		f["cve"] = cvePattern.FindString(f["name"] + " " + str(data["description"]))
+	}
+	return f
+}
+
+var (
+	cvePattern     = regexp.MustCompile(`(?i)CVE-\d{4}-\d{4,}`)
+	pocNamePattern = regexp.MustCompile(`(?m)^\s*name:\s*["']?([\w.\-]+)`)
+)
+
+// pocID returns the xray PoC name ("poc-yaml-...") from the PoC content, or
+// the raw detail's poc_id.
+func pocID(data, raw map[string]any) string {
+	if m := pocNamePattern.FindStringSubmatch(str(data["xray_poc_content"])); len(m) == 2 {
+		return m[1]
+	}
+	return str(raw["poc_id"])
+}
+
+func str(v any) string {
+	if s, ok := v.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return ""
+}
+
+// Match returns the first rule matching data, or nil.
+func (s *Set) Match(data map[string]any) *Rule {
+	if s == nil || len(s.Rules) == 0 {
+		return nil
+	}
+	f := Fields(data)
+	for _, r := range s.Rules {
+		if r.matches(f) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(f map[string]string) bool {
+	check := func(re *regexp.Regexp, v string) bool {
+		return re == nil || (v != "" && re.MatchString(v))
+	}
+	return check(r.name, f["name"]) && check(r.pocID, f["poc_id"]) && check(r.cve, f["cve"]) && check(r.reqPkg, f["req_pkg"])
+}
+
+// templateVars are the fields assign templates can use: Fields plus
+// description.
+func templateVars(data map[string]any) map[string]any {
+	vars := map[string]any{}
+	for k, v := range Fields(data) {
+		vars[k] = v
+	}
+	vars["description"] = str(data["description"])
+	return vars
+}
+
+// Apply writes the rule's verdict into data for ActionAssign: risk_score,
+// level_id, ATT&CK names and the rendered text fields.
+func (r *Rule) Apply(data map[string]any) error {
+	if r.Action != ActionAssign || r.Assign == nil {
+		return fmt.Errorf("rule %s is not an assign rule", r.ID)
+	}
+	vars := templateVars(data)
+	for field, tmpl := range r.templates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, vars); err != nil {
+			return fmt.Errorf("rule %s: render %s: %w", r.ID, field, err)
+		}
+		if buf.Len() > 0 {
+			data[field] = buf.String()
+		}
+	}
+	data["risk_score"] = r.Assign.RiskScore
+	if r.Assign.LevelID > 0 {
+		data["level_id"] = r.Assign.LevelID
+	}
+	data["tactic_name"] = r.Assign.TacticName
+	data["technique_name"] = r.Assign.TechniqueName
+	data["sub_technique_name"] = r.Assign.SubTechniqueName
+	return nil
+}
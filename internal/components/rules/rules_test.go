@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRules = `{
+  "rules": [
+    {
+      "id": "log4j",
+      "match": {"cve": "CVE-2021-44228"},
+      "action": "assign",
+      "assign": {
+        "risk_score": 9,
+        "level_id": 3,
+        "tactic_name": "初始访问",
+        "technique_name": "利用面向公众的应用程序",
+        "eval_description": "{{.name}} 命中 {{.cve}}，已知可远程代码执行。",
+        "suggestion": "升级 log4j 至 2.17.1 以上。"
+      }
+    },
+    {
+      "id": "dir-scan",
+      "match": {"poc_id": "^poc-yaml-dir-", "req_pkg": "GET /"},
+      "action": "hint",
+      "hint": {"text": "目录探测类 PoC，通常为侦察。", "tactic_name": "侦察"}
+    }
+  ]
+}`
+
+func TestParse_Validates(t *testing.T) {
+	cases := map[string]string{
+		"missing id":    `{"rules":[{"match":{"name":"x"},"action":"hint","hint":{"text":"t"}}]}`,
+		"duplicate id":  `{"rules":[{"id":"a","match":{"name":"x"},"action":"hint","hint":{"text":"t"}},{"id":"a","match":{"name":"y"},"action":"hint","hint":{"text":"t"}}]}`,
+		"no conditions": `{"rules":[{"id":"a","match":{},"action":"hint","hint":{"text":"t"}}]}`,
+		"bad regexp":    `{"rules":[{"id":"a","match":{"name":"("},"action":"hint","hint":{"text":"t"}}]}`,
+		"bad action":    `{"rules":[{"id":"a","match":{"name":"x"},"action":"drop"}]}`,
+		"bad score":     `{"rules":[{"id":"a","match":{"name":"x"},"action":"assign","assign":{"risk_score":11}}]}`,
+		"bad template":  `{"rules":[{"id":"a","match":{"name":"x"},"action":"assign","assign":{"risk_score":5,"suggestion":"{{.name"}}]}`,
+		"unknown field": `{"rules":[{"id":"a","match":{"name":"x"},"action":"assign","assign":{"risk_score":5,"suggestion":"{{.nmae}}"}}]}`,
+	}
+	for name, in := range cases {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	set, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	log4j := map[string]any{
+		"name":        "Apache Log4j2 远程代码执行",
+		"description": "JNDI 注入 (cve-2021-44228)",
+	}
+	if r := set.Match(log4j); r == nil || r.ID != "log4j" {
+		t.Fatalf("expected log4j rule from description CVE, got %+v", r)
+	}
+
+	raw := map[string]any{"_raw": map[string]any{"cve_id": "CVE-2021-44228"}}
+	if r := set.Match(raw); r == nil || r.ID != "log4j" {
+		t.Fatalf("expected log4j rule from _raw.cve_id, got %+v", r)
+	}
+
+	dir := map[string]any{
+		"xray_poc_content": "name: poc-yaml-dir-listing\nrules:\n  r0:\n",
+		"req_pkg":          "GET /admin/ HTTP/1.1",
+	}
+	if r := set.Match(dir); r == nil || r.ID != "dir-scan" {
+		t.Fatalf("expected dir-scan rule, got %+v", r)
+	}
+
+	// All conditions must hold.
+	dir["req_pkg"] = "POST /admin/ HTTP/1.1"
+	if r := set.Match(dir); r != nil {
+		t.Fatalf("expected no match, got %s", r.ID)
+	}
+
+	var empty *Set
+	if r := empty.Match(log4j); r != nil {
+		t.Fatalf("nil set matched %s", r.ID)
+	}
+}
+
+func TestApply(t *testing.T) {
+	set, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	data := map[string]any{
+		"name":        "Apache Log4j2 远程代码执行",
+		"description": "CVE-2021-44228",
+		"suggestion":  "keep me?",
+	}
+	r := set.Match(data)
+	if err := r.Apply(data); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if data["risk_score"] != 9 || data["level_id"] != 3 {
+		t.Fatalf("unexpected score/level: %v %v", data["risk_score"], data["level_id"])
+	}
+	if data["technique_name"] != "利用面向公众的应用程序" {
+		t.Fatalf("unexpected technique: %v", data["technique_name"])
+	}
+	if got := data["eval_description"].(string); !strings.Contains(got, "Apache Log4j2") || !strings.Contains(got, "CVE-2021-44228") {
+		t.Fatalf("template not rendered: %q", got)
+	}
+	if data["suggestion"] != "升级 log4j 至 2.17.1 以上。" {
+		t.Fatalf("suggestion not replaced: %v", data["suggestion"])
+	}
+	if _, ok := data["product_feedback"]; ok {
+		t.Fatalf("empty template should not set product_feedback")
+	}
+
+	if err := set.Rules[1].Apply(data); err == nil {
+		t.Fatalf("expected error applying a hint rule")
+	}
+}
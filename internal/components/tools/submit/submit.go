@@ -3,8 +3,10 @@ package submit
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -13,9 +15,16 @@ import (
 	"strings"
 	"time"
 
+	"audit-workflow/internal/components/policy"
 	"audit-workflow/internal/components/tools/taxonomy"
 	"audit-workflow/internal/config"
 	"audit-workflow/internal/httpclient"
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/progress"
+	"audit-workflow/internal/review"
+	"audit-workflow/internal/stop"
+	"audit-workflow/internal/types"
 )
 
 type riskRecord struct {
@@ -29,16 +38,20 @@ func Run(cfg *config.RootConfig) error {
 
 type SubmitOptions struct {
 	Resume bool
+	// Taxonomy is loaded from cfg.ATTCKCSVPath() when nil.
+	Taxonomy *taxonomy.Taxonomy
+	// Stop, when closed, makes RunWithOptions return after the record being
+	// submitted; submitted_ids.jsonl is synced first.
+	Stop <-chan struct{}
 }
 
 func RunWithOptions(cfg *config.RootConfig, opt SubmitOptions) error {
-	taxPath := "ATT&CK.csv"
-	if _, err := os.Stat(taxPath); os.IsNotExist(err) {
-		taxPath = "../ATT&CK.csv"
-	}
-	if err := taxonomy.Load(taxPath); err != nil {
-		fmt.Printf("[Warning] Failed to load taxonomy from %s: %v\n", taxPath, err)
-	}
+	return RunWithContext(context.Background(), cfg, opt)
+}
+
+// RunWithContext is RunWithOptions with platform requests bound to ctx.
+func RunWithContext(ctx context.Context, cfg *config.RootConfig, opt SubmitOptions) error {
+	tax := loadTaxonomy(cfg, opt)
 
 	inputFile := cfg.PendingAuditsResultsPath()
 	f, err := os.Open(inputFile)
@@ -47,40 +60,33 @@ func RunWithOptions(cfg *config.RootConfig, opt SubmitOptions) error {
 	}
 	defer f.Close()
 
-	cl := httpclient.New(cfg.Yuheng.VerifySSL, cfg.Yuheng.TimeoutS)
-
-	fmt.Print("[Login] Authenticating... ")
-	tok, err := login(cl, cfg)
-	if err != nil || tok == "" {
-		fmt.Println("Failed")
-		return err
-	}
-	fmt.Println("OK")
-
-	submittedIDs := map[string]bool{}
-	submittedIDsFile := cfg.SubmittedIDsPath()
-	if err := os.MkdirAll(filepath.Dir(submittedIDsFile), 0o755); err != nil {
+	s, err := newSubmitter(ctx, cfg, opt, tax)
+	if err != nil {
 		return err
 	}
-	if opt.Resume {
-		submittedIDs, err = loadSubmittedIDs(submittedIDsFile)
-		if err != nil {
-			return err
-		}
-	}
-	wSubmitted, err := os.OpenFile(submittedIDsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	defer s.Close()
+	total, err := countLines(inputFile)
 	if err != nil {
 		return err
 	}
-	defer wSubmitted.Close()
+	s.prog = progress.Start(cfg, "submit", total)
 
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
-	success := 0
-	fail := 0
-	total := 0
-
 	for scanner.Scan() {
+		if stop.Requested(opt.Stop) {
+			if err := s.wSubmitted.Sync(); err != nil {
+				return err
+			}
+			if s.wQueue != nil {
+				if err := s.wQueue.Sync(); err != nil {
+					return err
+				}
+			}
+			s.printSummary()
+			s.log.Warn("submit interrupted, rerun with Resume to continue", "scanned", s.total)
+			return nil
+		}
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
@@ -89,118 +95,352 @@ func RunWithOptions(cfg *config.RootConfig, opt SubmitOptions) error {
 		if err := json.Unmarshal([]byte(line), &rec); err != nil {
 			continue
 		}
-		total++
-		if opt.Resume && submittedIDs[strings.TrimSpace(fmt.Sprint(rec.ID))] {
-			continue
-		}
-		data := rec.Data
-		if data == nil {
-			continue
+		s.submit(ctx, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.printSummary()
+	return nil
+}
+
+// Stream submits records from in as they arrive instead of reading
+// pending_audits_results.jsonl; submitted IDs are still appended to
+// submitted_ids.jsonl. It returns when in is closed or ctx is done.
+func Stream(ctx context.Context, cfg *config.RootConfig, opt SubmitOptions, in <-chan types.RiskRecord) error {
+	s, err := newSubmitter(ctx, cfg, opt, loadTaxonomy(cfg, opt))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.prog = progress.Start(cfg, "submit", 0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.printSummary()
+			return ctx.Err()
+		case rec, ok := <-in:
+			if !ok {
+				s.printSummary()
+				return nil
+			}
+			s.prog.AddTotal(1)
+			s.submit(ctx, riskRecord(rec))
 		}
-		rawDetail, _ := data["_raw"].(map[string]any)
-		if rawDetail == nil {
-			fmt.Printf("[Skip] ID %v: Missing raw detail or score\n", rec.ID)
-			continue
+	}
+}
+
+func loadTaxonomy(cfg *config.RootConfig, opt SubmitOptions) *taxonomy.Taxonomy {
+	if opt.Taxonomy != nil {
+		return opt.Taxonomy
+	}
+	taxPath := cfg.ATTCKCSVPath()
+	if taxPath == "" {
+		logging.Stage("submit").Warn("ATT&CK.csv not found, tactics will not be submitted")
+		return nil
+	}
+	tax, err := taxonomy.Load(taxPath)
+	if err != nil {
+		logging.Stage("submit").Warn("load taxonomy failed", "path", taxPath, "error", err)
+		return nil
+	}
+	if aliases, err := taxonomy.LoadAliases(cfg.AI.ATTCK.AliasPath); err != nil {
+		logging.Stage("submit").Warn("load ATT&CK aliases failed", "error", err)
+	} else {
+		tax.SetAliases(aliases)
+	}
+	return tax
+}
+
+// submitter holds the login, submitted-ID and review state shared by
+// RunWithOptions and Stream.
+type submitter struct {
+	log          *slog.Logger
+	cfg          *config.RootConfig
+	opt          SubmitOptions
+	tax          *taxonomy.Taxonomy
+	cl           *httpclient.Client
+	tok          string
+	submittedIDs map[string]bool
+	wSubmitted   *jsonl.Writer
+	prog         *progress.Reporter
+
+	// decisions are the reviewers' decisions by ID; queued maps the IDs in
+	// the review queue to the hash of their held verdict. wQueue is opened
+	// when the first record is held.
+	decisions map[string]review.Decision
+	queued    map[string]string
+	wQueue    *jsonl.Writer
+
+	success, fail, total, held, rejected int
+}
+
+func newSubmitter(ctx context.Context, cfg *config.RootConfig, opt SubmitOptions, tax *taxonomy.Taxonomy) (*submitter, error) {
+	cl := httpclient.New(cfg.Yuheng.VerifySSL, cfg.Yuheng.TimeoutS)
+
+	log := logging.Stage("submit")
+	start := time.Now()
+	tok, err := login(ctx, cl, cfg)
+	if err == nil && tok == "" {
+		err = fmt.Errorf("login returned no access token")
+	}
+	if err != nil {
+		log.Error("login failed", "error", err)
+		return nil, err
+	}
+	log.Info("logged in", logging.KeyDuration, time.Since(start))
+
+	submittedIDs := map[string]bool{}
+	submittedIDsFile := cfg.SubmittedIDsPath()
+	if err := os.MkdirAll(filepath.Dir(submittedIDsFile), 0o755); err != nil {
+		return nil, err
+	}
+	if opt.Resume {
+		submittedIDs, err = loadSubmittedIDs(submittedIDsFile)
+		if err != nil {
+			return nil, err
 		}
-		score := normalizeScore(data["risk_score"])
-		if score < 0 {
-			fmt.Printf("[Skip] ID %v: Missing valid score\n", rec.ID)
-			continue
+	}
+	decisions, err := review.LoadDecisions(cfg.ReviewDecisionsPath())
+	if err != nil {
+		return nil, err
+	}
+	items, err := review.LoadQueue(cfg.ReviewQueuePath())
+	if err != nil {
+		return nil, err
+	}
+	queued := map[string]string{}
+	for _, it := range items {
+		queued[review.Key(it.ID)] = it.Hash
+	}
+	wSubmitted, err := jsonl.Open(submittedIDsFile, true)
+	if err != nil {
+		return nil, err
+	}
+	return &submitter{
+		log:          log,
+		cfg:          cfg,
+		opt:          opt,
+		tax:          tax,
+		cl:           cl,
+		tok:          tok,
+		submittedIDs: submittedIDs,
+		wSubmitted:   wSubmitted,
+		decisions:    decisions,
+		queued:       queued,
+	}, nil
+}
+
+func (s *submitter) Close() error {
+	s.prog.Stop()
+	if s.wQueue != nil {
+		if err := s.wQueue.Close(); err != nil {
+			s.log.Error("close review queue failed", "error", err)
 		}
+	}
+	return s.wSubmitted.Close()
+}
 
-		if v, ok := data["eval_description"]; ok {
-			rawDetail["eval_description"] = v
+// reviewed returns the data to submit for rec: the approved version when a
+// reviewer edited its verdict, or nil when the verdict or a policy rejected
+// it or it is held for review.
+func (s *submitter) reviewed(rec riskRecord) map[string]any {
+	id := review.Key(rec.ID)
+	hash := review.Hash(rec.Data)
+	if d, ok := s.decisions[id]; ok && d.Hash == hash {
+		switch d.Decision {
+		case review.Reject:
+			s.rejected++
+			s.log.Info("skip record rejected in review", logging.KeyRecordID, rec.ID, "reviewer", d.Reviewer, "note", d.Note)
+			return nil
+		case review.Edit:
+			s.log.Info("submit record edited in review", logging.KeyRecordID, rec.ID, "reviewer", d.Reviewer)
+			return d.Data
+		default:
+			return rec.Data
+		}
+	}
+	var reasons []string
+	switch rec.Data[policy.FieldAction] {
+	case policy.ActionReject:
+		s.rejected++
+		s.log.Info("skip record rejected by policy", logging.KeyRecordID, rec.ID,
+			"policy_id", rec.Data[policy.FieldID], "reason", rec.Data[policy.FieldReason])
+		return nil
+	case policy.ActionManual:
+		reasons = []string{review.ReasonPolicy + ":" + fmt.Sprint(rec.Data[policy.FieldID])}
+	default:
+		if !s.cfg.Review.Enabled {
+			return rec.Data
+		}
+		if reasons = review.Reasons(s.cfg.Review, rec.Data); len(reasons) == 0 {
+			return rec.Data
 		}
-		rawDetail["attack_result"] = "成功"
+	}
+	s.held++
+	if s.queued[id] != hash {
+		if err := s.enqueue(review.NewItem(rec.ID, rec.Data, reasons)); err != nil {
+			s.log.Error("write review queue failed", logging.KeyRecordID, rec.ID, "error", err)
+		} else {
+			s.queued[id] = hash
+		}
+	}
+	s.log.Info("record held for review", logging.KeyRecordID, rec.ID, "reasons", strings.Join(reasons, ","))
+	return nil
+}
 
-		if v, ok := data["level_id"]; ok {
-			rawDetail["level_id"] = v
+// enqueue appends it to the review queue, opening the queue on first use.
+func (s *submitter) enqueue(it review.Item) error {
+	if s.wQueue == nil {
+		w, err := jsonl.Open(s.cfg.ReviewQueuePath(), true)
+		if err != nil {
+			return err
 		}
+		s.wQueue = w
+	}
+	b, _ := json.Marshal(it)
+	return s.wQueue.WriteLine(b)
+}
 
-		if v, ok := rawDetail["devices"]; ok {
-			if !isValidDevices(v) {
-				delete(rawDetail, "devices")
-				fmt.Printf("[Warning] ID %v: Invalid devices format in _raw, dropped\n", rec.ID)
-			}
+func (s *submitter) submit(ctx context.Context, rec riskRecord) {
+	s.total++
+	success, fail := s.success, s.fail
+	defer func() {
+		switch {
+		case s.success > success:
+			s.prog.Success()
+		case s.fail > fail:
+			s.prog.Failure()
+		default:
+			s.prog.Skip()
 		}
+	}()
+	if s.opt.Resume && s.submittedIDs[types.RecordKey(rec.ID)] {
+		return
+	}
+	if rec.Data == nil {
+		return
+	}
+	data := s.reviewed(rec)
+	if data == nil {
+		return
+	}
+	rawDetail, _ := data["_raw"].(map[string]any)
+	if rawDetail == nil {
+		s.log.Info("skip record without raw detail", logging.KeyRecordID, rec.ID)
+		return
+	}
+	score := normalizeScore(data["risk_score"])
+	if score < 0 {
+		s.log.Info("skip record without valid score", logging.KeyRecordID, rec.ID)
+		return
+	}
 
-		tName := firstString(data["tactic_name"])
-		teName := firstString(data["technique_name"])
-		subName := firstString(data["sub_technique_name"])
-
-		fmt.Printf("[Analysis] ID %v: AI Suggestion -> Tactic: '%s', Technique: '%s', Sub: '%s'\n", rec.ID, tName, teName, subName)
-
-		if tName != "" {
-			tid, teid, subid, found := taxonomy.LookupIDs(tName, teName, subName)
-			if !found {
-				if tid2, ok := taxonomy.LookupTacticID(tName); ok {
-					tid = tid2
-					teid = 0
-					subid = 0
-					teName = ""
-					subName = ""
-					found = true
-					fmt.Printf("[Warning] ID %v: Technique '%s' not found, falling back to Tactic '%s'\n", rec.ID, firstString(data["technique_name"]), tName)
-				} else {
-					fmt.Printf("[Warning] ID %v: Tactic '%s' not found in taxonomy\n", rec.ID, tName)
-				}
-			}
+	if v, ok := data["eval_description"]; ok {
+		rawDetail["eval_description"] = v
+	}
+	rawDetail["attack_result"] = "成功"
 
-			if found {
-				rawDetail["tactics"] = []map[string]any{{
-					"tactic_id":          tid,
-					"tactic_name":        tName,
-					"technique_id":       teid,
-					"technique_name":     teName,
-					"sub_technique_id":   subid,
-					"sub_technique_name": subName,
-				}}
-			}
-		}
+	if v, ok := data["level_id"]; ok {
+		rawDetail["level_id"] = v
+	}
 
-		if v, ok := data["community_tags"]; ok {
-			rawDetail["community_tags"] = v
-		}
-		if v, ok := data["serial_number"]; ok {
-			rawDetail["serial_number"] = v
-		}
-		if v, ok := data["product_feedback"]; ok {
-			rawDetail["product_feedback"] = v
+	if v, ok := rawDetail["devices"]; ok {
+		if !isValidDevices(v) {
+			delete(rawDetail, "devices")
+			s.log.Warn("invalid devices format in _raw, dropped", logging.KeyRecordID, rec.ID)
 		}
+	}
 
-		suggestion := firstString(data["suggestion"])
-
-		fmt.Printf("[Submit] Processing ID %v (Score: %d)... ", rec.ID, score)
-		if submitReview(cl, cfg, tok, rawDetail, score, suggestion) {
-			fmt.Println("Success")
-			success++
-			id := strings.TrimSpace(fmt.Sprint(rec.ID))
-			if id != "" {
-				submittedIDs[id] = true
-				b, _ := json.Marshal(map[string]any{
-					"id":           rec.ID,
-					"submitted_at": utcISO(),
-				})
-				_, _ = wSubmitted.Write(b)
-				_, _ = wSubmitted.WriteString("\n")
+	tName := firstString(data["tactic_name"])
+	teName := firstString(data["technique_name"])
+	subName := firstString(data["sub_technique_name"])
+
+	matchMethod := ""
+	if tName != "" {
+		if m, ok := s.tax.Resolve(tName, teName, subName); ok {
+			matchMethod = m.Method()
+			if matchMethod != string(taxonomy.MatchExact) {
+				s.log.Warn("ATT&CK selection matched inexactly", logging.KeyRecordID, rec.ID,
+					"suggested_tactic", tName, "suggested_technique", teName, "suggested_sub_technique", subName,
+					"tactic", m.TacticName, "technique", m.TechniqueName, "sub_technique", m.SubTechniqueName, "match_method", matchMethod)
 			}
+			rawDetail["tactics"] = []map[string]any{{
+				"tactic_id":          m.TacticID,
+				"tactic_name":        m.TacticName,
+				"technique_id":       m.TechniqueID,
+				"technique_name":     m.TechniqueName,
+				"sub_technique_id":   m.SubTechniqueID,
+				"sub_technique_name": m.SubTechniqueName,
+			}}
 		} else {
-			fmt.Println("Failed")
-			fail++
+			s.log.Warn("tactic not found in taxonomy", logging.KeyRecordID, rec.ID, "tactic", tName)
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+
+	if v, ok := data["community_tags"]; ok {
+		rawDetail["community_tags"] = v
+	}
+	if v, ok := data["serial_number"]; ok {
+		rawDetail["serial_number"] = v
+	}
+	if v, ok := data["product_feedback"]; ok {
+		rawDetail["product_feedback"] = v
 	}
 
-	if opt.Resume {
-		fmt.Printf("[Info] Found %d records to scan, submitted %d new, failed %d\n", total, success, fail)
+	suggestion := firstString(data["suggestion"])
+
+	start := time.Now()
+	if err := submitReview(ctx, s.cl, s.cfg, s.tok, rawDetail, score, suggestion); err == nil {
+		s.log.Info("record submitted", logging.KeyRecordID, rec.ID, "score", score,
+			"tactic", tName, "technique", teName, "sub_technique", subName, logging.KeyDuration, time.Since(start))
+		s.success++
+		id := types.RecordKey(rec.ID)
+		if id != "" {
+			s.submittedIDs[id] = true
+			b, _ := json.Marshal(map[string]any{
+				"id":           rec.ID,
+				"submitted_at": utcISO(),
+				"match_method": matchMethod,
+			})
+			if err := s.wSubmitted.WriteLine(b); err != nil {
+				s.log.Error("write submitted IDs file failed", logging.KeyRecordID, rec.ID, "error", err)
+			}
+		}
 	} else {
-		fmt.Printf("[Info] Found %d records to process\n", total)
+		s.log.Warn("submit failed", logging.KeyRecordID, rec.ID, "score", score, logging.KeyDuration, time.Since(start), "error", err)
+		s.fail++
 	}
-	fmt.Printf("[Summary] Success: %d, Failed: %d\n", success, fail)
-	return nil
+	time.Sleep(100 * time.Millisecond)
+}
+
+func (s *submitter) printSummary() {
+	s.prog.Stop()
+	s.log.Info("submit done", "records", s.total, "success", s.success, "failed", s.fail,
+		"held_for_review", s.held, "rejected", s.rejected, "resume", s.opt.Resume)
+	if s.held > 0 {
+		s.log.Info("records held for review, run cmd/review and submit again", "queue", s.cfg.ReviewQueuePath())
+	}
+}
+
+// countLines returns the number of non-empty lines in path.
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+			n++
+		}
+	}
+	return n, scanner.Err()
 }
 
 func loadSubmittedIDs(path string) (map[string]bool, error) {
@@ -227,7 +467,7 @@ func loadSubmittedIDs(path string) (map[string]bool, error) {
 		if err := json.Unmarshal([]byte(line), &rec); err != nil {
 			continue
 		}
-		id := strings.TrimSpace(fmt.Sprint(rec.ID))
+		id := types.RecordKey(rec.ID)
 		if id == "" {
 			continue
 		}
@@ -243,14 +483,14 @@ func utcISO() string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
 
-func login(cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
+func login(ctx context.Context, cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
 	fullURL, err := resolveURL(cfg.Yuheng.BaseURL, "/api/login")
 	if err != nil {
 		return "", err
 	}
 	body := map[string]any{"username": cfg.Yuheng.Username, "password": cfg.Yuheng.Password}
 	b, _ := json.Marshal(body)
-	req, _ := http.NewRequest(http.MethodPost, fullURL, bytes.NewReader(b))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	var out struct {
 		Data struct {
@@ -267,11 +507,10 @@ func login(cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
 	return out.Data.AccessToken, nil
 }
 
-func submitReview(cl *httpclient.Client, cfg *config.RootConfig, token string, editData map[string]any, score int, suggestion string) bool {
-	fullURL, err := resolveURL(cfg.Yuheng.BaseURL, fmt.Sprintf("/api/operation_side/lines/%v/review", editData["id"]))
+func submitReview(ctx context.Context, cl *httpclient.Client, cfg *config.RootConfig, token string, editData map[string]any, score int, suggestion string) error {
+	fullURL, err := resolveURL(cfg.Yuheng.BaseURL, "/api/operation_side/lines/"+types.RecordKey(editData["id"])+"/review")
 	if err != nil {
-		fmt.Printf("[Error] Submit failed: %v\n", err)
-		return false
+		return err
 	}
 
 	reviewData := map[string]any{
@@ -340,7 +579,7 @@ func submitReview(cl *httpclient.Client, cfg *config.RootConfig, token string, e
 	}
 
 	b, _ := json.Marshal(reviewData)
-	req, _ := http.NewRequest(http.MethodPut, fullURL, bytes.NewReader(b))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, fullURL, bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Cookie", "AccessToken="+token+";")
@@ -348,14 +587,12 @@ func submitReview(cl *httpclient.Client, cfg *config.RootConfig, token string, e
 	var out map[string]any
 	code, err := cl.DoJSON(req, &out)
 	if err != nil {
-		fmt.Printf("[Error] Submit failed: %v\n", err)
-		return false
+		return err
 	}
 	if code != 200 {
-		fmt.Printf("[Error] Submit failed: status=%d, msg=%v\n", code, out)
-		return false
+		return fmt.Errorf("status=%d, msg=%v", code, out)
 	}
-	return true
+	return nil
 }
 
 func resolveURL(base, ref string) (string, error) {
@@ -0,0 +1,216 @@
+package taxonomy
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// Name fields are repeated in the document so a hit on a technique name
+	// outweighs the same term appearing somewhere in a long description.
+	nameBoost = 3
+	// codeBonus is added when the query mentions the official code (e.g. T1190).
+	codeBonus = 5.0
+)
+
+var englishStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "for": true, "from": true, "has": true,
+	"have": true, "in": true, "into": true, "is": true, "it": true, "may": true,
+	"of": true, "on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "this": true, "to": true, "use": true, "used": true, "with": true,
+}
+
+// tokenize splits text into lowercase English words and Chinese bigrams.
+// A Han run of a single rune is kept as a unigram.
+func tokenize(text string) []string {
+	var out []string
+	var word []rune
+	var han []rune
+
+	flushWord := func() {
+		if len(word) >= 2 {
+			w := string(word)
+			if !englishStopwords[w] {
+				out = append(out, w)
+			}
+		}
+		word = word[:0]
+	}
+	flushHan := func() {
+		switch {
+		case len(han) == 1:
+			out = append(out, string(han))
+		case len(han) > 1:
+			for i := 0; i+1 < len(han); i++ {
+				out = append(out, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return out
+}
+
+type bm25Doc struct {
+	tf  map[string]int
+	len int
+}
+
+// bm25Index scores a fixed set of documents against a query.
+type bm25Index struct {
+	docs   []bm25Doc
+	df     map[string]int
+	avgLen float64
+}
+
+func newBM25Index(texts []string) *bm25Index {
+	idx := &bm25Index{df: make(map[string]int)}
+	total := 0
+	for _, text := range texts {
+		toks := tokenize(text)
+		d := bm25Doc{tf: make(map[string]int), len: len(toks)}
+		for _, tok := range toks {
+			if d.tf[tok] == 0 {
+				idx.df[tok]++
+			}
+			d.tf[tok]++
+		}
+		idx.docs = append(idx.docs, d)
+		total += len(toks)
+	}
+	if len(texts) > 0 {
+		idx.avgLen = float64(total) / float64(len(texts))
+	}
+	return idx
+}
+
+// score returns one BM25 score per document. Each distinct query term is
+// counted once so a long request packet cannot inflate a single term.
+func (idx *bm25Index) score(queryTerms []string) []float64 {
+	if idx == nil || len(idx.docs) == 0 {
+		return nil
+	}
+	scores := make([]float64, len(idx.docs))
+	n := float64(len(idx.docs))
+	seen := map[string]bool{}
+	for _, term := range queryTerms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		df := idx.df[term]
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+		for i, d := range idx.docs {
+			tf := float64(d.tf[term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B
+			if idx.avgLen > 0 {
+				norm += bm25B * float64(d.len) / idx.avgLen
+			}
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// tacticIndex holds the BM25 indexes for the techniques of one tactic.
+// techs[i] is document i of tech; subs[i] holds the sub-technique index of
+// techs[i] (nil when it has none).
+type tacticIndex struct {
+	techs []*techniqueNode
+	tech  *bm25Index
+	subs  []*bm25Index
+}
+
+func buildDocText(names []string, description string) string {
+	var b strings.Builder
+	for i := 0; i < nameBoost; i++ {
+		for _, n := range names {
+			b.WriteString(n)
+			b.WriteString(" ")
+		}
+	}
+	b.WriteString(description)
+	return b.String()
+}
+
+func (t *Taxonomy) buildIndex() {
+	t.index = make(map[string]*tacticIndex, len(t.techByTactic))
+	for tactic, techMap := range t.techByTactic {
+		ti := &tacticIndex{}
+		var techTexts []string
+		for _, tn := range sortedTechniques(techMap) {
+			names := []string{tn.TechniqueName, tn.NameEn}
+			var subTexts []string
+			for _, sub := range tn.Subs {
+				names = append(names, sub.SubTechniqueName, sub.NameEn)
+				subTexts = append(subTexts, buildDocText([]string{sub.SubTechniqueName, sub.NameEn}, sub.Description))
+			}
+			ti.techs = append(ti.techs, tn)
+			techTexts = append(techTexts, buildDocText(names, tn.Description))
+			if len(subTexts) > 0 {
+				ti.subs = append(ti.subs, newBM25Index(subTexts))
+			} else {
+				ti.subs = append(ti.subs, nil)
+			}
+		}
+		ti.tech = newBM25Index(techTexts)
+		t.index[tactic] = ti
+	}
+}
+
+// codeToken finds ATT&CK-looking tokens; queryCodes keeps those that are
+// well-formed codes.
+var (
+	codeToken = regexp.MustCompile(`(?i)\bT\d+(?:\.\d+)*\b`)
+	codeForm  = regexp.MustCompile(`^T\d{4}(?:\.\d{3})?$`)
+)
+
+// queryCodes returns the official codes named in query as whole tokens, so
+// that T1078 does not match inside T10780. A sub-technique code also names
+// its parent technique.
+func queryCodes(query string) map[string]bool {
+	codes := map[string]bool{}
+	for _, tok := range codeToken.FindAllString(query, -1) {
+		tok = strings.ToUpper(tok)
+		if !codeForm.MatchString(tok) {
+			continue
+		}
+		codes[tok] = true
+		if i := strings.IndexByte(tok, '.'); i > 0 {
+			codes[tok[:i]] = true
+		}
+	}
+	return codes
+}
+
+func containsCode(codes map[string]bool, code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return code != "" && codes[code]
+}
@@ -0,0 +1,76 @@
+package taxonomy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("SQL注入 in the Login-Form, 扫")
+	want := []string{"sql", "注入", "login", "form", "扫"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestGenerateTechniqueCandidates_BM25(t *testing.T) {
+	csv := testCSV + `3,初始访问,21,有效账户,,0,Valid Accounts,T1078
+`
+	tax, err := Parse(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	cands := tax.GenerateTechniqueCandidates("侦察", "发现目标存在漏洞，扫描器标记", 5, 5)
+	if len(cands) != 1 || len(cands[0].SubNames) == 0 || cands[0].SubNames[0] != "漏洞扫描" {
+		t.Fatalf("expected 漏洞扫描 ranked first, got %+v", cands)
+	}
+
+	cands = tax.GenerateTechniqueCandidates("初始访问", "Exploit of a public-facing application", 5, 5)
+	if len(cands) != 1 || cands[0].TechniqueName != "利用面向公众的应用程序" {
+		t.Fatalf("expected English name match only, got %+v", cands)
+	}
+
+	cands = tax.GenerateTechniqueCandidates("初始访问", "参考 t1078", 5, 5)
+	if len(cands) != 1 || cands[0].TechniqueName != "有效账户" {
+		t.Fatalf("expected code match, got %+v", cands)
+	}
+
+	cands = tax.GenerateTechniqueCandidates("初始访问", "无关内容", 1, 5)
+	if len(cands) != 1 || cands[0].TechniqueName != "利用面向公众的应用程序" {
+		t.Fatalf("expected fallback to lowest ID, got %+v", cands)
+	}
+}
+
+func TestGenerateTechniqueCandidates_UsesSTIXDescriptions(t *testing.T) {
+	tax, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cands := tax.GenerateTechniqueCandidates("侦察", "reconnaissance", 5, 5); len(cands) != 1 {
+		t.Fatalf("expected fallback candidate, got %+v", cands)
+	}
+
+	b, err := ParseSTIX(strings.NewReader(testSTIX))
+	if err != nil {
+		t.Fatalf("ParseSTIX failed: %v", err)
+	}
+	tax.AttachSTIX(b)
+
+	idx := tax.index["侦察"]
+	if scores := idx.tech.score(tokenize("reconnaissance")); len(scores) != 1 || scores[0] <= 0 {
+		t.Fatalf("expected description term to score, got %v", scores)
+	}
+}
+
+func TestQueryCodes_WholeTokens(t *testing.T) {
+	codes := queryCodes("参考t1078.004与 T1595，忽略 T10780、T1190.0045 和 xT1592")
+	want := map[string]bool{"T1078.004": true, "T1078": true, "T1595": true}
+	if !reflect.DeepEqual(codes, want) {
+		t.Fatalf("queryCodes = %v, want %v", codes, want)
+	}
+	if containsCode(codes, "T1078.001") || !containsCode(codes, " t1595 ") {
+		t.Fatal("containsCode should match only named codes")
+	}
+}
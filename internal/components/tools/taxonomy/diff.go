@@ -0,0 +1,146 @@
+package taxonomy
+
+import (
+	"sort"
+	"strings"
+)
+
+// Change pairs an entry of the old taxonomy with its counterpart in the new one.
+type Change struct {
+	Old Mapping
+	New Mapping
+}
+
+// DiffReport lists row-level differences between two taxonomy versions.
+// A row is one tactic/technique/sub-technique combination of ATT&CK.csv.
+type DiffReport struct {
+	Added   []Mapping
+	Removed []Mapping
+	// Renamed rows keep their IDs (or official code) but changed a name.
+	Renamed []Change
+	// IDChanged rows keep their names but changed at least one ID.
+	IDChanged []Change
+	Unchanged int
+
+	rows    map[string]Mapping
+	tactics map[string]string
+}
+
+// Diff compares two taxonomies row by row. Rows are paired by names first,
+// then by the (tactic_id, technique_id, sub_technique_id) triple, then by
+// official code within the same tactic.
+func Diff(oldTax, newTax *Taxonomy) *DiffReport {
+	d := &DiffReport{rows: map[string]Mapping{}, tactics: map[string]string{}}
+	if oldTax == nil || newTax == nil {
+		return d
+	}
+
+	byIDs := map[[3]int]Mapping{}
+	byCode := map[string]Mapping{}
+	for _, m := range newTax.lookupTable {
+		byIDs[[3]int{m.TacticID, m.TechniqueID, m.SubTechniqueID}] = m
+		if code := strings.ToUpper(m.CodeOfficial); code != "" {
+			byCode[m.TacticName+"|"+code] = m
+		}
+	}
+
+	matched := map[string]bool{}
+	for _, key := range sortedMappingKeys(oldTax.lookupTable) {
+		om := oldTax.lookupTable[key]
+		if nm, ok := newTax.lookupTable[key]; ok {
+			matched[key] = true
+			d.rows[key] = nm
+			if om.TacticID == nm.TacticID && om.TechniqueID == nm.TechniqueID && om.SubTechniqueID == nm.SubTechniqueID {
+				d.Unchanged++
+			} else {
+				d.IDChanged = append(d.IDChanged, Change{Old: om, New: nm})
+			}
+			continue
+		}
+
+		nm, ok := byIDs[[3]int{om.TacticID, om.TechniqueID, om.SubTechniqueID}]
+		if !ok {
+			if code := strings.ToUpper(om.CodeOfficial); code != "" {
+				nm, ok = byCode[om.TacticName+"|"+code]
+			}
+		}
+		// The counterpart must not be claimed already, nor be a row the old
+		// version has under the same names (that row is paired by name).
+		nk := makeKey(nm.TacticName, nm.TechniqueName, nm.SubTechniqueName)
+		_, inOld := oldTax.lookupTable[nk]
+		if ok && !matched[nk] && !inOld {
+			matched[nk] = true
+			d.rows[key] = nm
+			d.Renamed = append(d.Renamed, Change{Old: om, New: nm})
+			continue
+		}
+		d.Removed = append(d.Removed, om)
+	}
+
+	for _, key := range sortedMappingKeys(newTax.lookupTable) {
+		if !matched[key] {
+			d.Added = append(d.Added, newTax.lookupTable[key])
+		}
+	}
+
+	for name, id := range oldTax.tacticMap {
+		if _, ok := newTax.tacticMap[name]; ok {
+			d.tactics[name] = name
+			continue
+		}
+		for newName, newID := range newTax.tacticMap {
+			if newID == id {
+				d.tactics[name] = newName
+				break
+			}
+		}
+	}
+	return d
+}
+
+// Empty reports whether both versions are identical.
+func (d *DiffReport) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0 && len(d.IDChanged) == 0
+}
+
+// Migrate maps names chosen against the old taxonomy onto the new one.
+// A tactic-only selection (technique empty) is mapped through the tactic
+// rename table. ok is false when the entry was removed from the new version.
+func (d *DiffReport) Migrate(tactic, technique, sub string) (Mapping, bool) {
+	tactic, technique, sub = strings.TrimSpace(tactic), strings.TrimSpace(technique), strings.TrimSpace(sub)
+	if technique == "" {
+		name, ok := d.tactics[tactic]
+		if !ok {
+			return Mapping{}, false
+		}
+		return Mapping{TacticName: name}, true
+	}
+	m, ok := d.rows[makeKey(tactic, technique, sub)]
+	return m, ok
+}
+
+// MappingEntry is one line of the generated old→new name mapping.
+type MappingEntry struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// NameMapping returns the old→new name mapping for every row that survives,
+// including unchanged rows, as "tactic|technique|sub" keys.
+func (d *DiffReport) NameMapping() []MappingEntry {
+	out := make([]MappingEntry, 0, len(d.rows))
+	for _, key := range sortedMappingKeys(d.rows) {
+		m := d.rows[key]
+		out = append(out, MappingEntry{Old: key, New: makeKey(m.TacticName, m.TechniqueName, m.SubTechniqueName)})
+	}
+	return out
+}
+
+func sortedMappingKeys(m map[string]Mapping) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+package taxonomy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffAndMigrate(t *testing.T) {
+	oldTax, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse old: %v", err)
+	}
+	newCSV := `tactic_id,tactic_name,technique_id,technique_name,sub_technique_name,sub_technique_id,name_en,code_official
+1,侦察,1,主动扫描,,0,Active Scanning,T1595
+1,侦察,1,主动扫描,漏洞扫描,4,Vulnerability Scanning,T1595.002
+1,侦察,1,主动扫描,扫描IP地址块,2,Scanning IP Blocks,T1595.001
+3,初始访问,20,利用面向公众的应用,,0,Exploit Public-Facing Application,T1190
+3,初始访问,21,有效账户,,0,Valid Accounts,T1078
+`
+	newTax, err := Parse(strings.NewReader(newCSV))
+	if err != nil {
+		t.Fatalf("Parse new: %v", err)
+	}
+
+	d := Diff(oldTax, newTax)
+	if len(d.Added) != 1 || d.Added[0].TechniqueName != "有效账户" {
+		t.Fatalf("unexpected added: %+v", d.Added)
+	}
+	if len(d.Renamed) != 2 || len(d.IDChanged) != 1 || len(d.Removed) != 0 || d.Unchanged != 1 {
+		t.Fatalf("unexpected diff: renamed=%d id=%d removed=%d unchanged=%d", len(d.Renamed), len(d.IDChanged), len(d.Removed), d.Unchanged)
+	}
+
+	in := strings.Join([]string{
+		`{"id":1,"data":{"tactic_name":"侦察","technique_name":"主动扫描","sub_technique_name":"扫描 IP 块","risk_score":7,"req_pkg":"GET /?a=<b>&c=1"}}`,
+		`{"id":2,"data":{"tactic_name":"初始访问","technique_name":"利用面向公众的应用程序","sub_technique_name":""}}`,
+		`{"id":3,"data":{"tactic_name":"侦察","technique_name":"已删除技术","sub_technique_name":""}}`,
+		`{"id":4,"data":{"tactic_name":"侦察","technique_name":"","sub_technique_name":""}}`,
+	}, "\n")
+	var out bytes.Buffer
+	st, err := MigrateResults(strings.NewReader(in), &out, d)
+	if err != nil {
+		t.Fatalf("MigrateResults: %v", err)
+	}
+	if st.Total != 4 || st.Changed != 2 || len(st.Unmapped) != 1 || st.Unmapped[0] != "3" {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	got := out.String()
+	if !strings.Contains(got, `"sub_technique_name":"扫描IP地址块"`) || !strings.Contains(got, `"technique_name":"利用面向公众的应用"`) {
+		t.Fatalf("expected renamed entries, got:\n%s", got)
+	}
+	if !strings.Contains(got, `"risk_score":7`) || !strings.Contains(got, `"req_pkg":"GET /?a=<b>&c=1"`) {
+		t.Fatalf("expected other fields preserved, got:\n%s", got)
+	}
+}
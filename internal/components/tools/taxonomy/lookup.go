@@ -0,0 +1,297 @@
+package taxonomy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// MatchMethod tells how one level (tactic/technique/sub-technique) of a model
+// answer was matched against the taxonomy.
+type MatchMethod string
+
+const (
+	MatchExact      MatchMethod = "exact"
+	MatchNormalized MatchMethod = "normalized"
+	MatchNameEn     MatchMethod = "name_en"
+	MatchCode       MatchMethod = "code"
+	MatchAlias      MatchMethod = "alias"
+	// MatchDropped means a non-empty answer could not be matched and the
+	// level was left empty.
+	MatchDropped MatchMethod = "dropped"
+)
+
+// Match is the result of Resolve: the mapping that will be submitted and the
+// method used per level (empty when the answer left that level empty).
+type Match struct {
+	Mapping
+	Tactic    MatchMethod
+	Technique MatchMethod
+	Sub       MatchMethod
+}
+
+// Method summarises the match for logs and submission records: "exact" when
+// every answered level matched exactly, otherwise the non-exact levels, e.g.
+// "technique=code,sub=dropped".
+func (m Match) Method() string {
+	var parts []string
+	add := func(level string, mm MatchMethod) {
+		if mm != "" && mm != MatchExact {
+			parts = append(parts, level+"="+string(mm))
+		}
+	}
+	add("tactic", m.Tactic)
+	add("technique", m.Technique)
+	add("sub", m.Sub)
+	if len(parts) == 0 {
+		return string(MatchExact)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Aliases maps alternative spellings to canonical CSV names, per level.
+// Keys are compared after normalisation.
+type Aliases struct {
+	Tactics       map[string]string `json:"tactics"`
+	Techniques    map[string]string `json:"techniques"`
+	SubTechniques map[string]string `json:"sub_techniques"`
+}
+
+// LoadAliases reads an alias table JSON file. An empty path yields no aliases.
+func LoadAliases(path string) (*Aliases, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read alias table failed: %w", err)
+	}
+	var a Aliases
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("decode alias table failed: %w", err)
+	}
+	return &a, nil
+}
+
+// SetAliases installs the alias table used by Resolve.
+func (t *Taxonomy) SetAliases(a *Aliases) {
+	if t == nil {
+		return
+	}
+	t.aliases = aliasIndex{}
+	if a == nil {
+		return
+	}
+	t.aliases.tactics = normalizeKeys(a.Tactics)
+	t.aliases.techniques = normalizeKeys(a.Techniques)
+	t.aliases.subs = normalizeKeys(a.SubTechniques)
+}
+
+type aliasIndex struct {
+	tactics    map[string]string
+	techniques map[string]string
+	subs       map[string]string
+}
+
+func normalizeKeys(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if nk := normalizeName(k); nk != "" {
+			out[nk] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// normalizeName folds full-width characters to half-width, lowercases, and
+// drops whitespace and punctuation, so "主动扫描（扫描 IP 块）" and
+// "主动扫描(扫描IP块)" compare equal.
+func normalizeName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == 0x3000:
+			continue
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// Resolve maps a model answer to a taxonomy entry. Each level is tried as an
+// exact name, a normalised name, an alias, an official code (e.g. T1190) and
+// an English name. An unmatched sub-technique falls back to its technique and
+// an unmatched technique to the tactic; ok is false only when the tactic
+// itself cannot be matched.
+func (t *Taxonomy) Resolve(tactic, technique, sub string) (m Match, ok bool) {
+	if t == nil {
+		return Match{}, false
+	}
+	if mp, found := t.GetMapping(tactic, technique, sub); found {
+		m.Mapping = mp
+		m.Tactic = MatchExact
+		if strings.TrimSpace(technique) != "" {
+			m.Technique = MatchExact
+		}
+		if strings.TrimSpace(sub) != "" {
+			m.Sub = MatchExact
+		}
+		return m, true
+	}
+
+	tName, tm := t.resolveTactic(tactic)
+	if tName == "" {
+		return Match{}, false
+	}
+	m.Tactic = tm
+	m.TacticName = tName
+	m.TacticID = t.tacticMap[tName]
+
+	technique, sub = strings.TrimSpace(technique), strings.TrimSpace(sub)
+	if technique == "" {
+		if sub != "" {
+			m.Sub = MatchDropped
+		}
+		return m, true
+	}
+
+	tn, subFromCode, tem := t.resolveTechnique(tName, technique)
+	if tn == nil {
+		m.Technique = MatchDropped
+		if sub != "" {
+			m.Sub = MatchDropped
+		}
+		return m, true
+	}
+	techMapping, found := t.lookupTable[makeKey(tName, tn.TechniqueName, "")]
+	if !found {
+		m.Technique = MatchDropped
+		return m, true
+	}
+	m.Technique = tem
+	m.TechniqueID = techMapping.TechniqueID
+	m.TechniqueName = techMapping.TechniqueName
+	m.NameEn = techMapping.NameEn
+	m.CodeOfficial = techMapping.CodeOfficial
+
+	var sn *subNode
+	var sm MatchMethod
+	switch {
+	case sub != "":
+		sn, sm = t.resolveSub(tn, sub)
+	case subFromCode != nil:
+		// The technique answer was a sub-technique code such as T1595.002.
+		sn, sm = subFromCode, MatchCode
+	}
+	if sn == nil {
+		if sub != "" {
+			m.Sub = MatchDropped
+		}
+		return m, true
+	}
+	if subMapping, found := t.lookupTable[makeKey(tName, tn.TechniqueName, sn.SubTechniqueName)]; found {
+		m.Mapping = subMapping
+		m.Sub = sm
+	} else {
+		m.Sub = MatchDropped
+	}
+	return m, true
+}
+
+func (t *Taxonomy) resolveTactic(name string) (string, MatchMethod) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ""
+	}
+	if _, ok := t.tacticMap[name]; ok {
+		return name, MatchExact
+	}
+	norm := normalizeName(name)
+	for tName := range t.tacticMap {
+		if normalizeName(tName) == norm {
+			return tName, MatchNormalized
+		}
+	}
+	if alias, ok := t.aliases.tactics[norm]; ok {
+		if _, ok := t.tacticMap[alias]; ok {
+			return alias, MatchAlias
+		}
+	}
+	return "", ""
+}
+
+// resolveTechnique returns the technique node within tactic. When name is a
+// sub-technique code, the parent technique is returned together with the sub.
+func (t *Taxonomy) resolveTechnique(tactic, name string) (*techniqueNode, *subNode, MatchMethod) {
+	techMap := t.techByTactic[tactic]
+	if tn, ok := techMap[name]; ok {
+		return tn, nil, MatchExact
+	}
+	norm := normalizeName(name)
+	if norm == "" {
+		return nil, nil, ""
+	}
+	for _, tn := range sortedTechniques(techMap) {
+		if normalizeName(tn.TechniqueName) == norm {
+			return tn, nil, MatchNormalized
+		}
+	}
+	if alias, ok := t.aliases.techniques[norm]; ok {
+		if tn, ok := techMap[alias]; ok {
+			return tn, nil, MatchAlias
+		}
+	}
+	for _, tn := range sortedTechniques(techMap) {
+		if tn.CodeOfficial != "" && normalizeName(tn.CodeOfficial) == norm {
+			return tn, nil, MatchCode
+		}
+		for i := range tn.Subs {
+			if tn.Subs[i].CodeOfficial != "" && normalizeName(tn.Subs[i].CodeOfficial) == norm {
+				return tn, &tn.Subs[i], MatchCode
+			}
+		}
+	}
+	for _, tn := range sortedTechniques(techMap) {
+		if tn.NameEn != "" && normalizeName(tn.NameEn) == norm {
+			return tn, nil, MatchNameEn
+		}
+	}
+	return nil, nil, ""
+}
+
+func (t *Taxonomy) resolveSub(tn *techniqueNode, name string) (*subNode, MatchMethod) {
+	if i, ok := tn.subIdx[name]; ok {
+		return &tn.Subs[i], MatchExact
+	}
+	norm := normalizeName(name)
+	if norm == "" {
+		return nil, ""
+	}
+	for i := range tn.Subs {
+		if normalizeName(tn.Subs[i].SubTechniqueName) == norm {
+			return &tn.Subs[i], MatchNormalized
+		}
+	}
+	if alias, ok := t.aliases.subs[norm]; ok {
+		if i, ok := tn.subIdx[alias]; ok {
+			return &tn.Subs[i], MatchAlias
+		}
+	}
+	for i := range tn.Subs {
+		if tn.Subs[i].CodeOfficial != "" && normalizeName(tn.Subs[i].CodeOfficial) == norm {
+			return &tn.Subs[i], MatchCode
+		}
+	}
+	for i := range tn.Subs {
+		if tn.Subs[i].NameEn != "" && normalizeName(tn.Subs[i].NameEn) == norm {
+			return &tn.Subs[i], MatchNameEn
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,56 @@
+package taxonomy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	if a, b := normalizeName("主动扫描（扫描 IP 块）"), normalizeName("主动扫描(扫描IP块) "); a != b {
+		t.Fatalf("expected equal, got %q vs %q", a, b)
+	}
+	if got := normalizeName("ＴＡ　１"); got != "ta1" {
+		t.Fatalf("expected full-width folding, got %q", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tax, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	tax.SetAliases(&Aliases{
+		Tactics:    map[string]string{"Initial Access": "初始访问"},
+		Techniques: map[string]string{"SQL 注入": "利用面向公众的应用程序"},
+	})
+
+	cases := []struct {
+		tactic, technique, sub string
+		wantTech, wantSub      int
+		wantMethod             string
+	}{
+		{"侦察", "主动扫描", "扫描 IP 块", 1, 2, "exact"},
+		{"侦察", "主动扫描 ", "扫描 IP 块", 1, 2, "exact"},
+		{"侦察", "主动扫描", "扫描IP块", 1, 2, "sub=normalized"},
+		{"侦察", "主动扫描", "扫描ＩＰ块", 1, 2, "sub=normalized"},
+		{"侦察", "T1595", "Vulnerability Scanning", 1, 3, "technique=code,sub=name_en"},
+		{"侦察", "T1595.002", "", 1, 3, "technique=code,sub=code"},
+		{"侦察", "active scanning", "不存在", 1, 0, "technique=name_en,sub=dropped"},
+		{"initial access", "SQL注入", "", 20, 0, "tactic=alias,technique=alias"},
+		{"初始访问", "T1190", "", 20, 0, "technique=code"},
+		{"初始访问", "未知技术", "", 0, 0, "technique=dropped"},
+	}
+	for _, c := range cases {
+		m, ok := tax.Resolve(c.tactic, c.technique, c.sub)
+		if !ok {
+			t.Fatalf("%v: expected match", c)
+		}
+		if m.TechniqueID != c.wantTech || m.SubTechniqueID != c.wantSub || m.Method() != c.wantMethod {
+			t.Fatalf("%v: got tech=%d sub=%d method=%q", c, m.TechniqueID, m.SubTechniqueID, m.Method())
+		}
+	}
+
+	if _, ok := tax.Resolve("不存在", "主动扫描", ""); ok {
+		t.Fatalf("expected unknown tactic to fail")
+	}
+}
@@ -0,0 +1,121 @@
+package taxonomy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MigrationStats summarises a MigrateResults run.
+type MigrationStats struct {
+	Total   int
+	Changed int
+	// Unmapped holds IDs whose selection no longer exists in the new version.
+	// Those records are written unchanged.
+	Unmapped []string
+}
+
+// MigrateResults rewrites tactic_name/technique_name/sub_technique_name in
+// each pending_audits_results.jsonl record from r according to d and writes
+// the records to w. Other fields are passed through; numbers keep their
+// original representation.
+func MigrateResults(r io.Reader, w io.Writer, d *DiffReport) (MigrationStats, error) {
+	var st MigrationStats
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	bw := bufio.NewWriter(w)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		out, changed, unmappedID, err := migrateLine(line, d)
+		if err != nil {
+			// Keep lines we cannot parse exactly as they were.
+			out = line
+		}
+		st.Total++
+		if changed {
+			st.Changed++
+		}
+		if unmappedID != "" {
+			st.Unmapped = append(st.Unmapped, unmappedID)
+		}
+		if _, err := bw.Write(out); err != nil {
+			return st, err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return st, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return st, err
+	}
+	return st, bw.Flush()
+}
+
+func migrateLine(line []byte, d *DiffReport) (out []byte, changed bool, unmappedID string, err error) {
+	var rec map[string]json.RawMessage
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, false, "", err
+	}
+	rawData, ok := rec["data"]
+	if !ok {
+		return line, false, "", nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(rawData))
+	dec.UseNumber()
+	var data map[string]any
+	if err := dec.Decode(&data); err != nil || data == nil {
+		return line, false, "", nil
+	}
+
+	str := func(k string) string {
+		s, _ := data[k].(string)
+		return strings.TrimSpace(s)
+	}
+	tName, teName, subName := str("tactic_name"), str("technique_name"), str("sub_technique_name")
+	if tName == "" {
+		return line, false, "", nil
+	}
+
+	m, ok := d.Migrate(tName, teName, subName)
+	if !ok {
+		var id any
+		_ = json.Unmarshal(rec["id"], &id)
+		return line, false, fmt.Sprint(id), nil
+	}
+	if m.TacticName == tName && m.TechniqueName == teName && m.SubTechniqueName == subName {
+		return line, false, "", nil
+	}
+
+	data["tactic_name"] = m.TacticName
+	data["technique_name"] = m.TechniqueName
+	data["sub_technique_name"] = m.SubTechniqueName
+	b, err := marshalRaw(data)
+	if err != nil {
+		return nil, false, "", err
+	}
+	rec["data"] = b
+	out, err = marshalRaw(rec)
+	if err != nil {
+		return nil, false, "", err
+	}
+	return out, true, "", nil
+}
+
+// marshalRaw encodes v without escaping <, > and &, so that request and
+// response packets in migrated records keep their original text.
+func marshalRaw(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
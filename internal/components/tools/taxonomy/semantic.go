@@ -0,0 +1,209 @@
+package taxonomy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/eino/components/embedding"
+)
+
+const embedBatchSize = 32
+
+// VectorIndexOptions configures BuildVectorIndex.
+type VectorIndexOptions struct {
+	// Model is recorded in the index file; changing it re-embeds everything.
+	Model string
+	// Path is the local index file, usually next to ATT&CK.csv.
+	Path string
+	// Weight is the share of cosine similarity in the blended score (0..1).
+	Weight float64
+}
+
+type vectorIndexFile struct {
+	Model string            `json:"model"`
+	Items []vectorIndexItem `json:"items"`
+}
+
+type vectorIndexItem struct {
+	Technique string    `json:"technique"`
+	TextHash  string    `json:"text_hash"`
+	Vector    []float64 `json:"vector"`
+}
+
+// BuildVectorIndex embeds one document per technique (names, sub-technique
+// names and description) and keeps the vectors for
+// GenerateTechniqueCandidatesWithVector. Vectors whose text and model are
+// unchanged are reused from opt.Path; the file is rewritten when anything was
+// embedded. It returns the number of techniques embedded by this call.
+func (t *Taxonomy) BuildVectorIndex(ctx context.Context, emb embedding.Embedder, opt VectorIndexOptions) (int, error) {
+	if t == nil || emb == nil {
+		return 0, nil
+	}
+
+	docs := t.techniqueDocs()
+	names := make([]string, 0, len(docs))
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cached := map[string]vectorIndexItem{}
+	if prev, err := readVectorIndex(opt.Path); err != nil {
+		return 0, err
+	} else if prev != nil && prev.Model == opt.Model {
+		for _, it := range prev.Items {
+			cached[it.Technique] = it
+		}
+	}
+
+	items := make([]vectorIndexItem, 0, len(names))
+	var missing []int
+	for _, name := range names {
+		h := textHash(docs[name])
+		if it, ok := cached[name]; ok && it.TextHash == h && len(it.Vector) > 0 {
+			items = append(items, it)
+			continue
+		}
+		items = append(items, vectorIndexItem{Technique: name, TextHash: h})
+		missing = append(missing, len(items)-1)
+	}
+
+	for start := 0; start < len(missing); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch := missing[start:end]
+		texts := make([]string, len(batch))
+		for i, idx := range batch {
+			texts[i] = docs[items[idx].Technique]
+		}
+		vecs, err := emb.EmbedStrings(ctx, texts)
+		if err != nil {
+			return start, fmt.Errorf("embed techniques failed: %w", err)
+		}
+		if len(vecs) != len(batch) {
+			return start, fmt.Errorf("embed techniques: got %d vectors for %d texts", len(vecs), len(batch))
+		}
+		for i, idx := range batch {
+			items[idx].Vector = vecs[i]
+		}
+	}
+
+	if len(missing) > 0 || len(cached) != len(items) {
+		if err := writeVectorIndex(opt.Path, vectorIndexFile{Model: opt.Model, Items: items}); err != nil {
+			return len(missing), err
+		}
+	}
+
+	t.vectors = make(map[string][]float64, len(items))
+	for _, it := range items {
+		if v := normalizeVector(it.Vector); v != nil {
+			t.vectors[it.Technique] = v
+		}
+	}
+	t.semanticWeight = opt.Weight
+	return len(missing), nil
+}
+
+// techniqueDocs returns the embedding text per technique name. A technique
+// listed under several tactics shares one document.
+func (t *Taxonomy) techniqueDocs() map[string]string {
+	docs := map[string]string{}
+	for _, techMap := range t.techByTactic {
+		for name, tn := range techMap {
+			if _, ok := docs[name]; ok {
+				continue
+			}
+			parts := []string{tn.TechniqueName}
+			if tn.NameEn != "" {
+				parts = append(parts, tn.NameEn)
+			}
+			for _, sub := range tn.Subs {
+				parts = append(parts, sub.SubTechniqueName)
+			}
+			if tn.Description != "" {
+				parts = append(parts, tn.Description)
+			}
+			docs[name] = strings.Join(parts, "\n")
+		}
+	}
+	return docs
+}
+
+func readVectorIndex(path string) (*vectorIndexFile, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read vector index failed: %w", err)
+	}
+	var f vectorIndexFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		// A corrupt cache is rebuilt rather than treated as fatal.
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func writeVectorIndex(path string, f vectorIndexFile) error {
+	if strings.TrimSpace(path) == "" {
+		return nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write vector index failed: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func textHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeVector(v []float64) []float64 {
+	var n float64
+	for _, x := range v {
+		n += x * x
+	}
+	if n == 0 {
+		return nil
+	}
+	n = math.Sqrt(n)
+	out := make([]float64, len(v))
+	for i, x := range v {
+		out[i] = x / n
+	}
+	return out
+}
+
+func dot(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var s float64
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
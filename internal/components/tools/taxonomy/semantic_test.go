@@ -0,0 +1,89 @@
+package taxonomy
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/embedding"
+)
+
+// keywordEmbedder maps text onto fixed keyword axes so similarity is predictable.
+type keywordEmbedder struct {
+	calls int
+	texts int
+}
+
+var keywordAxes = []string{"扫描", "公众", "sql"}
+
+func (e *keywordEmbedder) EmbedStrings(_ context.Context, texts []string, _ ...embedding.Option) ([][]float64, error) {
+	e.calls++
+	e.texts += len(texts)
+	out := make([][]float64, len(texts))
+	for i, text := range texts {
+		v := make([]float64, len(keywordAxes)+1)
+		v[len(keywordAxes)] = 0.1
+		for j, kw := range keywordAxes {
+			if strings.Contains(strings.ToLower(text), kw) {
+				v[j] = 1
+			}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func TestBuildVectorIndex_ReusesIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attck.embeddings.json")
+	opt := VectorIndexOptions{Model: "m1", Path: path, Weight: 0.5}
+
+	tax, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	emb := &keywordEmbedder{}
+	n, err := tax.BuildVectorIndex(context.Background(), emb, opt)
+	if err != nil || n != 2 {
+		t.Fatalf("first build: n=%d err=%v", n, err)
+	}
+
+	tax2, _ := Parse(strings.NewReader(testCSV))
+	emb2 := &keywordEmbedder{}
+	if n, err := tax2.BuildVectorIndex(context.Background(), emb2, opt); err != nil || n != 0 || emb2.calls != 0 {
+		t.Fatalf("expected cached index, got n=%d calls=%d err=%v", n, emb2.calls, err)
+	}
+
+	opt.Model = "m2"
+	if n, err := tax2.BuildVectorIndex(context.Background(), emb2, opt); err != nil || n != 2 {
+		t.Fatalf("expected re-embed on model change, got n=%d err=%v", n, err)
+	}
+}
+
+func TestGenerateTechniqueCandidatesWithVector_BlendsSemantic(t *testing.T) {
+	csv := testCSV + `3,初始访问,21,有效账户,,0,Valid Accounts,T1078
+`
+	tax, err := Parse(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	emb := &keywordEmbedder{}
+	if _, err := tax.BuildVectorIndex(context.Background(), emb, VectorIndexOptions{Weight: 0.5}); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	// No lexical overlap with either technique; only the embedding connects
+	// the query to 利用面向公众的应用程序.
+	query := "SQL注入 id=1' or 1=1"
+	vecs, _ := emb.EmbedStrings(context.Background(), []string{"面向公众"})
+	cands := tax.GenerateTechniqueCandidatesWithVector("初始访问", query, vecs[0], 1, 5)
+	if len(cands) != 1 || cands[0].TechniqueName != "利用面向公众的应用程序" {
+		t.Fatalf("expected semantic match, got %+v", cands)
+	}
+
+	lexical := tax.GenerateTechniqueCandidates("初始访问", "有效账户 登录", 1, 5)
+	blended := tax.GenerateTechniqueCandidatesWithVector("初始访问", "有效账户 登录", vecs[0], 1, 5)
+	if lexical[0].TechniqueName != "有效账户" || blended[0].TechniqueName != "有效账户" {
+		t.Fatalf("expected strong lexical match to survive blending, got %+v / %+v", lexical, blended)
+	}
+}
@@ -0,0 +1,285 @@
+package taxonomy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// STIXTactic is an x-mitre-tactic object from the MITRE ATT&CK STIX bundle.
+type STIXTactic struct {
+	ID          string
+	Name        string
+	ShortName   string
+	Description string
+}
+
+// STIXTechnique is an attack-pattern object (technique or sub-technique)
+// from the MITRE ATT&CK STIX bundle.
+type STIXTechnique struct {
+	ID             string
+	Name           string
+	Description    string
+	Platforms      []string
+	Tactics        []string
+	IsSubtechnique bool
+	ParentID       string
+	Deprecated     bool
+}
+
+// STIXBundle is the subset of enterprise-attack.json used by the workflow.
+type STIXBundle struct {
+	Tactics    []STIXTactic
+	Techniques map[string]*STIXTechnique
+}
+
+type stixObject struct {
+	Type               string   `json:"type"`
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	ShortName          string   `json:"x_mitre_shortname"`
+	Platforms          []string `json:"x_mitre_platforms"`
+	IsSubtechnique     bool     `json:"x_mitre_is_subtechnique"`
+	Deprecated         bool     `json:"x_mitre_deprecated"`
+	Revoked            bool     `json:"revoked"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+	KillChainPhases []struct {
+		KillChainName string `json:"kill_chain_name"`
+		PhaseName     string `json:"phase_name"`
+	} `json:"kill_chain_phases"`
+}
+
+func (o stixObject) attackID() string {
+	for _, ref := range o.ExternalReferences {
+		if ref.SourceName == "mitre-attack" {
+			return strings.TrimSpace(ref.ExternalID)
+		}
+	}
+	return ""
+}
+
+// LoadSTIX reads an offline enterprise-attack.json STIX bundle.
+func LoadSTIX(path string) (*STIXBundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open stix bundle failed: %w", err)
+	}
+	defer f.Close()
+	return ParseSTIX(f)
+}
+
+// ParseSTIX reads tactics and techniques from STIX bundle JSON.
+// Revoked objects are dropped; deprecated ones are kept and flagged.
+func ParseSTIX(r io.Reader) (*STIXBundle, error) {
+	var raw struct {
+		Type    string       `json:"type"`
+		Objects []stixObject `json:"objects"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode stix bundle failed: %w", err)
+	}
+	if raw.Type != "bundle" {
+		return nil, fmt.Errorf("unexpected stix type %q (want bundle)", raw.Type)
+	}
+
+	b := &STIXBundle{Techniques: make(map[string]*STIXTechnique)}
+	for _, o := range raw.Objects {
+		if o.Revoked {
+			continue
+		}
+		id := o.attackID()
+		if id == "" {
+			continue
+		}
+		switch o.Type {
+		case "x-mitre-tactic":
+			b.Tactics = append(b.Tactics, STIXTactic{
+				ID:          id,
+				Name:        strings.TrimSpace(o.Name),
+				ShortName:   strings.TrimSpace(o.ShortName),
+				Description: strings.TrimSpace(o.Description),
+			})
+		case "attack-pattern":
+			tech := &STIXTechnique{
+				ID:             id,
+				Name:           strings.TrimSpace(o.Name),
+				Description:    strings.TrimSpace(o.Description),
+				Platforms:      o.Platforms,
+				IsSubtechnique: o.IsSubtechnique,
+				Deprecated:     o.Deprecated,
+			}
+			if i := strings.IndexByte(id, '.'); i > 0 {
+				tech.IsSubtechnique = true
+				tech.ParentID = id[:i]
+			}
+			for _, p := range o.KillChainPhases {
+				if p.KillChainName == "mitre-attack" {
+					tech.Tactics = append(tech.Tactics, p.PhaseName)
+				}
+			}
+			b.Techniques[id] = tech
+		}
+	}
+	sort.Slice(b.Tactics, func(i, j int) bool { return b.Tactics[i].ID < b.Tactics[j].ID })
+	return b, nil
+}
+
+// JoinReport lists the differences found while joining the CSV with STIX.
+type JoinReport struct {
+	// Matched is the number of distinct ATT&CK codes found in both sources.
+	Matched int
+	// OnlyInSTIX holds active STIX technique IDs with no CSV row.
+	OnlyInSTIX []string
+	// OnlyInCSV holds CSV code_official values with no STIX technique.
+	OnlyInCSV []string
+	// DeprecatedInCSV holds CSV codes that MITRE marks as deprecated.
+	DeprecatedInCSV []string
+	// MissingCode counts CSV techniques and sub-techniques without
+	// code_official, each once however many tactics list it.
+	MissingCode int
+	// TacticMismatches describes CSV techniques filed under a tactic that
+	// STIX does not list for them. A CSV tactic stands for the STIX tactic
+	// most of its techniques belong to.
+	TacticMismatches []string
+}
+
+// Empty reports whether both sources agree.
+func (r JoinReport) Empty() bool {
+	return len(r.OnlyInSTIX) == 0 && len(r.OnlyInCSV) == 0 && len(r.DeprecatedInCSV) == 0 && r.MissingCode == 0 &&
+		len(r.TacticMismatches) == 0
+}
+
+// AttachSTIX joins b into t by ATT&CK code: matching technique and
+// sub-technique nodes receive the MITRE description, platforms and
+// deprecation flag, while names and platform IDs stay from the CSV.
+func (t *Taxonomy) AttachSTIX(b *STIXBundle) JoinReport {
+	var rep JoinReport
+	if t == nil || b == nil {
+		return rep
+	}
+
+	seen := map[string]bool{}
+	onlyCSV := map[string]bool{}
+	deprecated := map[string]bool{}
+	missing := map[string]bool{}
+	attach := func(code, name string) (*STIXTechnique, bool) {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			missing[name] = true
+			return nil, false
+		}
+		st, ok := b.Techniques[code]
+		if !ok {
+			onlyCSV[code] = true
+			return nil, false
+		}
+		seen[code] = true
+		if st.Deprecated {
+			deprecated[code] = true
+		}
+		return st, true
+	}
+
+	for _, techMap := range t.techByTactic {
+		for _, tn := range techMap {
+			if st, ok := attach(tn.CodeOfficial, tn.TechniqueName); ok {
+				tn.Description = st.Description
+				tn.Platforms = st.Platforms
+				tn.Deprecated = st.Deprecated
+			}
+			for i := range tn.Subs {
+				sub := &tn.Subs[i]
+				if st, ok := attach(sub.CodeOfficial, tn.TechniqueName+"/"+sub.SubTechniqueName); ok {
+					sub.Description = st.Description
+					sub.Platforms = st.Platforms
+					sub.Deprecated = st.Deprecated
+				}
+			}
+		}
+	}
+
+	for id, st := range b.Techniques {
+		if !seen[id] && !st.Deprecated {
+			rep.OnlyInSTIX = append(rep.OnlyInSTIX, id)
+		}
+	}
+	// Descriptions feed the BM25 documents, so rebuild with the new text.
+	t.buildIndex()
+
+	rep.Matched = len(seen)
+	rep.MissingCode = len(missing)
+	rep.TacticMismatches = tacticMismatches(t, b)
+	rep.OnlyInCSV = sortedKeys(onlyCSV)
+	rep.DeprecatedInCSV = sortedKeys(deprecated)
+	sort.Strings(rep.OnlyInSTIX)
+	return rep
+}
+
+// tacticMismatches checks the tactic of every CSV technique against the
+// kill chain phases STIX gives it. CSV tactics carry no ATT&CK code, so each
+// is paired with the phase most of its techniques have in STIX.
+func tacticMismatches(t *Taxonomy, b *STIXBundle) []string {
+	label := map[string]string{}
+	for _, ta := range b.Tactics {
+		label[ta.ShortName] = ta.ID + " " + ta.Name
+	}
+	name := func(phase string) string {
+		if l, ok := label[phase]; ok {
+			return l
+		}
+		return phase
+	}
+	stix := func(tn *techniqueNode) *STIXTechnique {
+		return b.Techniques[strings.ToUpper(strings.TrimSpace(tn.CodeOfficial))]
+	}
+
+	var out []string
+	for tactic, techMap := range t.techByTactic {
+		votes := map[string]int{}
+		for _, tn := range techMap {
+			if st := stix(tn); st != nil {
+				for _, p := range st.Tactics {
+					votes[p]++
+				}
+			}
+		}
+		phase := ""
+		for p, n := range votes {
+			if n > votes[phase] || (n == votes[phase] && p < phase) {
+				phase = p
+			}
+		}
+		if phase == "" {
+			continue
+		}
+		for _, tn := range techMap {
+			st := stix(tn)
+			if st == nil || len(st.Tactics) == 0 || slices.Contains(st.Tactics, phase) {
+				continue
+			}
+			names := make([]string, len(st.Tactics))
+			for i, p := range st.Tactics {
+				names[i] = name(p)
+			}
+			out = append(out, fmt.Sprintf("%s %s under %s (%s); STIX: %s", st.ID, tn.TechniqueName, tactic, name(phase), strings.Join(names, ", ")))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
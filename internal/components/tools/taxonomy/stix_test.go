@@ -0,0 +1,114 @@
+package taxonomy
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSTIX = `{
+  "type": "bundle",
+  "objects": [
+    {"type": "x-mitre-tactic", "name": "Reconnaissance", "x_mitre_shortname": "reconnaissance",
+     "external_references": [{"source_name": "mitre-attack", "external_id": "TA0043"}]},
+    {"type": "attack-pattern", "name": "Active Scanning", "description": "Adversaries may execute active reconnaissance scans.",
+     "x_mitre_platforms": ["PRE"], "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "reconnaissance"}],
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1595"}]},
+    {"type": "attack-pattern", "name": "Scanning IP Blocks", "x_mitre_is_subtechnique": true,
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1595.001"}]},
+    {"type": "attack-pattern", "name": "Vulnerability Scanning", "x_mitre_deprecated": true,
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1595.002"}]},
+    {"type": "attack-pattern", "name": "Wordlist Scanning",
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1595.003"}]},
+    {"type": "attack-pattern", "name": "Old Technique", "revoked": true,
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T9999"}]}
+  ]
+}`
+
+func TestParseSTIX(t *testing.T) {
+	b, err := ParseSTIX(strings.NewReader(testSTIX))
+	if err != nil {
+		t.Fatalf("ParseSTIX failed: %v", err)
+	}
+	if len(b.Tactics) != 1 || b.Tactics[0].ID != "TA0043" || b.Tactics[0].ShortName != "reconnaissance" {
+		t.Fatalf("unexpected tactics: %+v", b.Tactics)
+	}
+	if _, ok := b.Techniques["T9999"]; ok {
+		t.Fatalf("expected revoked technique to be dropped")
+	}
+	sub := b.Techniques["T1595.001"]
+	if sub == nil || !sub.IsSubtechnique || sub.ParentID != "T1595" {
+		t.Fatalf("unexpected sub-technique: %+v", sub)
+	}
+	if got := b.Techniques["T1595"].Tactics; len(got) != 1 || got[0] != "reconnaissance" {
+		t.Fatalf("unexpected kill chain phases: %v", got)
+	}
+}
+
+func TestAttachSTIX_Report(t *testing.T) {
+	tax, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	b, err := ParseSTIX(strings.NewReader(testSTIX))
+	if err != nil {
+		t.Fatalf("ParseSTIX failed: %v", err)
+	}
+
+	rep := tax.AttachSTIX(b)
+	if rep.Matched != 3 {
+		t.Fatalf("expected 3 matched codes, got %d", rep.Matched)
+	}
+	if len(rep.OnlyInSTIX) != 1 || rep.OnlyInSTIX[0] != "T1595.003" {
+		t.Fatalf("unexpected OnlyInSTIX: %v", rep.OnlyInSTIX)
+	}
+	if len(rep.OnlyInCSV) != 1 || rep.OnlyInCSV[0] != "T1190" {
+		t.Fatalf("unexpected OnlyInCSV: %v", rep.OnlyInCSV)
+	}
+	if len(rep.DeprecatedInCSV) != 1 || rep.DeprecatedInCSV[0] != "T1595.002" {
+		t.Fatalf("unexpected DeprecatedInCSV: %v", rep.DeprecatedInCSV)
+	}
+
+	tn := tax.techByTactic["侦察"]["主动扫描"]
+	if !strings.Contains(tn.Description, "active reconnaissance") || len(tn.Platforms) != 1 {
+		t.Fatalf("expected STIX fields on technique, got %+v", tn)
+	}
+}
+
+func TestAttachSTIX_TacticMismatchAndMissingCode(t *testing.T) {
+	csv := `tactic_id,tactic_name,technique_id,technique_name,sub_technique_name,sub_technique_id,name_en,code_official
+1,侦察,1,主动扫描,,0,Active Scanning,T1595
+1,侦察,5,收集受害者主机信息,,0,Gather Victim Host Information,T1592
+1,侦察,6,利用面向公众的应用程序,,0,Exploit Public-Facing Application,T1190
+1,侦察,7,未编号技术,,0,Uncoded,
+3,初始访问,20,利用面向公众的应用程序,,0,Exploit Public-Facing Application,T1190
+3,初始访问,7,未编号技术,,0,Uncoded,
+`
+	stix := `{"type": "bundle", "objects": [
+    {"type": "x-mitre-tactic", "name": "Reconnaissance", "x_mitre_shortname": "reconnaissance",
+     "external_references": [{"source_name": "mitre-attack", "external_id": "TA0043"}]},
+    {"type": "x-mitre-tactic", "name": "Initial Access", "x_mitre_shortname": "initial-access",
+     "external_references": [{"source_name": "mitre-attack", "external_id": "TA0001"}]},
+    {"type": "attack-pattern", "name": "Active Scanning", "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "reconnaissance"}],
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1595"}]},
+    {"type": "attack-pattern", "name": "Gather Victim Host Information", "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "reconnaissance"}],
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1592"}]},
+    {"type": "attack-pattern", "name": "Exploit Public-Facing Application", "kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "initial-access"}],
+     "external_references": [{"source_name": "mitre-attack", "external_id": "T1190"}]}
+  ]}`
+	tax, err := Parse(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	b, err := ParseSTIX(strings.NewReader(stix))
+	if err != nil {
+		t.Fatalf("ParseSTIX failed: %v", err)
+	}
+	rep := tax.AttachSTIX(b)
+	if rep.MissingCode != 1 {
+		t.Fatalf("a technique listed under two tactics should count once, got %d", rep.MissingCode)
+	}
+	if len(rep.TacticMismatches) != 1 || !strings.Contains(rep.TacticMismatches[0], "T1190") ||
+		!strings.Contains(rep.TacticMismatches[0], "侦察") || !strings.Contains(rep.TacticMismatches[0], "TA0001 Initial Access") {
+		t.Fatalf("unexpected tactic mismatches: %v", rep.TacticMismatches)
+	}
+}
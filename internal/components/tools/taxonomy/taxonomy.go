@@ -4,11 +4,11 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 // Mapping represents the IDs for a specific Tactic/Technique/SubTechnique combination
@@ -33,6 +33,9 @@ type techniqueNode struct {
 	TechniqueName string
 	NameEn        string
 	CodeOfficial  string
+	Description   string
+	Platforms     []string
+	Deprecated    bool
 	Subs          []subNode
 	subIdx        map[string]int
 }
@@ -42,9 +45,14 @@ type subNode struct {
 	SubTechniqueName string
 	NameEn           string
 	CodeOfficial     string
+	Description      string
+	Platforms        []string
+	Deprecated       bool
 }
 
-var (
+// Taxonomy is an in-memory index over one ATT&CK.csv file.
+// A nil *Taxonomy is valid and behaves as an empty taxonomy.
+type Taxonomy struct {
 	// key: tactic_name|technique_name|sub_technique_name (sub can be empty)
 	// value: Mapping
 	lookupTable map[string]Mapping
@@ -54,118 +62,142 @@ var (
 	// key: tactic_name
 	// value: technique_name -> node
 	techByTactic map[string]map[string]*techniqueNode
-	once         sync.Once
-	loadErr      error
-)
+	// key: tactic_name
+	// value: BM25 index over the tactic's techniques
+	index map[string]*tacticIndex
+	// key: technique_name
+	// value: unit-length embedding, see BuildVectorIndex
+	vectors        map[string][]float64
+	semanticWeight float64
+	// alternative spellings used by Resolve, see SetAliases
+	aliases aliasIndex
+}
 
-// Load initializes the taxonomy from the given CSV path.
+// Load reads the taxonomy from the given CSV path.
 // CSV Header expected: tactic_id,tactic_name,technique_id,technique_name,sub_technique_name,sub_technique_id,name_en,code_official
-func Load(csvPath string) error {
-	once.Do(func() {
-		lookupTable = make(map[string]Mapping)
-		tacticMap = make(map[string]int)
-		techByTactic = make(map[string]map[string]*techniqueNode)
-		f, err := os.Open(csvPath)
+func Load(csvPath string) (*Taxonomy, error) {
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("open taxonomy csv failed: %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads the taxonomy from CSV content with the same layout as Load.
+func Parse(r io.Reader) (*Taxonomy, error) {
+	t := &Taxonomy{
+		lookupTable:  make(map[string]Mapping),
+		tacticMap:    make(map[string]int),
+		techByTactic: make(map[string]map[string]*techniqueNode),
+	}
+
+	reader := csv.NewReader(r)
+	// Skip header
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("read header failed: %w", err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			loadErr = fmt.Errorf("open taxonomy csv failed: %w", err)
-			return
+			return nil, fmt.Errorf("read record failed: %w", err)
+		}
+		if len(record) < 6 {
+			continue
 		}
-		defer f.Close()
 
-		reader := csv.NewReader(f)
-		// Skip header
-		if _, err := reader.Read(); err != nil {
-			loadErr = fmt.Errorf("read header failed: %w", err)
-			return
+		// Parse IDs
+		tid, _ := strconv.Atoi(record[0])
+		tName := strings.TrimSpace(record[1])
+		teid, _ := strconv.Atoi(record[2])
+		teName := strings.TrimSpace(record[3])
+		subName := strings.TrimSpace(record[4])
+		subid, _ := strconv.Atoi(record[5])
+		var nameEn, codeOfficial string
+		if len(record) > 6 {
+			nameEn = strings.TrimSpace(record[6])
+		}
+		if len(record) > 7 {
+			codeOfficial = strings.TrimSpace(record[7])
 		}
 
-		for {
-			record, err := reader.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				loadErr = fmt.Errorf("read record failed: %w", err)
-				return
-			}
-			if len(record) < 6 {
-				continue
-			}
+		t.add(Mapping{
+			TacticID:         tid,
+			TacticName:       tName,
+			TechniqueID:      teid,
+			TechniqueName:    teName,
+			SubTechniqueID:   subid,
+			SubTechniqueName: subName,
+			NameEn:           nameEn,
+			CodeOfficial:     codeOfficial,
+		})
+	}
 
-			// Parse IDs
-			tid, _ := strconv.Atoi(record[0])
-			tName := strings.TrimSpace(record[1])
-			teid, _ := strconv.Atoi(record[2])
-			teName := strings.TrimSpace(record[3])
-			subName := strings.TrimSpace(record[4])
-			subid, _ := strconv.Atoi(record[5])
-			var nameEn, codeOfficial string
-			if len(record) > 6 {
-				nameEn = strings.TrimSpace(record[6])
-			}
-			if len(record) > 7 {
-				codeOfficial = strings.TrimSpace(record[7])
+	// Sort once here so lookups never mutate shared state.
+	for _, techMap := range t.techByTactic {
+		for _, tn := range techMap {
+			sort.SliceStable(tn.Subs, func(i, j int) bool { return tn.Subs[i].SubTechniqueID < tn.Subs[j].SubTechniqueID })
+			for i, sub := range tn.Subs {
+				tn.subIdx[sub.SubTechniqueName] = i
 			}
+		}
+	}
+	t.buildIndex()
+	return t, nil
+}
 
-			m := Mapping{
-				TacticID:         tid,
-				TacticName:       tName,
-				TechniqueID:      teid,
-				TechniqueName:    teName,
-				SubTechniqueID:   subid,
-				SubTechniqueName: subName,
-				NameEn:           nameEn,
-				CodeOfficial:     codeOfficial,
-			}
+func (t *Taxonomy) add(m Mapping) {
+	tName, teName, subName := m.TacticName, m.TechniqueName, m.SubTechniqueName
 
-			// Key format: Tactic|Technique|SubTechnique
-			key := makeKey(tName, teName, subName)
-			lookupTable[key] = m
+	// Key format: Tactic|Technique|SubTechnique
+	t.lookupTable[makeKey(tName, teName, subName)] = m
 
-			if tid > 0 && tName != "" {
-				tacticMap[tName] = tid
-			}
+	if m.TacticID > 0 && tName != "" {
+		t.tacticMap[tName] = m.TacticID
+	}
 
-			if tName != "" && teName != "" {
-				if _, ok := techByTactic[tName]; !ok {
-					techByTactic[tName] = make(map[string]*techniqueNode)
-				}
-				tn, ok := techByTactic[tName][teName]
-				if !ok {
-					tn = &techniqueNode{
-						TechniqueID:   teid,
-						TechniqueName: teName,
-						subIdx:        make(map[string]int),
-					}
-					techByTactic[tName][teName] = tn
-				}
+	if tName == "" || teName == "" {
+		return
+	}
+	if _, ok := t.techByTactic[tName]; !ok {
+		t.techByTactic[tName] = make(map[string]*techniqueNode)
+	}
+	tn, ok := t.techByTactic[tName][teName]
+	if !ok {
+		tn = &techniqueNode{
+			TechniqueID:   m.TechniqueID,
+			TechniqueName: teName,
+			subIdx:        make(map[string]int),
+		}
+		t.techByTactic[tName][teName] = tn
+	}
 
-				if tn.TechniqueID == 0 {
-					tn.TechniqueID = teid
-				}
+	if tn.TechniqueID == 0 {
+		tn.TechniqueID = m.TechniqueID
+	}
 
-				if subName == "" || subid == 0 {
-					if tn.NameEn == "" {
-						tn.NameEn = nameEn
-					}
-					if tn.CodeOfficial == "" {
-						tn.CodeOfficial = codeOfficial
-					}
-				} else {
-					if _, exists := tn.subIdx[subName]; !exists {
-						tn.Subs = append(tn.Subs, subNode{
-							SubTechniqueID:   subid,
-							SubTechniqueName: subName,
-							NameEn:           nameEn,
-							CodeOfficial:     codeOfficial,
-						})
-						tn.subIdx[subName] = len(tn.Subs) - 1
-					}
-				}
-			}
+	if subName == "" || m.SubTechniqueID == 0 {
+		if tn.NameEn == "" {
+			tn.NameEn = m.NameEn
 		}
-	})
-	return loadErr
+		if tn.CodeOfficial == "" {
+			tn.CodeOfficial = m.CodeOfficial
+		}
+		return
+	}
+	if _, exists := tn.subIdx[subName]; !exists {
+		tn.Subs = append(tn.Subs, subNode{
+			SubTechniqueID:   m.SubTechniqueID,
+			SubTechniqueName: subName,
+			NameEn:           m.NameEn,
+			CodeOfficial:     m.CodeOfficial,
+		})
+		tn.subIdx[subName] = len(tn.Subs) - 1
+	}
 }
 
 func makeKey(tactic, technique, sub string) string {
@@ -174,36 +206,37 @@ func makeKey(tactic, technique, sub string) string {
 
 // LookupIDs returns the IDs for the given names.
 // If not found, returns (0, 0, 0, false).
-func LookupIDs(tactic, technique, sub string) (tacticID, techniqueID, subID int, found bool) {
-	if lookupTable == nil {
+func (t *Taxonomy) LookupIDs(tactic, technique, sub string) (tacticID, techniqueID, subID int, found bool) {
+	if t == nil {
 		return 0, 0, 0, false
 	}
 	key := makeKey(tactic, technique, sub)
-	if m, ok := lookupTable[key]; ok {
+	if m, ok := t.lookupTable[key]; ok {
 		return m.TacticID, m.TechniqueID, m.SubTechniqueID, true
 	}
 	return 0, 0, 0, false
 }
 
 // LookupTacticID returns the ID for a tactic name.
-func LookupTacticID(tactic string) (int, bool) {
-	if tacticMap == nil {
+func (t *Taxonomy) LookupTacticID(tactic string) (int, bool) {
+	if t == nil {
 		return 0, false
 	}
-	id, ok := tacticMap[strings.TrimSpace(tactic)]
+	id, ok := t.tacticMap[strings.TrimSpace(tactic)]
 	return id, ok
 }
 
-func ListTactics() []string {
-	if tacticMap == nil {
+// ListTactics returns all tactic names ordered by tactic ID.
+func (t *Taxonomy) ListTactics() []string {
+	if t == nil {
 		return nil
 	}
 	type pair struct {
 		id   int
 		name string
 	}
-	pairs := make([]pair, 0, len(tacticMap))
-	for name, id := range tacticMap {
+	pairs := make([]pair, 0, len(t.tacticMap))
+	for name, id := range t.tacticMap {
 		pairs = append(pairs, pair{id: id, name: name})
 	}
 	sort.Slice(pairs, func(i, j int) bool {
@@ -219,90 +252,39 @@ func ListTactics() []string {
 	return out
 }
 
-func GenerateTechniqueCandidates(tactic, query string, topK, subMaxPerTechnique int) []TechniqueCandidate {
-	tactic = strings.TrimSpace(tactic)
-	if techByTactic == nil {
-		return nil
-	}
-	techMap, ok := techByTactic[tactic]
-	if !ok || len(techMap) == 0 {
-		return nil
-	}
-
-	type scored struct {
-		name  string
-		score int
-		id    int
-		subs  []string
-	}
-
-	queryLower := strings.ToLower(query)
-	scoreText := func(needle string, weight int) int {
-		needle = strings.TrimSpace(needle)
-		if needle == "" {
-			return 0
-		}
-		if strings.Contains(queryLower, strings.ToLower(needle)) || strings.Contains(query, needle) {
-			return weight
-		}
-		return 0
-	}
-
-	var scoredList []scored
-	for _, tn := range techMap {
-		s := scoreText(tn.TechniqueName, 6) + scoreText(tn.NameEn, 4) + scoreText(tn.CodeOfficial, 4)
-		var matchedSubs []string
-		for _, sub := range tn.Subs {
-			subScore := scoreText(sub.SubTechniqueName, 5) + scoreText(sub.NameEn, 3) + scoreText(sub.CodeOfficial, 3)
-			if subScore > 0 {
-				s += subScore
-				matchedSubs = append(matchedSubs, sub.SubTechniqueName)
-			}
-		}
-		scoredList = append(scoredList, scored{
-			name:  tn.TechniqueName,
-			score: s,
-			id:    tn.TechniqueID,
-			subs:  matchedSubs,
-		})
-	}
+// GenerateTechniqueCandidates ranks the techniques of a tactic against query
+// with BM25 over technique/sub-technique names (Chinese and English) and, when
+// a STIX bundle is attached, MITRE descriptions. A query naming an official
+// code (e.g. T1190) gets an extra bonus. When nothing matches, the first topK
+// techniques by ID are returned.
+func (t *Taxonomy) GenerateTechniqueCandidates(tactic, query string, topK, subMaxPerTechnique int) []TechniqueCandidate {
+	return t.GenerateTechniqueCandidatesWithVector(tactic, query, nil, topK, subMaxPerTechnique)
+}
 
-	sort.Slice(scoredList, func(i, j int) bool {
-		if scoredList[i].score != scoredList[j].score {
-			return scoredList[i].score > scoredList[j].score
-		}
-		if scoredList[i].id != scoredList[j].id {
-			return scoredList[i].id < scoredList[j].id
-		}
-		return scoredList[i].name < scoredList[j].name
-	})
-
-	anyMatched := false
-	for _, it := range scoredList {
-		if it.score > 0 {
-			anyMatched = true
-			break
-		}
-	}
+// GenerateTechniqueCandidatesWithVector is GenerateTechniqueCandidates blended
+// with cosine similarity between queryVec (the embedding of query) and the
+// technique vectors loaded by BuildVectorIndex. A nil queryVec, or a taxonomy
+// without vectors, gives the purely lexical ranking.
+func (t *Taxonomy) GenerateTechniqueCandidatesWithVector(tactic, query string, queryVec []float64, topK, subMaxPerTechnique int) []TechniqueCandidate {
+	scoredList := t.ScoreTechniques(tactic, query, queryVec)
+	anyMatched := len(scoredList) > 0 && scoredList[0].Score > 0
 
 	var out []TechniqueCandidate
 	for _, it := range scoredList {
-		if anyMatched && it.score == 0 {
+		if anyMatched && it.Score <= 0 {
 			continue
 		}
-		tn := techMap[it.name]
+		tn := t.techByTactic[strings.TrimSpace(tactic)][it.TechniqueName]
 		c := TechniqueCandidate{TechniqueName: tn.TechniqueName}
 
-		if len(it.subs) > 0 {
-			sort.Strings(it.subs)
-			for _, s := range it.subs {
+		if len(it.MatchedSubs) > 0 {
+			for _, s := range it.MatchedSubs {
 				c.SubNames = append(c.SubNames, s)
 				if subMaxPerTechnique > 0 && len(c.SubNames) >= subMaxPerTechnique {
 					break
 				}
 			}
 		} else if len(tn.Subs) > 0 {
-			sort.Slice(tn.Subs, func(i, j int) bool { return tn.Subs[i].SubTechniqueID < tn.Subs[j].SubTechniqueID })
 			for _, sub := range tn.Subs {
 				c.SubNames = append(c.SubNames, sub.SubTechniqueName)
 				if subMaxPerTechnique > 0 && len(c.SubNames) >= subMaxPerTechnique {
@@ -319,6 +301,169 @@ func GenerateTechniqueCandidates(tactic, query string, topK, subMaxPerTechnique
 	return out
 }
 
+// TechniqueScore is the ranking detail of one technique for a query.
+type TechniqueScore struct {
+	TechniqueID   int
+	TechniqueName string
+	CodeOfficial  string
+	// Lexical is BM25 plus code bonus, including the best sub-technique.
+	Lexical float64
+	// Semantic is the cosine similarity, 0 without vectors.
+	Semantic float64
+	// Score is the value used for ranking.
+	Score float64
+	// MatchedSubs are the sub-techniques with a lexical hit, best first.
+	MatchedSubs []string
+}
+
+// ScoreTechniques returns every technique of tactic with its scores, best
+// first (ties by technique ID). It is the ranking behind
+// GenerateTechniqueCandidatesWithVector.
+func (t *Taxonomy) ScoreTechniques(tactic, query string, queryVec []float64) []TechniqueScore {
+	tactic = strings.TrimSpace(tactic)
+	if t == nil {
+		return nil
+	}
+	ti, ok := t.index[tactic]
+	if !ok || len(ti.techs) == 0 {
+		return nil
+	}
+
+	terms := tokenize(query)
+	codes := queryCodes(query)
+	techScores := ti.tech.score(terms)
+
+	scoredList := make([]TechniqueScore, 0, len(ti.techs))
+	for i, tn := range ti.techs {
+		s := techScores[i]
+		if containsCode(codes, tn.CodeOfficial) {
+			s += codeBonus
+		}
+
+		subScores := ti.subs[i].score(terms)
+		type subScore struct {
+			name  string
+			score float64
+		}
+		var matched []subScore
+		best := 0.0
+		for j, sub := range tn.Subs {
+			ss := 0.0
+			if j < len(subScores) {
+				ss = subScores[j]
+			}
+			if containsCode(codes, sub.CodeOfficial) {
+				ss += codeBonus
+			}
+			if ss > 0 {
+				matched = append(matched, subScore{name: sub.SubTechniqueName, score: ss})
+			}
+			if ss > best {
+				best = ss
+			}
+		}
+		sort.SliceStable(matched, func(a, b int) bool { return matched[a].score > matched[b].score })
+		var subs []string
+		for _, m := range matched {
+			subs = append(subs, m.name)
+		}
+
+		scoredList = append(scoredList, TechniqueScore{
+			TechniqueID:   tn.TechniqueID,
+			TechniqueName: tn.TechniqueName,
+			CodeOfficial:  tn.CodeOfficial,
+			Lexical:       s + best,
+			Score:         s + best,
+			MatchedSubs:   subs,
+		})
+	}
+
+	if queryVec = normalizeVector(queryVec); queryVec != nil && len(t.vectors) > 0 {
+		maxLex := 0.0
+		for _, it := range scoredList {
+			if it.Lexical > maxLex {
+				maxLex = it.Lexical
+			}
+		}
+		w := t.semanticWeight
+		for i := range scoredList {
+			lex := 0.0
+			if maxLex > 0 {
+				lex = scoredList[i].Lexical / maxLex
+			}
+			if v, ok := t.vectors[scoredList[i].TechniqueName]; ok {
+				scoredList[i].Semantic = math.Max(0, dot(queryVec, v))
+			}
+			scoredList[i].Score = (1-w)*lex + w*scoredList[i].Semantic
+		}
+	}
+
+	// ti.techs is already ordered by ID, so a stable sort keeps ties by ID.
+	sort.SliceStable(scoredList, func(i, j int) bool { return scoredList[i].Score > scoredList[j].Score })
+	return scoredList
+}
+
+// TechniqueInfo describes one technique and its sub-techniques for browsing.
+type TechniqueInfo struct {
+	TechniqueID   int
+	TechniqueName string
+	NameEn        string
+	CodeOfficial  string
+	Deprecated    bool
+	Subs          []SubTechniqueInfo
+}
+
+// SubTechniqueInfo describes one sub-technique for browsing.
+type SubTechniqueInfo struct {
+	SubTechniqueID   int
+	SubTechniqueName string
+	NameEn           string
+	CodeOfficial     string
+	Deprecated       bool
+}
+
+// Techniques returns the technique/sub-technique tree of a tactic ordered by ID.
+func (t *Taxonomy) Techniques(tactic string) []TechniqueInfo {
+	if t == nil {
+		return nil
+	}
+	var out []TechniqueInfo
+	for _, tn := range sortedTechniques(t.techByTactic[strings.TrimSpace(tactic)]) {
+		info := TechniqueInfo{
+			TechniqueID:   tn.TechniqueID,
+			TechniqueName: tn.TechniqueName,
+			NameEn:        tn.NameEn,
+			CodeOfficial:  tn.CodeOfficial,
+			Deprecated:    tn.Deprecated,
+		}
+		for _, sub := range tn.Subs {
+			info.Subs = append(info.Subs, SubTechniqueInfo{
+				SubTechniqueID:   sub.SubTechniqueID,
+				SubTechniqueName: sub.SubTechniqueName,
+				NameEn:           sub.NameEn,
+				CodeOfficial:     sub.CodeOfficial,
+				Deprecated:       sub.Deprecated,
+			})
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func sortedTechniques(techMap map[string]*techniqueNode) []*techniqueNode {
+	out := make([]*techniqueNode, 0, len(techMap))
+	for _, tn := range techMap {
+		out = append(out, tn)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TechniqueID != out[j].TechniqueID {
+			return out[i].TechniqueID < out[j].TechniqueID
+		}
+		return out[i].TechniqueName < out[j].TechniqueName
+	})
+	return out
+}
+
 func FormatTechniqueCandidates(tactic string, cands []TechniqueCandidate, maxRunes int) string {
 	var b strings.Builder
 	for _, c := range cands {
@@ -344,11 +489,11 @@ func FormatTechniqueCandidates(tactic string, cands []TechniqueCandidate, maxRun
 }
 
 // GetMapping returns the full Mapping struct
-func GetMapping(tactic, technique, sub string) (Mapping, bool) {
-	if lookupTable == nil {
+func (t *Taxonomy) GetMapping(tactic, technique, sub string) (Mapping, bool) {
+	if t == nil {
 		return Mapping{}, false
 	}
 	key := makeKey(tactic, technique, sub)
-	m, ok := lookupTable[key]
+	m, ok := t.lookupTable[key]
 	return m, ok
 }
@@ -23,12 +23,12 @@ func TestLoadAndLookup(t *testing.T) {
 		t.Skip("ATT&CK.csv not found, skipping test")
 	}
 
-	err := Load(csvPath)
+	tax, err := Load(csvPath)
 	if err != nil {
 		t.Fatalf("Load failed: %v", err)
 	}
 
-	tid, teid, subid, found := LookupIDs("侦察", "主动扫描", "扫描 IP 块")
+	tid, teid, subid, found := tax.LookupIDs("侦察", "主动扫描", "扫描 IP 块")
 	if !found {
 		t.Errorf("Lookup failed for 侦察/主动扫描/扫描 IP 块")
 	}
@@ -36,7 +36,7 @@ func TestLoadAndLookup(t *testing.T) {
 		t.Errorf("ID mismatch: got %d,%d,%d; want 1,1,2", tid, teid, subid)
 	}
 
-	id, ok := LookupTacticID("侦察")
+	id, ok := tax.LookupTacticID("侦察")
 	if !ok || id != 1 {
 		t.Errorf("LookupTacticID failed for 侦察: got %d, %v", id, ok)
 	}
@@ -59,11 +59,12 @@ func TestCandidatesGeneration(t *testing.T) {
 		t.Skip("ATT&CK.csv not found, skipping test")
 	}
 
-	if err := Load(csvPath); err != nil {
+	tax, err := Load(csvPath)
+	if err != nil {
 		t.Fatalf("Load failed: %v", err)
 	}
 
-	tactics := ListTactics()
+	tactics := tax.ListTactics()
 	if len(tactics) == 0 {
 		t.Fatalf("expected non-empty tactics list")
 	}
@@ -78,7 +79,7 @@ func TestCandidatesGeneration(t *testing.T) {
 		t.Fatalf("expected tactics to include 侦察, got: %v", tactics)
 	}
 
-	cands := GenerateTechniqueCandidates("侦察", "扫描 IP 块 漏洞扫描", 5, 5)
+	cands := tax.GenerateTechniqueCandidates("侦察", "扫描 IP 块 漏洞扫描", 5, 5)
 	if len(cands) == 0 {
 		t.Fatalf("expected non-empty candidates")
 	}
@@ -87,3 +88,47 @@ func TestCandidatesGeneration(t *testing.T) {
 		t.Fatalf("expected candidates to include 主动扫描, got: %q", gotText)
 	}
 }
+
+const testCSV = `tactic_id,tactic_name,technique_id,technique_name,sub_technique_name,sub_technique_id,name_en,code_official
+1,侦察,1,主动扫描,,0,Active Scanning,T1595
+1,侦察,1,主动扫描,漏洞扫描,3,Vulnerability Scanning,T1595.002
+1,侦察,1,主动扫描,扫描 IP 块,2,Scanning IP Blocks,T1595.001
+3,初始访问,20,利用面向公众的应用程序,,0,Exploit Public-Facing Application,T1190
+`
+
+func TestParse_IndependentInstances(t *testing.T) {
+	a, err := Parse(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	b, err := Parse(strings.NewReader(strings.Replace(testCSV, "3,初始访问,20,", "3,初始访问,21,", 1)))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if _, teid, _, ok := a.LookupIDs("初始访问", "利用面向公众的应用程序", ""); !ok || teid != 20 {
+		t.Fatalf("a: got %d, %v; want 20, true", teid, ok)
+	}
+	if _, teid, _, ok := b.LookupIDs("初始访问", "利用面向公众的应用程序", ""); !ok || teid != 21 {
+		t.Fatalf("b: got %d, %v; want 21, true", teid, ok)
+	}
+
+	if got := a.ListTactics(); len(got) != 2 || got[0] != "侦察" || got[1] != "初始访问" {
+		t.Fatalf("unexpected tactics: %v", got)
+	}
+
+	cands := a.GenerateTechniqueCandidates("侦察", "", 5, 5)
+	if len(cands) != 1 || len(cands[0].SubNames) != 2 || cands[0].SubNames[0] != "扫描 IP 块" {
+		t.Fatalf("expected subs ordered by ID, got: %+v", cands)
+	}
+}
+
+func TestNilTaxonomy(t *testing.T) {
+	var tax *Taxonomy
+	if _, _, _, ok := tax.LookupIDs("侦察", "", ""); ok {
+		t.Fatalf("expected lookup miss on nil taxonomy")
+	}
+	if got := tax.ListTactics(); got != nil {
+		t.Fatalf("expected nil tactics, got %v", got)
+	}
+}
@@ -3,10 +3,12 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type PathsConfig struct {
@@ -29,16 +31,55 @@ type YuhengConfig struct {
 }
 
 type AIConfig struct {
-	Provider     string          `json:"provider"`
-	Model        string          `json:"model"`
-	TimeoutS     float64         `json:"timeout_s"`
-	BaseURL      string          `json:"base_url"`
-	PromptPath   string          `json:"prompt_path"`
-	Concurrency  int             `json:"concurrency"`
-	RateLimitQPS int             `json:"rate_limit_qps"`
-	Context      AIContextConfig `json:"context"`
-	ATTCK        AIAttckConfig   `json:"attck"`
-	APIKey       string          `json:"-"`
+	Provider   string  `json:"provider"`
+	Model      string  `json:"model"`
+	TimeoutS   float64 `json:"timeout_s"`
+	BaseURL    string  `json:"base_url"`
+	PromptPath string  `json:"prompt_path"`
+	RulesPath  string  `json:"rules_path"`
+	// PolicyPath is the policy file deciding which records are skipped,
+	// rejected or sent to manual review before the model sees them.
+	PolicyPath     string           `json:"policy_path"`
+	Concurrency    int              `json:"concurrency"`
+	RateLimitQPS   float64          `json:"rate_limit_qps"`
+	RateLimitBurst int              `json:"rate_limit_burst"`
+	RateLimitTPM   int              `json:"rate_limit_tpm"`
+	Adaptive       AIAdaptiveConfig `json:"adaptive"`
+	Context        AIContextConfig  `json:"context"`
+	ATTCK          AIAttckConfig    `json:"attck"`
+	Dedup          AIDedupConfig    `json:"dedup"`
+	Judge          AIJudgeConfig    `json:"judge"`
+	// RecordTrace persists what the model saw and returned for every
+	// record: "jsonl" appends to record_traces.jsonl, "files" writes
+	// record_traces/<id>.json, empty disables it.
+	RecordTrace string `json:"record_trace"`
+	APIKey      string `json:"-"`
+}
+
+// AIAdaptiveConfig lets the AI stage adjust its parallelism at run time:
+// it starts at Concurrency, grows while model calls succeed within
+// LatencyTargetS and halves on throttling (429) or timeouts, staying within
+// [MinConcurrency, MaxConcurrency].
+type AIAdaptiveConfig struct {
+	Enabled        bool    `json:"enabled"`
+	MinConcurrency int     `json:"min_concurrency"`
+	MaxConcurrency int     `json:"max_concurrency"`
+	LatencyTargetS float64 `json:"latency_target_s"`
+}
+
+// AIDedupConfig makes the AI stage analyse one record per group of records
+// with the same normalised trimmed context and copy its verdict to the rest.
+type AIDedupConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// AIJudgeConfig asks a second model the risk prompt of every record the
+// model scored, so that Submit can hold records where the two verdicts
+// disagree. It is off unless Model is set; BaseURL falls back to the chat
+// model's.
+type AIJudgeConfig struct {
+	Model   string `json:"model"`
+	BaseURL string `json:"base_url"`
 }
 
 type AIContextConfig struct {
@@ -51,17 +92,114 @@ type AIContextConfig struct {
 }
 
 type AIAttckConfig struct {
-	CSVPath            string   `json:"csv_path"`
-	TacticAllowlist    []string `json:"tactic_allowlist"`
-	TechniqueTopK      int      `json:"technique_top_k"`
-	CandidateMaxRunes  int      `json:"candidate_max_runes"`
-	SubMaxPerTechnique int      `json:"sub_max_per_technique"`
+	CSVPath            string            `json:"csv_path"`
+	STIXPath           string            `json:"stix_path"`
+	AliasPath          string            `json:"alias_path"`
+	TacticAllowlist    []string          `json:"tactic_allowlist"`
+	TechniqueTopK      int               `json:"technique_top_k"`
+	CandidateMaxRunes  int               `json:"candidate_max_runes"`
+	SubMaxPerTechnique int               `json:"sub_max_per_technique"`
+	Embedding          AIEmbeddingConfig `json:"embedding"`
+}
+
+// AIEmbeddingConfig enables semantic technique retrieval. It is off unless
+// Model is set; BaseURL and APIKey fall back to the chat model's.
+type AIEmbeddingConfig struct {
+	BaseURL   string  `json:"base_url"`
+	Model     string  `json:"model"`
+	TimeoutS  float64 `json:"timeout_s"`
+	IndexPath string  `json:"index_path"`
+	// Weight of the semantic score against BM25, in [0,1]; 0 ranks
+	// lexically only. Unset means DefaultEmbeddingWeight.
+	Weight *float64 `json:"weight"`
+	APIKey string   `json:"-"`
+}
+
+// DefaultEmbeddingWeight is the semantic weight when ai.attck.embedding.weight
+// is not set.
+const DefaultEmbeddingWeight = 0.5
+
+// SemanticWeight returns the configured weight, or DefaultEmbeddingWeight.
+func (e AIEmbeddingConfig) SemanticWeight() float64 {
+	if e.Weight == nil {
+		return DefaultEmbeddingWeight
+	}
+	return *e.Weight
+}
+
+// applyAdaptiveDefaults fills unset adaptive bounds: min 1, max
+// ai.concurrency, latency target 30s. min never exceeds max.
+func applyAdaptiveDefaults(ai *AIConfig) {
+	a := &ai.Adaptive
+	if a.MinConcurrency <= 0 {
+		a.MinConcurrency = 1
+	}
+	if a.MaxConcurrency <= 0 {
+		a.MaxConcurrency = ai.Concurrency
+	}
+	if a.MaxConcurrency < a.MinConcurrency {
+		a.MaxConcurrency = a.MinConcurrency
+	}
+	if a.LatencyTargetS <= 0 {
+		a.LatencyTargetS = 30
+	}
+}
+
+// ProgressConfig controls the progress reporter: Mode is auto (status line
+// on a terminal, JSON events otherwise), tty, json or off; IntervalS is the
+// JSON event period.
+type ProgressConfig struct {
+	Mode      string  `json:"mode"`
+	IntervalS float64 `json:"interval_s"`
+}
+
+// LogConfig selects the log level (debug, info, warn, error), the stdout
+// format (text or json) and whether each run also logs to a file in the
+// state dir.
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	File   bool   `json:"file"`
+}
+
+// MetricsConfig enables the Prometheus listener when Listen is set, for
+// example ":9102".
+type MetricsConfig struct {
+	Listen string `json:"listen"`
+}
+
+// TracingConfig enables span export: Exporter is "otlp" (OTLP/HTTP JSON
+// to Endpoint, for example http://localhost:4318), "file" (JSONL under the
+// state dir) or empty for no tracing.
+type TracingConfig struct {
+	Exporter    string `json:"exporter"`
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"service_name"`
+}
+
+// ReviewConfig makes Submit hold records for human review instead of
+// submitting them when any enabled condition matches: a score of at least
+// MinScore (0 disables it), no technique selected, a score read from the
+// reply text because it was not valid JSON, or a judge verdict (ai.judge)
+// whose score differs by JudgeScoreDelta or more or whose technique differs.
+type ReviewConfig struct {
+	Enabled           bool `json:"enabled"`
+	MinScore          int  `json:"min_score"`
+	EmptyTechnique    bool `json:"empty_technique"`
+	TextScore         bool `json:"text_score"`
+	JudgeDisagreement bool `json:"judge_disagreement"`
+	JudgeScoreDelta   int  `json:"judge_score_delta"`
 }
 
 type RootConfig struct {
-	Paths  PathsConfig  `json:"paths"`
-	Yuheng YuhengConfig `json:"yuheng"`
-	AI     AIConfig     `json:"ai"`
+	Paths    PathsConfig    `json:"paths"`
+	Yuheng   YuhengConfig   `json:"yuheng"`
+	AI       AIConfig       `json:"ai"`
+	Review   ReviewConfig   `json:"review"`
+	Progress ProgressConfig `json:"progress"`
+	Log      LogConfig      `json:"log"`
+	Metrics  MetricsConfig  `json:"metrics"`
+	Tracing  TracingConfig  `json:"tracing"`
 }
 
 func (c *RootConfig) StateDir() string {
@@ -83,10 +221,81 @@ func (c *RootConfig) PendingAuditsResultsPath() string {
 	return filepath.Join(c.StateDir(), "pending_audits_results.jsonl")
 }
 
+// RunLogPath is the log file of a run started at t.
+func (c *RootConfig) RunLogPath(t time.Time) string {
+	return filepath.Join(c.StateDir(), "logs", "run-"+t.UTC().Format("20060102T150405Z")+".log")
+}
+
+// RunManifestPath is the manifest of the AI run runID.
+func (c *RootConfig) RunManifestPath(runID string) string {
+	return filepath.Join(c.StateDir(), "runs", runID+".json")
+}
+
+// RecordTracesPath is the per-record trace file of ai.record_trace=jsonl.
+func (c *RootConfig) RecordTracesPath() string {
+	return filepath.Join(c.StateDir(), "record_traces.jsonl")
+}
+
+// RecordTraceDir holds the <id>.json files of ai.record_trace=files.
+func (c *RootConfig) RecordTraceDir() string {
+	return filepath.Join(c.StateDir(), "record_traces")
+}
+
+// TracePath is the span file of a run started at t with the file exporter.
+func (c *RootConfig) TracePath(t time.Time) string {
+	return filepath.Join(c.StateDir(), "traces", "trace-"+t.UTC().Format("20060102T150405Z")+".jsonl")
+}
+
+// ReviewQueuePath holds the records Submit held for human review.
+func (c *RootConfig) ReviewQueuePath() string {
+	return filepath.Join(c.StateDir(), "review_queue.jsonl")
+}
+
+// ReviewDecisionsPath holds the reviewers' decisions; Submit sends the
+// approved version of accepted and edited records and skips rejected ones.
+func (c *RootConfig) ReviewDecisionsPath() string {
+	return filepath.Join(c.StateDir(), "review_decisions.jsonl")
+}
+
 func (c *RootConfig) SubmittedIDsPath() string {
 	return filepath.Join(c.StateDir(), "submitted_ids.jsonl")
 }
 
+// PendingAuditsFailuresPath is the dead-letter file of records the AI stage
+// could not score.
+func (c *RootConfig) PendingAuditsFailuresPath() string {
+	return filepath.Join(c.StateDir(), "pending_audits_failures.jsonl")
+}
+
+// ATTCKCSVPath returns the first existing ATT&CK.csv among ai.attck.csv_path,
+// ./ATT&CK.csv and ../ATT&CK.csv, or "" if none exists.
+func (c *RootConfig) ATTCKCSVPath() string {
+	var candidates []string
+	if c != nil && strings.TrimSpace(c.AI.ATTCK.CSVPath) != "" {
+		candidates = append(candidates, strings.TrimSpace(c.AI.ATTCK.CSVPath))
+	}
+	candidates = append(candidates,
+		"ATT&CK.csv",
+		"../ATT&CK.csv",
+	)
+
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// ATTCKEmbeddingIndexPath returns ai.attck.embedding.index_path, defaulting to
+// a file next to csvPath.
+func (c *RootConfig) ATTCKEmbeddingIndexPath(csvPath string) string {
+	if c != nil && strings.TrimSpace(c.AI.ATTCK.Embedding.IndexPath) != "" {
+		return strings.TrimSpace(c.AI.ATTCK.Embedding.IndexPath)
+	}
+	return csvPath + ".embeddings.json"
+}
+
 func Load() (*RootConfig, error) {
 	appPath := os.Getenv("YH_CONFIG")
 	if appPath == "" {
@@ -174,6 +383,12 @@ func Load() (*RootConfig, error) {
 	if base.AI.ATTCK.SubMaxPerTechnique <= 0 {
 		base.AI.ATTCK.SubMaxPerTechnique = 8
 	}
+	if base.Review.JudgeScoreDelta <= 0 {
+		base.Review.JudgeScoreDelta = 2
+	}
+	if w := base.AI.ATTCK.Embedding.Weight; w != nil && (*w < 0 || *w > 1) {
+		return nil, fmt.Errorf("ai.attck.embedding.weight %v outside [0,1]", *w)
+	}
 
 	if p := os.Getenv("AI_PROVIDER"); p != "" {
 		base.AI.Provider = p
@@ -187,16 +402,100 @@ func Load() (*RootConfig, error) {
 	if p := os.Getenv("AI_PROMPT_PATH"); p != "" {
 		base.AI.PromptPath = p
 	}
+	if p := os.Getenv("AI_RECORD_TRACE"); p != "" {
+		base.AI.RecordTrace = p
+	}
+	base.AI.RecordTrace = strings.ToLower(strings.TrimSpace(base.AI.RecordTrace))
 	if p := os.Getenv("AI_CONCURRENCY"); p != "" {
 		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v > 0 {
 			base.AI.Concurrency = v
 		}
 	}
 	if p := os.Getenv("AI_RATE_LIMIT_QPS"); p != "" {
-		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v >= 0 {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(p), 64); err == nil && v >= 0 {
 			base.AI.RateLimitQPS = v
 		}
 	}
+	if p := os.Getenv("AI_RATE_LIMIT_BURST"); p != "" {
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v >= 0 {
+			base.AI.RateLimitBurst = v
+		}
+	}
+	if p := os.Getenv("AI_RATE_LIMIT_TPM"); p != "" {
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v >= 0 {
+			base.AI.RateLimitTPM = v
+		}
+	}
+	if p := os.Getenv("AI_ADAPTIVE_CONCURRENCY"); p != "" {
+		if v, err := strconv.ParseBool(strings.TrimSpace(p)); err == nil {
+			base.AI.Adaptive.Enabled = v
+		}
+	}
+	if p := os.Getenv("AI_MAX_CONCURRENCY"); p != "" {
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v > 0 {
+			base.AI.Adaptive.MaxConcurrency = v
+		}
+	}
+	applyAdaptiveDefaults(&base.AI)
+	if p := os.Getenv("AI_DEDUP"); p != "" {
+		if v, err := strconv.ParseBool(strings.TrimSpace(p)); err == nil {
+			base.AI.Dedup.Enabled = v
+		}
+	}
+
+	if p := os.Getenv("REVIEW_ENABLED"); p != "" {
+		if v, err := strconv.ParseBool(strings.TrimSpace(p)); err == nil {
+			base.Review.Enabled = v
+		}
+	}
+	if p := os.Getenv("REVIEW_MIN_SCORE"); p != "" {
+		if v, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && v >= 0 {
+			base.Review.MinScore = v
+		}
+	}
+
+	if p := os.Getenv("PROGRESS_MODE"); p != "" {
+		base.Progress.Mode = p
+	}
+	base.Progress.Mode = strings.ToLower(strings.TrimSpace(base.Progress.Mode))
+	if base.Progress.Mode == "" {
+		base.Progress.Mode = "auto"
+	}
+	if base.Progress.IntervalS <= 0 {
+		base.Progress.IntervalS = 10
+	}
+
+	if p := os.Getenv("LOG_LEVEL"); p != "" {
+		base.Log.Level = p
+	}
+	if p := os.Getenv("LOG_FORMAT"); p != "" {
+		base.Log.Format = p
+	}
+	if p := os.Getenv("METRICS_LISTEN"); p != "" {
+		base.Metrics.Listen = p
+	}
+	if p := os.Getenv("LOG_FILE"); p != "" {
+		if v, err := strconv.ParseBool(strings.TrimSpace(p)); err == nil {
+			base.Log.File = v
+		}
+	}
+
+	if p := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); p != "" {
+		base.Tracing.Endpoint = p
+		if base.Tracing.Exporter == "" {
+			base.Tracing.Exporter = "otlp"
+		}
+	}
+	if p := os.Getenv("TRACING_EXPORTER"); p != "" {
+		base.Tracing.Exporter = p
+	}
+	base.Tracing.Exporter = strings.ToLower(strings.TrimSpace(base.Tracing.Exporter))
+	if p := os.Getenv("OTEL_SERVICE_NAME"); p != "" {
+		base.Tracing.ServiceName = p
+	}
+	if base.Tracing.ServiceName == "" {
+		base.Tracing.ServiceName = "audit-workflow"
+	}
 
 	if p := os.Getenv("AI_API_KEY"); p != "" {
 		base.AI.APIKey = p
@@ -204,6 +503,23 @@ func Load() (*RootConfig, error) {
 		base.AI.APIKey = v
 	}
 
+	if p := os.Getenv("AI_EMBEDDING_MODEL"); p != "" {
+		base.AI.ATTCK.Embedding.Model = p
+	}
+	if p := os.Getenv("AI_EMBEDDING_API_KEY"); p != "" {
+		base.AI.ATTCK.Embedding.APIKey = p
+	} else if v, ok := aiSecrets["embedding_api_key"].(string); ok && strings.TrimSpace(v) != "" {
+		base.AI.ATTCK.Embedding.APIKey = v
+	} else {
+		base.AI.ATTCK.Embedding.APIKey = base.AI.APIKey
+	}
+	if base.AI.ATTCK.Embedding.BaseURL == "" {
+		base.AI.ATTCK.Embedding.BaseURL = base.AI.BaseURL
+	}
+	if base.AI.ATTCK.Embedding.TimeoutS <= 0 {
+		base.AI.ATTCK.Embedding.TimeoutS = base.AI.TimeoutS
+	}
+
 	return &base, nil
 }
 
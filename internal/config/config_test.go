@@ -70,3 +70,39 @@ func TestLoad_FailsOnInvalidSecretsJSON(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+func TestLoad_EmbeddingWeight(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("YH_SECRETS", filepath.Join(dir, "missing.json"))
+	for _, c := range []struct {
+		json string
+		want float64
+		err  bool
+	}{
+		{`{}`, DefaultEmbeddingWeight, false},
+		{`{"weight":0}`, 0, false},
+		{`{"weight":1}`, 1, false},
+		{`{"weight":0.3}`, 0.3, false},
+		{`{"weight":-0.1}`, 0, true},
+		{`{"weight":1.5}`, 0, true},
+	} {
+		appPath := filepath.Join(dir, "app.json")
+		if err := os.WriteFile(appPath, []byte(`{"ai":{"attck":{"embedding":`+c.json+`}}}`), 0o644); err != nil {
+			t.Fatalf("write app.json: %v", err)
+		}
+		t.Setenv("YH_CONFIG", appPath)
+		cfg, err := Load()
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.json)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.json, err)
+		}
+		if got := cfg.AI.ATTCK.Embedding.SemanticWeight(); got != c.want {
+			t.Errorf("%s: weight = %v, want %v", c.json, got, c.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fetch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,6 +14,10 @@ import (
 
 	"audit-workflow/internal/config"
 	"audit-workflow/internal/httpclient"
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/progress"
+	"audit-workflow/internal/types"
 )
 
 type loginResp struct {
@@ -28,53 +33,108 @@ type listResp struct {
 }
 
 func Run(cfg *config.RootConfig) error {
+	return RunWithContext(context.Background(), cfg)
+}
+
+// RunWithContext is Run stopping between records once ctx is done; the
+// records fetched so far are synced to pending_audits.jsonl and ctx.Err()
+// is returned.
+func RunWithContext(ctx context.Context, cfg *config.RootConfig) error {
+	return run(ctx, cfg, nil)
+}
+
+// Stream fetches like Run, still writing pending_audits.jsonl, and also sends
+// every record on out as soon as it is written. Sending blocks while the
+// consumer is busy, which paces fetching. out is closed when Stream returns.
+func Stream(ctx context.Context, cfg *config.RootConfig, out chan<- types.PendingRecord) error {
+	defer close(out)
+	return run(ctx, cfg, func(rec types.PendingRecord) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- rec:
+			return nil
+		}
+	})
+}
+
+func run(ctx context.Context, cfg *config.RootConfig, emit func(types.PendingRecord) error) error {
+	log := logging.Stage("fetch")
 	cl := httpclient.New(cfg.Yuheng.VerifySSL, cfg.Yuheng.TimeoutS)
 
-	fmt.Print("[Login] 尝试登录 ")
-	tok, err := login(cl, cfg)
-	if err != nil || tok == "" {
-		fmt.Println("失败")
+	start := time.Now()
+	tok, err := login(ctx, cl, cfg)
+	if err == nil && tok == "" {
+		err = fmt.Errorf("login returned no access token")
+	}
+	if err != nil {
+		log.Error("login failed", "error", err)
 		return err
 	}
-	fmt.Println("OK")
+	log.Info("logged in", logging.KeyDuration, time.Since(start))
+
+	pageNo := 1
+	pageSize := cfg.Yuheng.ListPageSize
+	total := 0
 
 	outFile := cfg.PendingAuditsPath()
 	outDir := filepath.Dir(outFile)
 	if err := os.MkdirAll(outDir, 0o755); err != nil {
 		return err
 	}
-	f, err := os.Create(outFile)
+	w, err := jsonl.Open(outFile, false)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	pageNo := 1
-	pageSize := cfg.Yuheng.ListPageSize
-	total := 0
+	defer w.Close()
+	prog := progress.Start(cfg, "fetch", 0)
+	defer prog.Stop()
+	stopped := func(err error) error {
+		prog.Stop()
+		if serr := w.Sync(); serr != nil {
+			return serr
+		}
+		log.Warn("fetch interrupted", "written", total, "file", filepath.Base(outFile))
+		return err
+	}
 
 	for {
-		fmt.Printf("[Fetch] List page %d ", pageNo)
-		items, err := fetchList(cl, cfg, tok, pageNo, pageSize)
+		if err := ctx.Err(); err != nil {
+			return stopped(err)
+		}
+		pageStart := time.Now()
+		items, err := fetchList(ctx, cl, cfg, tok, pageNo, pageSize)
+		if err != nil && ctx.Err() != nil {
+			return stopped(ctx.Err())
+		}
 		if err != nil {
-			fmt.Println("失败", err)
+			log.Error("list page failed", "page", pageNo, "error", err)
 			break
 		}
 		if len(items) == 0 {
-			fmt.Println("空")
+			log.Info("list page empty", "page", pageNo)
 			break
 		}
-		fmt.Printf("获取到 %d 个ID，开始获取详情...\n", len(items))
+		log.Info("list page fetched", "page", pageNo, "ids", len(items), logging.KeyDuration, time.Since(pageStart))
+		prog.AddTotal(len(items))
 
 		for _, it := range items {
+			if err := ctx.Err(); err != nil {
+				return stopped(err)
+			}
 			idVal, ok := it["id"].(float64)
 			if !ok {
+				prog.Skip()
 				continue
 			}
 			id := int(idVal)
-			detail, err := fetchDetail(cl, cfg, tok, id)
+			detail, err := fetchDetail(ctx, cl, cfg, tok, id)
+			if err != nil && ctx.Err() != nil {
+				return stopped(ctx.Err())
+			}
 			if err != nil || detail == nil {
-				fmt.Printf("  [Warn] 无法获取 ID %d 的详情\n", id)
+				log.Warn("fetch detail failed", logging.KeyRecordID, id, "error", err)
+				prog.Failure()
 				continue
 			}
 
@@ -94,9 +154,16 @@ func Run(cfg *config.RootConfig) error {
 				"fetched_at": utcISO(),
 			}
 			b, _ := json.Marshal(rec)
-			f.Write(b)
-			f.WriteString("\n")
+			if err := w.WriteLine(b); err != nil {
+				return err
+			}
 			total++
+			prog.Success()
+			if emit != nil {
+				if err := emit(types.PendingRecord{ID: id, Data: dataToSave}); err != nil {
+					return err
+				}
+			}
 		}
 
 		if len(items) < pageSize {
@@ -106,11 +173,12 @@ func Run(cfg *config.RootConfig) error {
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	fmt.Printf("[Success] 写入 %d 条到 %s\n", total, filepath.Base(outFile))
+	prog.Stop()
+	log.Info("fetch done", "written", total, "file", filepath.Base(outFile))
 	return nil
 }
 
-func login(cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
+func login(ctx context.Context, cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
 	fullURL, err := resolveURL(cfg.Yuheng.BaseURL, "/api/login")
 	if err != nil {
 		return "", err
@@ -120,7 +188,7 @@ func login(cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
 		"password": cfg.Yuheng.Password,
 	}
 	b, _ := json.Marshal(body)
-	req, _ := http.NewRequest(http.MethodPost, fullURL, bytes.NewReader(b))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	var out loginResp
 	code, err := cl.DoJSON(req, &out)
@@ -133,7 +201,7 @@ func login(cl *httpclient.Client, cfg *config.RootConfig) (string, error) {
 	return out.Data.AccessToken, nil
 }
 
-func fetchList(cl *httpclient.Client, cfg *config.RootConfig, token string, pageNo, pageSize int) ([]map[string]any, error) {
+func fetchList(ctx context.Context, cl *httpclient.Client, cfg *config.RootConfig, token string, pageNo, pageSize int) ([]map[string]any, error) {
 	endpoint := cfg.Yuheng.ListEndpoint
 	if endpoint == "" {
 		endpoint = "/api/lines/operation"
@@ -188,7 +256,7 @@ func fetchList(cl *httpclient.Client, cfg *config.RootConfig, token string, page
 			return nil, err
 		}
 		u.RawQuery = values.Encode()
-		req, _ = http.NewRequest(http.MethodGet, u.String(), nil)
+		req, _ = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	} else {
 		body := map[string]any{
 			"filters":   filters,
@@ -196,7 +264,7 @@ func fetchList(cl *httpclient.Client, cfg *config.RootConfig, token string, page
 			"page_size": pageSize,
 		}
 		b, _ := json.Marshal(body)
-		req, _ = http.NewRequest(http.MethodPost, fullURL, bytes.NewReader(b))
+		req, _ = http.NewRequestWithContext(ctx, http.MethodPost, fullURL, bytes.NewReader(b))
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -205,11 +273,11 @@ func fetchList(cl *httpclient.Client, cfg *config.RootConfig, token string, page
 
 	debug := strings.ToLower(os.Getenv("FETCH_DEBUG"))
 	if debug == "1" || debug == "true" || debug == "yes" {
+		u := fullURL
 		if req.Method == http.MethodGet {
-			fmt.Printf("[FetchDebug] %s %s\n", req.Method, req.URL.String())
-		} else {
-			fmt.Printf("[FetchDebug] %s %s\n", req.Method, fullURL)
+			u = req.URL.String()
 		}
+		logging.Stage("fetch").Info("list request", "method", req.Method, "url", u)
 	}
 
 	var out listResp
@@ -223,12 +291,12 @@ func fetchList(cl *httpclient.Client, cfg *config.RootConfig, token string, page
 	return out.Data.Data, nil
 }
 
-func fetchDetail(cl *httpclient.Client, cfg *config.RootConfig, token string, id int) (map[string]any, error) {
+func fetchDetail(ctx context.Context, cl *httpclient.Client, cfg *config.RootConfig, token string, id int) (map[string]any, error) {
 	fullURL, err := resolveURL(cfg.Yuheng.BaseURL, fmt.Sprintf("/api/operation_side/audit/lines/%d", id))
 	if err != nil {
 		return nil, err
 	}
-	req, _ := http.NewRequest(http.MethodGet, fullURL, nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Cookie", "AccessToken="+token+";")
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"crypto/tls"
+
+	"audit-workflow/internal/metrics"
+	"audit-workflow/internal/tracing"
 )
 
 type Client struct {
@@ -30,11 +34,21 @@ func New(verifySSL bool, timeoutSec float64) *Client {
 }
 
 func (c *Client) DoJSON(req *http.Request, out any) (int, error) {
+	endpoint := metrics.EndpointLabel(req.URL.Path)
+	_, span := tracing.Start(req.Context(), "platform "+req.Method+" "+endpoint,
+		"http.request.method", req.Method, "http.route", endpoint, "server.address", req.URL.Host)
+	defer span.End()
+	start := time.Now()
 	resp, err := c.inner.Do(req)
+	metrics.PlatformRequestSeconds.Observe(time.Since(start).Seconds(), endpoint)
 	if err != nil {
+		metrics.PlatformRequests.Inc(endpoint, "error")
+		span.RecordError(err)
 		return 0, err
 	}
 	defer resp.Body.Close()
+	metrics.PlatformRequests.Inc(endpoint, strconv.Itoa(resp.StatusCode))
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
 
 	if out == nil {
 		return resp.StatusCode, nil
@@ -44,6 +58,8 @@ func (c *Client) DoJSON(req *http.Request, out any) (int, error) {
 	cap.max = 2048
 	dec := json.NewDecoder(io.TeeReader(resp.Body, &cap))
 	if err := dec.Decode(out); err != nil {
+		// The error quotes the body, so the span only gets its type.
+		span.SetAttributes("error.type", "decode")
 		ct := resp.Header.Get("Content-Type")
 		prefix := bodyPrefix(cap.b, 200)
 		if looksLikeHTML(cap.b) {
@@ -0,0 +1,80 @@
+// Package jsonl writes the JSONL checkpoint files of the workflow.
+package jsonl
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+)
+
+// flushInterval bounds how long a written line may sit in the buffer.
+const flushInterval = time.Second
+
+// Writer appends lines to a file through a buffer. The buffer is flushed at
+// least every flushInterval while lines are written; Sync and Close also
+// fsync the file. Writer is safe for concurrent use.
+type Writer struct {
+	mu        sync.Mutex
+	f         *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+}
+
+// Open opens path for appending, or truncates it when appendMode is false.
+func Open(path string, appendMode bool) (*Writer, error) {
+	flag := os.O_CREATE | os.O_WRONLY
+	if appendMode {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f: f, w: bufio.NewWriterSize(f, 64*1024), lastFlush: time.Now()}, nil
+}
+
+// WriteLine writes b followed by a newline.
+func (w *Writer) WriteLine(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	if err := w.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	if time.Since(w.lastFlush) >= flushInterval {
+		w.lastFlush = time.Now()
+		return w.w.Flush()
+	}
+	return nil
+}
+
+// Sync flushes the buffer and fsyncs the file.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+func (w *Writer) sync() error {
+	w.lastFlush = time.Now()
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Close syncs and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter_AppendAndTruncate(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "out.jsonl")
+
+	w, err := Open(p, false)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := w.WriteLine([]byte(`{"id":1}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if b, _ := os.ReadFile(p); string(b) != "{\"id\":1}\n" {
+		t.Fatalf("after sync: %q", b)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	w, err = Open(p, true)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	_ = w.WriteLine([]byte(`{"id":2}`))
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if b, _ := os.ReadFile(p); string(b) != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Fatalf("after append: %q", b)
+	}
+
+	w, _ = Open(p, false)
+	_ = w.Close()
+	if b, _ := os.ReadFile(p); len(b) != 0 {
+		t.Fatalf("expected truncation, got %q", b)
+	}
+}
@@ -0,0 +1,126 @@
+// Package logging configures the process-wide slog logger from the log
+// section of the config.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/progress"
+)
+
+// Attribute keys shared by all stages.
+const (
+	KeyStage    = "stage"
+	KeyRecordID = "record_id"
+	KeyAttempt  = "attempt"
+	KeyDuration = "duration"
+	KeyProvider = "provider"
+)
+
+// Stage returns the default logger tagged with stage.
+func Stage(stage string) *slog.Logger {
+	return slog.Default().With(KeyStage, stage)
+}
+
+// Setup installs the default logger: cfg.Log.Format (text or json) at
+// cfg.Log.Level on stdout and, with cfg.Log.File, also as JSON to a per-run
+// file under the state dir. The returned func closes that file. Records on
+// stdout clear the progress status line first so they do not run into it.
+func Setup(cfg *config.RootConfig) (func() error, error) {
+	level, err := ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	handlers := []slog.Handler{newHandler(progress.Writer(os.Stdout), cfg.Log.Format, opts)}
+
+	closeFn := func() error { return nil }
+	if cfg.Log.File {
+		path := cfg.RunLogPath(time.Now())
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, slog.NewJSONHandler(f, opts))
+		closeFn = f.Close
+	}
+
+	var h slog.Handler = handlers[0]
+	if len(handlers) > 1 {
+		h = fanout(handlers)
+	}
+	slog.SetDefault(slog.New(h))
+	return closeFn, nil
+}
+
+// ParseLevel accepts debug, info, warn or error; empty means info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q", s)
+}
+
+func newHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if strings.EqualFold(strings.TrimSpace(format), "json") {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// fanout sends every record to all handlers that accept its level.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
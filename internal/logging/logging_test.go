@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audit-workflow/internal/config"
+)
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseLevel(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatalf("expected error for unknown level")
+	}
+}
+
+func TestSetup_RunLogFile(t *testing.T) {
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+
+	cfg := &config.RootConfig{
+		Paths: config.PathsConfig{StateDir: t.TempDir()},
+		Log:   config.LogConfig{Level: "warn", Format: "json", File: true},
+	}
+	closeLog, err := Setup(cfg)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	Stage("ai").Info("dropped below level")
+	Stage("ai").Warn("record failed", KeyRecordID, 7, KeyAttempt, 2)
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(cfg.StateDir(), "logs", "run-*.log"))
+	if len(files) != 1 {
+		t.Fatalf("expected one run log, got %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning, got %q", b)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["stage"] != "ai" || rec["record_id"] != float64(7) || rec["attempt"] != float64(2) || rec["level"] != "WARN" {
+		t.Fatalf("unexpected record: %v", rec)
+	}
+}
@@ -0,0 +1,283 @@
+// Package metrics keeps the workflow's Prometheus metrics and serves them in
+// the text exposition format. Metrics are always collected; they are only
+// exposed when a listener is started with Serve.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Workflow metrics.
+var (
+	PlatformRequests = NewCounterVec("audit_platform_requests_total",
+		"Requests to the Yuheng platform.", "endpoint", "status")
+	PlatformRequestSeconds = NewHistogramVec("audit_platform_request_duration_seconds",
+		"Latency of requests to the Yuheng platform.", DefaultBuckets, "endpoint")
+
+	ModelCalls = NewCounterVec("audit_model_calls_total",
+		"Chat model calls.", "provider", "stage", "outcome")
+	ModelCallSeconds = NewHistogramVec("audit_model_call_duration_seconds",
+		"Latency of chat model calls.", DefaultBuckets, "provider", "stage")
+	ModelTokens = NewCounterVec("audit_model_tokens_total",
+		"Tokens used by chat model calls, as reported by the provider.", "provider", "stage", "kind")
+
+	Records = NewCounterVec("audit_records_total",
+		"Records handled per workflow stage.", "stage", "outcome")
+
+	AIQueueDepth = NewGauge("audit_ai_queue_depth",
+		"Records waiting for an AI worker.")
+	AIInFlight = NewGauge("audit_ai_inflight_records",
+		"Records being analysed.")
+	AIConcurrency = NewGauge("audit_ai_concurrency_limit",
+		"Current AI concurrency limit.")
+)
+
+// DefaultBuckets suit HTTP and model latencies, in seconds.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type collector interface {
+	write(w io.Writer)
+	metricName() string
+}
+
+var registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func register(c collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// Write writes all metrics in the Prometheus text format.
+func Write(w io.Writer) {
+	registry.mu.Lock()
+	cs := append([]collector(nil), registry.collectors...)
+	registry.mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].metricName() < cs[j].metricName() })
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// Handler serves Write.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu   sync.Mutex
+	vals map[string]*sample
+}
+
+type sample struct {
+	lvs []string
+	v   float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, vals: map[string]*sample{}}
+	register(c)
+	return c
+}
+
+// Inc adds 1 for the given label values.
+func (c *CounterVec) Inc(lvs ...string) { c.Add(1, lvs...) }
+
+// Add adds v (>= 0) for the given label values.
+func (c *CounterVec) Add(v float64, lvs ...string) {
+	key := strings.Join(lvs, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.vals[key]
+	if !ok {
+		s = &sample{lvs: append([]string(nil), lvs...)}
+		c.vals[key] = s
+	}
+	s.v += v
+}
+
+// Value returns the current value for the given label values.
+func (c *CounterVec) Value(lvs ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.vals[strings.Join(lvs, "\xff")]; ok {
+		return s.v
+	}
+	return 0
+}
+
+func (c *CounterVec) metricName() string { return c.name }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, escapeHelp(c.help), c.name)
+	for _, key := range sortedKeys(c.vals) {
+		s := c.vals[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, s.lvs, "", ""), formatFloat(s.v))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu   sync.Mutex
+	vals map[string]*histogram
+}
+
+type histogram struct {
+	lvs    []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, vals: map[string]*histogram{}}
+	register(h)
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *HistogramVec) Observe(v float64, lvs ...string) {
+	key := strings.Join(lvs, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.vals[key]
+	if !ok {
+		s = &histogram{lvs: append([]string(nil), lvs...), counts: make([]uint64, len(h.buckets))}
+		h.vals[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) metricName() string { return h.name }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, escapeHelp(h.help), h.name)
+	for _, key := range sortedKeys(h.vals) {
+		s := h.vals[key]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, s.lvs, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, s.lvs, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, s.lvs, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, s.lvs, "", ""), s.count)
+	}
+}
+
+// Gauge is a single value that can go up and down.
+type Gauge struct {
+	name, help string
+
+	mu sync.Mutex
+	v  float64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.v += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+func (g *Gauge) metricName() string { return g.name }
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.Value()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	var parts []string
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		parts = append(parts, n+`="`+escapeLabel(v)+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// The text exposition format knows only these escapes; anything else,
+// control characters included, is written as is. Invalid UTF-8 is replaced
+// since the format must be valid UTF-8.
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(v, "\uFFFD"))
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(strings.ToValidUTF8(v, "\uFFFD"))
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite_TextFormat(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Test requests.", "endpoint", "status")
+	c.Inc("/api/x", "200")
+	c.Add(2, "/api/x", "200")
+	h := NewHistogramVec("test_latency_seconds", "Test latency.", []float64{0.1, 1}, "stage")
+	h.Observe(0.05, "risk")
+	h.Observe(0.5, "risk")
+	g := NewGauge("test_queue_depth", "Test queue.")
+	g.Set(4)
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	out := string(b)
+
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{endpoint="/api/x",status="200"} 3` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{stage="risk",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{stage="risk",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{stage="risk",le="+Inf"} 2` + "\n",
+		`test_latency_seconds_sum{stage="risk"} 0.55` + "\n",
+		`test_latency_seconds_count{stage="risk"} 2` + "\n",
+		"test_queue_depth 4\n",
+		"# TYPE audit_model_calls_total counter\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestWrite_EscapesLabelsAndHelp(t *testing.T) {
+	c := NewCounterVec("test_escape_total", "Escapes \\ and\nnewlines.", "v")
+	c.Inc("a\\b\"c\nd\te\x01\xff")
+	var sb strings.Builder
+	c.write(&sb)
+	want := "# HELP test_escape_total Escapes \\\\ and\\nnewlines.\n" +
+		"# TYPE test_escape_total counter\n" +
+		`test_escape_total{v="a\\b\"c\nd` + "\te\x01\uFFFD" + `"} 1` + "\n"
+	if got := sb.String(); got != want {
+		t.Fatalf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestEndpointLabel(t *testing.T) {
+	if got := EndpointLabel("/api/operation_side/lines/123/review"); got != "/api/operation_side/lines/:id/review" {
+		t.Fatalf("EndpointLabel = %q", got)
+	}
+}
+
+func TestServe_ScrapesAndStops(t *testing.T) {
+	stop, err := Serve("")
+	if err != nil || stop(context.Background()) != nil {
+		t.Fatalf("empty addr should start nothing: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	stop, err = Serve(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewGauge("test_served", "Test served.").Set(1)
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "test_served 1\n") {
+		t.Fatalf("scrape = %d:\n%s", resp.StatusCode, b)
+	}
+
+	if err := stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		t.Fatal("listener still serving after stop")
+	}
+}
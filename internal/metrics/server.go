@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Serve exposes /metrics on addr (for example ":9102") until the returned
+// func is called. An empty addr starts nothing.
+func Serve(addr string) (func(context.Context) error, error) {
+	if strings.TrimSpace(addr) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics listener stopped", "error", err)
+		}
+	}()
+	slog.Info("metrics listening", "addr", ln.Addr().String(), "path", "/metrics")
+	return srv.Shutdown, nil
+}
+
+// EndpointLabel turns a request path into a low-cardinality label by
+// replacing numeric segments with ":id".
+func EndpointLabel(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		if s != "" && strings.Trim(s, "0123456789") == "" {
+			segs[i] = ":id"
+		}
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,169 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/metrics"
+)
+
+// decreaseCooldown keeps a burst of failures from the same overload from
+// halving the limit several times.
+const decreaseCooldown = 2 * time.Second
+
+// concurrencyController is an AIMD limit on the number of records analysed
+// at once. Each healthy model call adds 1/limit, so the limit grows by about
+// one per round of calls; a throttled or timed-out call halves it. A nil
+// controller imposes no limit.
+type concurrencyController struct {
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	min, max     int
+	target       time.Duration
+	lastDecrease time.Time
+	// changed is closed and replaced whenever a slot may have freed up.
+	changed chan struct{}
+}
+
+// newConcurrencyController returns nil unless ai.adaptive.enabled is set.
+func newConcurrencyController(cfg *config.RootConfig) *concurrencyController {
+	a := cfg.AI.Adaptive
+	if !a.Enabled {
+		return nil
+	}
+	c := &concurrencyController{
+		min:     max(a.MinConcurrency, 1),
+		max:     max(a.MaxConcurrency, a.MinConcurrency, 1),
+		target:  time.Duration(a.LatencyTargetS * float64(time.Second)),
+		changed: make(chan struct{}),
+	}
+	c.limit = float64(min(max(cfg.AI.Concurrency, c.min), c.max))
+	return c
+}
+
+// Workers returns how many workers to start for n records (n <= 0 means
+// unknown) given the static concurrency.
+func (c *concurrencyController) Workers(concurrency, n int) int {
+	w := concurrency
+	if c != nil {
+		w = c.max
+	}
+	if n > 0 && w > n {
+		w = n
+	}
+	return max(w, 1)
+}
+
+// Limit returns the current effective concurrency.
+func (c *concurrencyController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// Acquire blocks until fewer than Limit records are in flight.
+func (c *concurrencyController) Acquire(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		if c.inFlight < int(c.limit) {
+			c.inFlight++
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (c *concurrencyController) Release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.inFlight--
+	c.notifyLocked()
+	c.mu.Unlock()
+}
+
+// Observe feeds the outcome of one model call into the limit.
+func (c *concurrencyController) Observe(latency time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := int(c.limit)
+	switch {
+	case err == nil && (c.target <= 0 || latency <= c.target):
+		c.limit = min(c.limit+1/c.limit, float64(c.max))
+	case isThrottleError(err):
+		if time.Since(c.lastDecrease) < decreaseCooldown {
+			return
+		}
+		c.lastDecrease = time.Now()
+		c.limit = max(c.limit/2, float64(c.min))
+	default:
+		// Slow calls and other errors hold the limit.
+		return
+	}
+	if now := int(c.limit); now != old {
+		reason := "healthy"
+		if err != nil {
+			reason = "throttled"
+		}
+		aiLog().Info("concurrency changed", "from", old, "to", now, "reason", reason)
+		metrics.AIConcurrency.Set(float64(now))
+		c.notifyLocked()
+	}
+}
+
+func (c *concurrencyController) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// describe formats the concurrency setting for the start message.
+func (c *concurrencyController) describe(static int) string {
+	if c == nil {
+		return fmt.Sprint(static)
+	}
+	return fmt.Sprintf("adaptive %d, %d-%d", c.Limit(), c.min, c.max)
+}
+
+// isThrottleError reports whether err looks like provider throttling or a
+// timeout: HTTP 429, rate-limit messages or a deadline.
+func isThrottleError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"429", "too many requests", "rate limit", "ratelimit", "timeout", "timed out"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"audit-workflow/internal/config"
+)
+
+func TestConcurrencyController_AIMD(t *testing.T) {
+	cfg := &config.RootConfig{AI: config.AIConfig{
+		Concurrency: 2,
+		Adaptive:    config.AIAdaptiveConfig{Enabled: true, MinConcurrency: 1, MaxConcurrency: 4, LatencyTargetS: 1},
+	}}
+	c := newConcurrencyController(cfg)
+	if c.Limit() != 2 || c.Workers(2, 100) != 4 || c.Workers(2, 3) != 3 {
+		t.Fatalf("unexpected start: limit %d workers %d", c.Limit(), c.Workers(2, 100))
+	}
+
+	// Healthy calls add about one slot per round; slow calls add nothing.
+	c.Observe(2*time.Second, nil)
+	if c.Limit() != 2 {
+		t.Fatalf("slow call changed the limit to %d", c.Limit())
+	}
+	for i := 0; i < 3; i++ {
+		c.Observe(10*time.Millisecond, nil)
+	}
+	if c.Limit() != 3 {
+		t.Fatalf("expected growth to 3, got %d", c.Limit())
+	}
+	for i := 0; i < 20; i++ {
+		c.Observe(time.Millisecond, nil)
+	}
+	if c.Limit() != 4 {
+		t.Fatalf("expected cap at 4, got %d", c.Limit())
+	}
+
+	// A burst of 429s halves the limit once.
+	c.Observe(time.Millisecond, errors.New("status 429: Too Many Requests"))
+	c.Observe(time.Millisecond, errors.New("status 429: Too Many Requests"))
+	if c.Limit() != 2 {
+		t.Fatalf("expected backoff to 2, got %d", c.Limit())
+	}
+	c.lastDecrease = time.Time{}
+	c.Observe(time.Millisecond, context.DeadlineExceeded)
+	c.lastDecrease = time.Time{}
+	c.Observe(time.Millisecond, context.DeadlineExceeded)
+	if c.Limit() != 1 {
+		t.Fatalf("expected floor at 1, got %d", c.Limit())
+	}
+	// Other errors leave the limit alone.
+	c.Observe(time.Millisecond, errors.New("invalid api key"))
+	if c.Limit() != 1 {
+		t.Fatalf("unexpected change on non-throttle error: %d", c.Limit())
+	}
+
+	// With limit 1 a second Acquire waits for Release.
+	ctx := context.Background()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan error, 1)
+	go func() { got <- c.Acquire(ctx) }()
+	select {
+	case <-got:
+		t.Fatalf("second Acquire did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Release()
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+	c.Release()
+}
+
+func TestConcurrencyController_Disabled(t *testing.T) {
+	var c *concurrencyController = newConcurrencyController(&config.RootConfig{AI: config.AIConfig{Concurrency: 3}})
+	if c != nil {
+		t.Fatalf("expected nil controller when disabled")
+	}
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.Release()
+	c.Observe(time.Millisecond, errors.New("429"))
+	if c.Workers(3, 10) != 3 || c.describe(3) != "3" {
+		t.Fatalf("unexpected nil controller behaviour")
+	}
+}
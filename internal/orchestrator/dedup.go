@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"audit-workflow/internal/components/parser"
+	"audit-workflow/internal/components/rules"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/tracing"
+	"audit-workflow/internal/types"
+)
+
+// Result data fields written for deduplicated records.
+const (
+	// dedupGroupField holds the group ID, on the representative and on
+	// every member.
+	dedupGroupField = "dedup_group"
+	// dedupOfField holds the representative's ID on members.
+	dedupOfField = "dedup_of"
+)
+
+// dedupGroups shares one model verdict among records whose trimmed context
+// is the same once hosts, IPs, timestamps and session tokens are masked:
+// the first record of a group (the representative) is analysed, the others
+// wait for it and copy its verdict. Groups live for one run.
+type dedupGroups struct {
+	cfg   *config.RootConfig
+	rules *rules.Set
+
+	mu     sync.Mutex
+	groups map[string]*dedupGroup
+}
+
+type dedupGroup struct {
+	id  string
+	rep any
+	// done is closed when the representative finished; verdict is then set
+	// unless it failed.
+	done    chan struct{}
+	verdict map[string]any
+}
+
+func newDedupGroups(cfg *config.RootConfig, ruleSet *rules.Set) *dedupGroups {
+	return &dedupGroups{cfg: cfg, rules: ruleSet, groups: map[string]*dedupGroup{}}
+}
+
+// fingerprint is the group ID of a record: a hash of its normalised
+// trimmed context and of the rule it matches, since rules can tell apart
+// records the context does not.
+func (d *dedupGroups) fingerprint(data map[string]any) string {
+	norm := make(map[string]any, len(contextFields))
+	for _, f := range contextFields {
+		s := firstString(data[f.key])
+		if limit := f.budget(d.cfg); limit > 0 {
+			// Mask before trimming so that hosts of different lengths do
+			// not move the cut; the margin leaves room for what the masks
+			// shorten.
+			s = truncate(s, 2*limit)
+		}
+		norm[f.key] = normalizeForFingerprint(s)
+	}
+	h := sha256.New()
+	h.Write([]byte(BuildTrimmedContext(d.cfg, norm)))
+	h.Write([]byte{0})
+	if r := d.rules.Match(data); r != nil {
+		h.Write([]byte(r.ID))
+	}
+	return "g-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// join returns the group of fp, creating it with id as the representative
+// when there is none; leader reports whether the caller is the
+// representative.
+func (d *dedupGroups) join(fp string, id any) (g *dedupGroup, leader bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if g, ok := d.groups[fp]; ok {
+		return g, false
+	}
+	g = &dedupGroup{id: fp, rep: id, done: make(chan struct{})}
+	d.groups[fp] = g
+	return g, true
+}
+
+// finish publishes the representative's verdict. A nil verdict (the
+// representative failed) removes the group so that a waiting member takes
+// over.
+func (d *dedupGroups) finish(g *dedupGroup, verdict map[string]any) {
+	d.mu.Lock()
+	if verdict == nil {
+		delete(d.groups, g.id)
+	}
+	g.verdict = verdict
+	d.mu.Unlock()
+	close(g.done)
+}
+
+// dedupVerdict is what analysing a record added to or changed in its data:
+// the fields a member copies from its representative.
+func dedupVerdict(before, after map[string]any) map[string]any {
+	v := map[string]any{}
+	for k, val := range after {
+		if k == "_raw" {
+			continue
+		}
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, val) {
+			v[k] = val
+		}
+	}
+	return v
+}
+
+// shareVerdict builds the result of member j from its group's verdict.
+func (a *analysis) shareVerdict(ctx context.Context, j job, g *dedupGroup, attrs []any) result {
+	_, span := tracing.Start(ctx, "record", "audit.record_id", types.RecordKey(j.rec.ID), "audit.dedup_of", types.RecordKey(g.rep))
+	defer span.End()
+	data := maps.Clone(j.rec.Data)
+	if data == nil {
+		data = map[string]any{}
+	}
+	for k, v := range g.verdict {
+		data[k] = v
+	}
+	data[dedupGroupField] = g.id
+	data[dedupOfField] = g.rep
+	s := &RecordState{
+		ID:          j.rec.ID,
+		Data:        data,
+		Context:     BuildTrimmedContext(a.cfg, j.rec.Data),
+		ScoreSource: "dedup",
+	}
+	s.Score, _ = parser.NormalizeRiskScore(data["risk_score"])
+	attrs = append(attrs, dedupGroupField, g.id, dedupOfField, g.rep)
+	attrs = append(attrs, recordLogAttrs(s)...)
+	a.writeTrace(newRecordTrace(s, nil, nil))
+	data = recordResultData(s)
+	line, err := a.resultLine(j, data)
+	if err != nil {
+		return result{idx: j.idx, id: j.rec.ID, attrs: attrs, fail: &RecordError{Class: FailureInternal, Err: err}}
+	}
+	return result{idx: j.idx, id: j.rec.ID, wrote: true, line: line, data: data, attrs: attrs, deduped: true}
+}
+
+// contextFields are the record fields BuildTrimmedContext reads, with their
+// budgets.
+var contextFields = []struct {
+	key    string
+	budget func(*config.RootConfig) int
+}{
+	{"name", func(c *config.RootConfig) int { return c.AI.Context.NameMaxRunes }},
+	{"description", func(c *config.RootConfig) int { return c.AI.Context.DescriptionMaxRunes }},
+	{"xray_poc_content", func(c *config.RootConfig) int { return c.AI.Context.POCMaxRunes }},
+	{"req_pkg", func(c *config.RootConfig) int { return c.AI.Context.ReqMaxRunes }},
+	{"resp_pkg", func(c *config.RootConfig) int { return c.AI.Context.RespMaxRunes }},
+}
+
+// fingerprintMasks replace what differs between copies of one finding on
+// different assets. Order matters: header lines and URLs go before the
+// generic IP, timestamp and token patterns.
+var fingerprintMasks = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Headers naming the target, carrying sessions or varying per response.
+	{regexp.MustCompile(`(?im)^((?:host|origin|referer|x-forwarded-for|x-forwarded-host|x-real-ip|cookie|set-cookie|authorization|date|expires|last-modified|etag|content-length|x-request-id|x-trace-id)\s*:).*$`), "$1 <v>"},
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/\s"'<>?#]+`), "$1<host>"},
+	{regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]*`), "<token>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`), "<ts>"},
+	{regexp.MustCompile(`\b(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2} GMT\b`), "<ts>"},
+	{regexp.MustCompile(`\b1\d{9}(?:\d{3})?\b`), "<ts>"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){2,7}[0-9a-f]{1,4}\b`), "<ip>"},
+	{regexp.MustCompile(`(?i)\b([\w-]*(?:token|session|sessid|sid|csrf|nonce|signature|sign|auth|ticket)[\w-]*)=[^&\s;"']+`), "$1=<token>"},
+	{regexp.MustCompile(`(?i)("[\w-]*(?:token|session|sessid|csrf|nonce|signature|ticket)[\w-]*"\s*:\s*)"[^"]*"`), `$1"<token>"`},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{16,}\b`), "<hex>"},
+}
+
+var spaceRun = regexp.MustCompile(`\s+`)
+
+// normalizeForFingerprint masks hosts, IPs, timestamps and session tokens
+// in s and collapses whitespace.
+func normalizeForFingerprint(s string) string {
+	for _, m := range fingerprintMasks {
+		s = m.re.ReplaceAllString(s, m.repl)
+	}
+	return strings.TrimSpace(spaceRun.ReplaceAllString(s, " "))
+}
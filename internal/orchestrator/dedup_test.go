@@ -0,0 +1,199 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"audit-workflow/internal/config"
+)
+
+func dedupTestConfig() *config.RootConfig {
+	return &config.RootConfig{AI: config.AIConfig{
+		Context: config.AIContextConfig{TotalMaxRunes: 2600, NameMaxRunes: 200, DescriptionMaxRunes: 1200, POCMaxRunes: 800, ReqMaxRunes: 400, RespMaxRunes: 400},
+	}}
+}
+
+func TestDedupFingerprint_IgnoresAssetDetails(t *testing.T) {
+	d := newDedupGroups(dedupTestConfig(), nil)
+	record := func(host, ip, cookie, date string) map[string]any {
+		return map[string]any{
+			"name":        "Apache Struts2 S2-045 远程代码执行",
+			"description": "目标 " + ip + " 存在漏洞",
+			"req_pkg": "POST http://" + host + "/index.action?token=" + cookie + " HTTP/1.1\r\n" +
+				"Host: " + host + "\r\nCookie: JSESSIONID=" + cookie + "\r\nX-Forwarded-For: " + ip + "\r\n\r\nx=1",
+			"resp_pkg": "HTTP/1.1 200 OK\r\nDate: " + date + "\r\nContent-Length: " + fmt.Sprint(len(host)) + "\r\n\r\nuid=0(root) at " + date,
+		}
+	}
+	a := d.fingerprint(record("a.example.com", "10.0.0.1", "0123456789abcdef0123", "Mon, 14 Oct 2026 08:00:00 GMT"))
+	b := d.fingerprint(record("very-long-host-name.internal.example.org:8443", "192.168.100.200", "fedcba9876543210fedc", "Tue, 15 Oct 2026 09:30:12 GMT"))
+	if a != b {
+		t.Fatalf("same finding on different assets should share a fingerprint: %s vs %s", a, b)
+	}
+	other := record("a.example.com", "10.0.0.1", "0123456789abcdef0123", "Mon, 14 Oct 2026 08:00:00 GMT")
+	other["req_pkg"] = strings.Replace(other["req_pkg"].(string), "index.action", "login.action", 1)
+	if d.fingerprint(other) == a {
+		t.Fatal("a different request path should change the fingerprint")
+	}
+}
+
+func TestDedupGroups_FailedRepresentativeHandsOver(t *testing.T) {
+	d := newDedupGroups(dedupTestConfig(), nil)
+	g, leader := d.join("g-1", 1)
+	if !leader {
+		t.Fatal("first record should lead its group")
+	}
+	g2, leader := d.join("g-1", 2)
+	if leader || g2 != g {
+		t.Fatal("second record should wait for the first")
+	}
+	d.finish(g, nil)
+	<-g.done
+	g3, leader := d.join("g-1", 2)
+	if !leader || g3 == g {
+		t.Fatal("after a failed representative the next record should lead")
+	}
+}
+
+func TestRunRiskAnalysis_DedupSharesVerdict(t *testing.T) {
+	backend := &fakeBackend{}
+	riskCalls := 0
+	backend.onRisk = func() { riskCalls++ }
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	cfg.AI.Dedup.Enabled = true
+
+	if err := os.MkdirAll(cfg.StateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for id, host := range map[int]string{1: "10.0.0.1", 2: "10.0.0.2", 3: "app.example.com", 4: ""} {
+		data := map[string]any{
+			"name":    "weblogic 反序列化",
+			"req_pkg": "GET http://" + host + "/wls-wsat/CoordinatorPortType HTTP/1.1\r\nHost: " + host,
+			"_raw":    map[string]any{"id": id},
+		}
+		if host == "" {
+			data["name"] = "目录遍历"
+			data["req_pkg"] = "GET /rec-4/../../etc/passwd"
+		}
+		b, _ := json.Marshal(map[string]any{"id": id, "data": data})
+		sb.Write(b)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(cfg.PendingAuditsPath(), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if riskCalls != 2 {
+		t.Fatalf("risk calls = %d, want one per distinct finding", riskCalls)
+	}
+
+	f, err := os.Open(cfg.PendingAuditsResultsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	groups := map[string][]string{}
+	members := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line struct {
+			ID   json.Number `json:"id"`
+			Data struct {
+				RiskScore any            `json:"risk_score"`
+				Group     string         `json:"dedup_group"`
+				Of        any            `json:"dedup_of"`
+				Raw       map[string]any `json:"_raw"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Data.RiskScore == nil || line.Data.Group == "" || line.Data.Raw == nil {
+			t.Fatalf("incomplete result: %s", sc.Text())
+		}
+		groups[line.Data.Group] = append(groups[line.Data.Group], line.ID.String())
+		if line.Data.Of != nil {
+			members++
+		}
+	}
+	if len(groups) != 2 || members != 2 {
+		t.Fatalf("groups = %v with %d members, want {1,2,3} and {4} with 2 members", groups, members)
+	}
+}
+
+func TestRunRiskAnalysis_DedupMembersHoldNoSlot(t *testing.T) {
+	// Two adaptive slots and three records: a representative, its member
+	// and an unrelated record. The representative's model calls wait for
+	// the unrelated record's, which can only start if the waiting member
+	// does not sit on the second slot.
+	backend := &fakeBackend{}
+	otherStarted := make(chan struct{})
+	var once sync.Once
+	starved := false
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/chat/completions" {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			switch {
+			case bytes.Contains(body, []byte("/other/")):
+				once.Do(func() { close(otherStarted) })
+			case bytes.Contains(body, []byte("wls-wsat")):
+				select {
+				case <-otherStarted:
+				case <-time.After(2 * time.Second):
+					mu.Lock()
+					starved = true
+					mu.Unlock()
+				}
+			}
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	cfg.AI.Dedup.Enabled = true
+	cfg.AI.Concurrency = 2
+	cfg.AI.Adaptive = config.AIAdaptiveConfig{Enabled: true, MinConcurrency: 1, MaxConcurrency: 3}
+
+	if err := os.MkdirAll(cfg.StateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for id, req := range []string{"GET http://10.0.0.1/wls-wsat/ HTTP/1.1", "GET http://10.0.0.2/wls-wsat/ HTTP/1.1", "GET /other/ HTTP/1.1"} {
+		b, _ := json.Marshal(map[string]any{"id": id + 1, "data": map[string]any{"name": "finding", "req_pkg": req}})
+		sb.Write(b)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(cfg.PendingAuditsPath(), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if starved {
+		t.Fatal("the unrelated record waited for the representative: the member held a slot")
+	}
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != "[1 2 3]" {
+		t.Fatalf("results: %s", got)
+	}
+}
@@ -0,0 +1,108 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/types"
+)
+
+// FailureRecord is one line of pending_audits_failures.jsonl. A record that
+// fails again gets a new line with Attempts incremented; the last line per
+// ID wins.
+type FailureRecord struct {
+	ID            any    `json:"id"`
+	ErrorClass    string `json:"error_class"`
+	Error         string `json:"error"`
+	Attempts      int    `json:"attempts"`
+	FirstFailedAt string `json:"first_failed_at"`
+	LastFailedAt  string `json:"last_failed_at"`
+}
+
+// LoadFailures reads the dead-letter file keyed by ID. A missing file yields
+// an empty map.
+func LoadFailures(path string) (map[string]FailureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]FailureRecord{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	recs := map[string]FailureRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec FailureRecord
+		if err := decodeLine([]byte(line), &rec); err != nil {
+			continue
+		}
+		if id := types.RecordKey(rec.ID); id != "" {
+			recs[id] = rec
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return recs, err
+	}
+	return recs, nil
+}
+
+// failureLog appends dead-letter entries, carrying attempt counts and the
+// first failure time over from earlier runs.
+type failureLog struct {
+	w     *jsonl.Writer
+	prev  map[string]FailureRecord
+	count int
+}
+
+func openFailureLog(path string) (*failureLog, error) {
+	prev, err := LoadFailures(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := jsonl.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &failureLog{w: w, prev: prev}, nil
+}
+
+// Record appends a failure of id and returns its attempt count.
+func (l *failureLog) Record(id any, re *RecordError) (int, error) {
+	now := utcISO()
+	rec := FailureRecord{
+		ID:            id,
+		ErrorClass:    re.Class,
+		Error:         re.Err.Error(),
+		Attempts:      1,
+		FirstFailedAt: now,
+		LastFailedAt:  now,
+	}
+	key := types.RecordKey(id)
+	if p, ok := l.prev[key]; ok {
+		rec.Attempts = p.Attempts + 1
+		rec.FirstFailedAt = p.FirstFailedAt
+	}
+	l.prev[key] = rec
+	l.count++
+
+	b, _ := json.Marshal(rec)
+	if err := l.w.WriteLine(b); err != nil {
+		return rec.Attempts, fmt.Errorf("write failures file failed: %w", err)
+	}
+	return rec.Attempts, nil
+}
+
+func (l *failureLog) Close() error {
+	return l.w.Close()
+}
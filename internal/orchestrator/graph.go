@@ -2,11 +2,13 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"audit-workflow/internal/components/tools/submit"
 	"audit-workflow/internal/config"
 	"audit-workflow/internal/fetch"
+	"audit-workflow/internal/stop"
 
 	"github.com/cloudwego/eino/compose"
 )
@@ -15,36 +17,96 @@ type WorkflowInput struct{}
 
 type WorkflowOutput struct{}
 
+type WorkflowOptions struct {
+	// Streaming runs the three stages concurrently through RunPipeline
+	// instead of one after another.
+	Streaming bool
+	Resume    bool
+	// RetryFailed makes the AI stage analyse only the IDs in the failures
+	// file. It cannot be combined with Streaming.
+	RetryFailed bool
+	// Stop, when closed, ends the run after the checkpoints of the current
+	// stage are synced; later stages are not started and the run fails with
+	// ErrInterrupted. Typically Shutdown.Stopping().
+	Stop <-chan struct{}
+}
+
 func BuildWorkflow(ctx context.Context, cfg *config.RootConfig) (compose.Runnable[WorkflowInput, WorkflowOutput], error) {
+	return BuildWorkflowWithOptions(ctx, cfg, WorkflowOptions{})
+}
+
+func BuildWorkflowWithOptions(ctx context.Context, cfg *config.RootConfig, opt WorkflowOptions) (compose.Runnable[WorkflowInput, WorkflowOutput], error) {
+	if opt.Streaming && opt.RetryFailed {
+		return nil, errors.New("retry of failed records is not supported in streaming mode")
+	}
+	tax, err := LoadTaxonomy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	graph := compose.NewGraph[WorkflowInput, WorkflowOutput]()
 
+	if opt.Streaming {
+		pipelineNode := compose.InvokableLambda(func(ctx context.Context, in WorkflowInput) (WorkflowOutput, error) {
+			return WorkflowOutput{}, RunPipeline(ctx, cfg, PipelineOptions{Resume: opt.Resume, Taxonomy: tax, Stop: opt.Stop})
+		})
+		if err := graph.AddLambdaNode("pipeline", pipelineNode, compose.WithNodeName("pipeline")); err != nil {
+			return nil, err
+		}
+		if err := graph.AddEdge(compose.START, "pipeline"); err != nil {
+			return nil, err
+		}
+		if err := graph.AddEdge("pipeline", compose.END); err != nil {
+			return nil, err
+		}
+		return graph.Compile(ctx, compose.WithGraphName("workflow"))
+	}
+
 	fetchNode := compose.InvokableLambda(func(ctx context.Context, in WorkflowInput) (WorkflowInput, error) {
-		if err := fetch.Run(cfg); err != nil {
+		fetchCtx, cancel := contextUntilStop(ctx, opt.Stop)
+		defer cancel()
+		if err := fetch.RunWithContext(fetchCtx, cfg); err != nil {
+			if stop.Requested(opt.Stop) {
+				return in, ErrInterrupted
+			}
 			return in, fmt.Errorf("fetch failed: %w", err)
 		}
 		return in, nil
 	})
-	if err := graph.AddLambdaNode("fetch", fetchNode); err != nil {
+	if err := graph.AddLambdaNode("fetch", fetchNode, compose.WithNodeName("fetch")); err != nil {
 		return nil, err
 	}
 
 	aiNode := compose.InvokableLambda(func(ctx context.Context, in WorkflowInput) (WorkflowInput, error) {
-		if err := RunRiskAnalysis(ctx, cfg); err != nil {
+		if stop.Requested(opt.Stop) {
+			return in, ErrInterrupted
+		}
+		if err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{Resume: opt.Resume, RetryFailed: opt.RetryFailed, Taxonomy: tax, Stop: opt.Stop}); err != nil {
+			if errors.Is(err, ErrInterrupted) {
+				return in, err
+			}
 			return in, fmt.Errorf("ai failed: %w", err)
 		}
 		return in, nil
 	})
-	if err := graph.AddLambdaNode("ai", aiNode); err != nil {
+	if err := graph.AddLambdaNode("ai", aiNode, compose.WithNodeName("ai")); err != nil {
 		return nil, err
 	}
 
 	submitNode := compose.InvokableLambda(func(ctx context.Context, in WorkflowInput) (WorkflowOutput, error) {
-		if err := submit.Run(cfg); err != nil {
+		if stop.Requested(opt.Stop) {
+			return WorkflowOutput{}, ErrInterrupted
+		}
+		sopt := submit.SubmitOptions{Resume: opt.Resume, Taxonomy: tax, Stop: opt.Stop}
+		if err := submit.RunWithContext(ctx, cfg, sopt); err != nil {
 			return WorkflowOutput{}, fmt.Errorf("submit failed: %w", err)
 		}
+		if stop.Requested(opt.Stop) {
+			return WorkflowOutput{}, ErrInterrupted
+		}
 		return WorkflowOutput{}, nil
 	})
-	if err := graph.AddLambdaNode("submit", submitNode); err != nil {
+	if err := graph.AddLambdaNode("submit", submitNode, compose.WithNodeName("submit")); err != nil {
 		return nil, err
 	}
 
@@ -61,7 +123,7 @@ func BuildWorkflow(ctx context.Context, cfg *config.RootConfig) (compose.Runnabl
 		return nil, err
 	}
 
-	compiled, err := graph.Compile(ctx)
+	compiled, err := graph.Compile(ctx, compose.WithGraphName("workflow"))
 	if err != nil {
 		return nil, err
 	}
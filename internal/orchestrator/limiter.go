@@ -0,0 +1,128 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"audit-workflow/internal/config"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// llmLimiter paces model requests against a request rate and a token per
+// minute budget. Both are token buckets shared by all workers and by the
+// tactic and risk calls; either may be disabled. A nil limiter never waits.
+type llmLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+}
+
+// newLLMLimiter returns nil when neither ai.rate_limit_qps nor
+// ai.rate_limit_tpm is set. rate_limit_qps may be fractional (0.5 is one
+// request every two seconds); rate_limit_burst defaults to
+// max(1, ceil(rate_limit_qps)). The TPM bucket holds one minute's budget.
+func newLLMLimiter(ai config.AIConfig) *llmLimiter {
+	now := time.Now()
+	l := &llmLimiter{}
+	if ai.RateLimitQPS > 0 {
+		burst := float64(ai.RateLimitBurst)
+		if burst <= 0 {
+			burst = math.Max(1, math.Ceil(ai.RateLimitQPS))
+		}
+		l.requests = newBucket(ai.RateLimitQPS, burst, now)
+	}
+	if ai.RateLimitTPM > 0 {
+		l.tokens = newBucket(float64(ai.RateLimitTPM)/60, float64(ai.RateLimitTPM), now)
+	}
+	if l.requests == nil && l.tokens == nil {
+		return nil
+	}
+	return l
+}
+
+// Wait blocks until a request carrying msgs fits both budgets. The prompt
+// size is estimated with estimateTokens.
+func (l *llmLimiter) Wait(ctx context.Context, msgs []*schema.Message) error {
+	if l == nil {
+		return nil
+	}
+	return l.wait(ctx, float64(estimateTokens(msgs)))
+}
+
+func (l *llmLimiter) wait(ctx context.Context, tokens float64) error {
+	l.mu.Lock()
+	now := time.Now()
+	var d time.Duration
+	if l.requests != nil {
+		d = max(d, l.requests.take(1, now))
+	}
+	if l.tokens != nil {
+		d = max(d, l.tokens.take(tokens, now))
+	}
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		// Hand the reservation back for the requests still waiting.
+		l.mu.Lock()
+		if l.requests != nil {
+			l.requests.tokens++
+		}
+		if l.tokens != nil {
+			l.tokens.tokens += tokens
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// bucket is a token bucket refilled at rate per second up to burst. Its
+// balance may go negative: callers reserve ahead and wait out the debt.
+type bucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newBucket(rate, burst float64, now time.Time) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take removes n and returns how long until the balance is back to zero.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// estimateTokens approximates the prompt size: one token per non-ASCII rune
+// (CJK text is close to that) and one per four ASCII characters.
+func estimateTokens(msgs []*schema.Message) int {
+	ascii, other := 0, 0
+	for _, m := range msgs {
+		if m == nil {
+			continue
+		}
+		for _, r := range m.Content {
+			if r < 0x80 {
+				ascii++
+			} else {
+				other++
+			}
+		}
+	}
+	return other + (ascii+3)/4
+}
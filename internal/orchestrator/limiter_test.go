@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"audit-workflow/internal/config"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestLLMLimiter_Disabled(t *testing.T) {
+	if l := newLLMLimiter(config.AIConfig{}); l != nil {
+		t.Fatalf("expected nil limiter without limits")
+	}
+	var l *llmLimiter
+	if err := l.Wait(context.Background(), nil); err != nil {
+		t.Fatalf("nil limiter waited: %v", err)
+	}
+}
+
+func TestLLMLimiter_FractionalRateAndBurst(t *testing.T) {
+	l := newLLMLimiter(config.AIConfig{RateLimitQPS: 0.5, RateLimitBurst: 2})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx, nil); err != nil {
+			t.Fatalf("burst request %d: %v", i, err)
+		}
+	}
+
+	// The third request needs 2s at 0.5 rps.
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(short, nil); err == nil {
+		t.Fatalf("expected the third request to wait")
+	}
+	// The cancelled reservation was handed back.
+	if got := l.requests.tokens; got < -0.01 || got > 0.01 {
+		t.Fatalf("expected an empty bucket after refund, got %.2f", got)
+	}
+}
+
+func TestLLMLimiter_TokensPerMinute(t *testing.T) {
+	// 6000 TPM refills 100 tokens per second.
+	l := newLLMLimiter(config.AIConfig{RateLimitTPM: 6000})
+	big := []*schema.Message{schema.UserMessage(strings.Repeat("漏", 6000))}
+	ctx := context.Background()
+	if err := l.Wait(ctx, big); err != nil {
+		t.Fatalf("first request within budget: %v", err)
+	}
+	start := time.Now()
+	if err := l.Wait(ctx, []*schema.Message{schema.UserMessage(strings.Repeat("a", 20))}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("expected the next request to wait for refill, waited %s", d)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	msgs := []*schema.Message{schema.SystemMessage("abcdefgh"), schema.UserMessage("风险评估"), nil}
+	if got := estimateTokens(msgs); got != 6 {
+		t.Fatalf("estimateTokens = %d, want 6", got)
+	}
+}
@@ -0,0 +1,299 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	promptcomp "audit-workflow/internal/components/prompt"
+	"audit-workflow/internal/config"
+)
+
+// Run statuses recorded in the manifest.
+const (
+	RunRunning     = "running"
+	RunCompleted   = "completed"
+	RunInterrupted = "interrupted"
+	RunFailed      = "failed"
+)
+
+// RunManifest describes one AI run: what produced its results and how it
+// ended. It is written to runs/<run_id>.json when the run starts and
+// rewritten when it ends.
+type RunManifest struct {
+	RunID     string `json:"run_id"`
+	Mode      string `json:"mode"`
+	Resume    bool   `json:"resume"`
+	Shard     string `json:"shard,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	StartedAt string `json:"started_at"`
+	EndedAt   string `json:"ended_at,omitempty"`
+
+	Build        BuildInfo      `json:"build"`
+	Provider     string         `json:"provider"`
+	Model        string         `json:"model"`
+	PromptHash   string         `json:"prompt_hash"`
+	TaxonomyPath string         `json:"taxonomy_path"`
+	TaxonomyHash string         `json:"taxonomy_hash"`
+	RulesHash    string         `json:"rules_hash,omitempty"`
+	PolicyHash   string         `json:"policy_hash,omitempty"`
+	Config       map[string]any `json:"config"`
+
+	Counts RunCounts `json:"counts"`
+}
+
+// RunCounts are the record totals of a run. Skipped counts records not
+// analysed because of Resume or RetryFailed, or reused in streaming mode;
+// Deduplicated counts written records that took their group's verdict.
+type RunCounts struct {
+	Items        int `json:"items"`
+	Written      int `json:"written"`
+	Failed       int `json:"failed"`
+	Skipped      int `json:"skipped"`
+	Interrupted  int `json:"interrupted"`
+	Deduplicated int `json:"deduplicated,omitempty"`
+	// Records policies handled instead of the model, by action.
+	PolicySkipped  int `json:"policy_skipped,omitempty"`
+	PolicyRejected int `json:"policy_rejected,omitempty"`
+	PolicyManual   int `json:"policy_manual,omitempty"`
+}
+
+// BuildInfo identifies the binary from its embedded build information; no
+// git checkout is needed at run time.
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Module    string            `json:"module,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Platform  string            `json:"platform"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+// provenance is stamped onto every result line of a run.
+type provenance struct {
+	RunID        string
+	PromptHash   string
+	TaxonomyHash string
+	Shard        string
+}
+
+// runManifest keeps a run's manifest up to date on disk.
+type runManifest struct {
+	mu   sync.Mutex
+	path string
+	m    RunManifest
+}
+
+// startRunManifest fills in the manifest of a new run and writes it. mode
+// is batch, retry_failed or stream.
+func startRunManifest(cfg *config.RootConfig, mode string, opt RiskAnalysisOptions) (*runManifest, error) {
+	promptHash, err := promptcomp.TemplateHash(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("hash prompt template failed: %w", err)
+	}
+	taxPath := cfg.ATTCKCSVPath()
+	taxHash, err := fileHash(taxPath)
+	if err != nil {
+		return nil, fmt.Errorf("hash taxonomy failed: %w", err)
+	}
+	var rulesHash string
+	if p := strings.TrimSpace(cfg.AI.RulesPath); p != "" {
+		if rulesHash, err = fileHash(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("hash rules failed: %w", err)
+		}
+	}
+	var policyHash string
+	if p := strings.TrimSpace(cfg.AI.PolicyPath); p != "" {
+		if policyHash, err = fileHash(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("hash policies failed: %w", err)
+		}
+	}
+	redacted, err := redactedConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newRunID(time.Now())
+	rm := &runManifest{
+		path: cfg.RunManifestPath(id),
+		m: RunManifest{
+			RunID:        id,
+			Mode:         mode,
+			Resume:       opt.Resume,
+			Shard:        opt.Shard.String(),
+			Status:       RunRunning,
+			StartedAt:    utcISO(),
+			Build:        buildInfo(),
+			Provider:     cfg.AI.Provider,
+			Model:        cfg.AI.Model,
+			PromptHash:   promptHash,
+			TaxonomyPath: taxPath,
+			TaxonomyHash: taxHash,
+			RulesHash:    rulesHash,
+			PolicyHash:   policyHash,
+			Config:       redacted,
+		},
+	}
+	if err := os.MkdirAll(filepath.Dir(rm.path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := rm.write(); err != nil {
+		return nil, fmt.Errorf("write run manifest failed: %w", err)
+	}
+	aiLog().Info("run started", "run_id", id, "manifest", rm.path)
+	return rm, nil
+}
+
+func (rm *runManifest) provenance() provenance {
+	if rm == nil {
+		return provenance{}
+	}
+	return provenance{RunID: rm.m.RunID, PromptHash: rm.m.PromptHash, TaxonomyHash: rm.m.TaxonomyHash, Shard: rm.m.Shard}
+}
+
+// count applies f to the counts.
+func (rm *runManifest) count(f func(*RunCounts)) {
+	rm.mu.Lock()
+	f(&rm.m.Counts)
+	rm.mu.Unlock()
+}
+
+// finish records how the run ended and rewrites the manifest. A failed write
+// is logged: the run's own outcome matters more.
+func (rm *runManifest) finish(err error) {
+	rm.mu.Lock()
+	rm.m.EndedAt = utcISO()
+	switch {
+	case err == nil:
+		rm.m.Status = RunCompleted
+	case errors.Is(err, ErrInterrupted), errors.Is(err, context.Canceled):
+		rm.m.Status = RunInterrupted
+	default:
+		rm.m.Status = RunFailed
+		rm.m.Error = err.Error()
+	}
+	rm.mu.Unlock()
+	if werr := rm.write(); werr != nil {
+		aiLog().Warn("write run manifest failed", "run_id", rm.m.RunID, "error", werr)
+	}
+}
+
+func (rm *runManifest) write() error {
+	rm.mu.Lock()
+	b, err := json.MarshalIndent(rm.m, "", "  ")
+	rm.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := rm.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rm.path)
+}
+
+// LoadRunManifest reads the manifest of runID.
+func LoadRunManifest(cfg *config.RootConfig, runID string) (*RunManifest, error) {
+	b, err := os.ReadFile(cfg.RunManifestPath(runID))
+	if err != nil {
+		return nil, err
+	}
+	var m RunManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func newRunID(t time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func buildInfo() BuildInfo {
+	bi := BuildInfo{GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+	bi.Module, bi.Version = info.Main.Path, info.Main.Version
+	for _, s := range info.Settings {
+		// vcs.* is only present when the toolchain embedded it at build time.
+		if strings.HasPrefix(s.Key, "vcs.") || s.Key == "-tags" || s.Key == "CGO_ENABLED" {
+			if bi.Settings == nil {
+				bi.Settings = map[string]string{}
+			}
+			bi.Settings[s.Key] = s.Value
+		}
+	}
+	return bi
+}
+
+// redactedConfig returns cfg as JSON with credential-like values masked.
+// API keys are never serialised; passwords, tokens and secrets (also inside
+// list_filters) are replaced here.
+func redactedConfig(cfg *config.RootConfig) (map[string]any, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	redact(m)
+	return m, nil
+}
+
+func redact(v any) {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, val := range x {
+			if s, ok := val.(string); ok && s != "" && isSecretKey(k) {
+				x[k] = "***"
+				continue
+			}
+			redact(val)
+		}
+	case []any:
+		for _, val := range x {
+			redact(val)
+		}
+	}
+}
+
+func isSecretKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, s := range []string{"password", "passwd", "secret", "token", "api_key", "apikey", "authorization", "cookie"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
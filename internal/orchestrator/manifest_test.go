@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunRiskAnalysis_WritesManifestAndStampsResults(t *testing.T) {
+	backend := &fakeBackend{failRisk: map[string]bool{"2": true}}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	cfg.Yuheng.Password = "hunter2"
+	cfg.Yuheng.ListFilters = map[string]any{"session_token": "abc", "status": "open"}
+	cfg.AI.APIKey = "sk-secret"
+	cfg.AI.RecordTrace = "jsonl"
+	writePending(t, cfg, 1, 2, 3)
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	f, err := os.Open(cfg.PendingAuditsResultsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var runID, promptHash, taxHash string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line struct {
+			RunID        string `json:"run_id"`
+			PromptHash   string `json:"prompt_hash"`
+			TaxonomyHash string `json:"taxonomy_hash"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.RunID == "" || len(line.PromptHash) != 64 || len(line.TaxonomyHash) != 64 {
+			t.Fatalf("result line not stamped: %s", sc.Text())
+		}
+		if runID != "" && line.RunID != runID {
+			t.Fatalf("run ID changed within a run: %s vs %s", line.RunID, runID)
+		}
+		runID, promptHash, taxHash = line.RunID, line.PromptHash, line.TaxonomyHash
+	}
+
+	m, err := LoadRunManifest(cfg, runID)
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	if m.Status != RunCompleted || m.Mode != "batch" || m.StartedAt == "" || m.EndedAt == "" {
+		t.Fatalf("unexpected manifest header: %+v", m)
+	}
+	if m.PromptHash != promptHash || m.TaxonomyHash != taxHash || m.Provider != "openai" || m.Model != "test" || m.Build.GoVersion == "" {
+		t.Fatalf("unexpected manifest provenance: %+v", m)
+	}
+	if want := (RunCounts{Items: 3, Written: 2, Failed: 1}); m.Counts != want {
+		t.Fatalf("counts = %+v, want %+v", m.Counts, want)
+	}
+	raw, _ := os.ReadFile(cfg.RunManifestPath(runID))
+	for _, secret := range []string{"hunter2", "abc", "sk-secret"} {
+		if strings.Contains(string(raw), secret) {
+			t.Fatalf("manifest leaks %q:\n%s", secret, raw)
+		}
+	}
+	filters := m.Config["yuheng"].(map[string]any)["list_filters"].(map[string]any)
+	if filters["session_token"] != "***" || filters["status"] != "open" {
+		t.Fatalf("unexpected redaction: %v", filters)
+	}
+
+	tr, err := LoadRecordTrace(cfg.RecordTracesPath(), "1")
+	if err != nil || tr.RunID != runID {
+		t.Fatalf("trace not stamped with run ID: %+v, %v", tr, err)
+	}
+}
@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"audit-workflow/internal/components/rules"
+	"audit-workflow/internal/types"
+)
+
+// maxPendingLine is the longest pending_audits.jsonl line the AI stage reads.
+const maxPendingLine = 16 * 1024 * 1024
+
+// pendingReader streams pending_audits.jsonl one record at a time. Records
+// are returned without the bulk of data["_raw"] (only the keepRaw keys are
+// kept), which the prompts never see; the full raw detail is read back from
+// the file through the record's lineRef when its result line is written.
+type pendingReader struct {
+	f  *os.File
+	sc *bufio.Scanner
+	// keepRaw are the keys of data["_raw"] Next keeps; rules.RawFields by
+	// default.
+	keepRaw []string
+	// off is the file offset of the next unread byte.
+	off  int64
+	line lineRef
+}
+
+// lineRef locates a record's line in a JSONL file.
+type lineRef struct {
+	off int64
+	n   int
+}
+
+func openPendingReader(path string) (*pendingReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &pendingReader{f: f, sc: bufio.NewScanner(f), keepRaw: rules.RawFields}
+	r.sc.Buffer(make([]byte, 0, 64*1024), maxPendingLine)
+	r.sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			r.line = lineRef{off: r.off, n: len(token)}
+		}
+		r.off += int64(advance)
+		return advance, token, err
+	})
+	return r, nil
+}
+
+// Next returns the next record whose ID want accepts and where it is in the
+// file; other records are not decoded beyond their ID, and lines that are
+// not valid JSON are skipped. It returns io.EOF after the last record.
+func (r *pendingReader) Next(want func(id any) bool) (types.PendingRecord, lineRef, error) {
+	for r.sc.Scan() {
+		line := r.sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var head struct {
+			ID any `json:"id"`
+		}
+		if decodeLine(line, &head) != nil || !want(head.ID) {
+			continue
+		}
+		rec, err := decodePromptRecord(line, r.keepRaw)
+		if err != nil {
+			continue
+		}
+		return rec, r.line, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return types.PendingRecord{}, lineRef{}, err
+	}
+	return types.PendingRecord{}, lineRef{}, io.EOF
+}
+
+// Raw reads back the full data["_raw"] of the record at ref. It may be
+// called concurrently with Next and with itself.
+func (r *pendingReader) Raw(ref lineRef, id any) (json.RawMessage, error) {
+	buf, err := readLine(r.f, ref)
+	if err != nil {
+		return nil, fmt.Errorf("read pending record failed: %w", err)
+	}
+	var rec struct {
+		ID   any `json:"id"`
+		Data struct {
+			Raw json.RawMessage `json:"_raw"`
+		} `json:"data"`
+	}
+	if err := decodeLine(buf, &rec); err != nil {
+		return nil, fmt.Errorf("decode pending record failed: %w", err)
+	}
+	if types.RecordKey(rec.ID) != types.RecordKey(id) {
+		return nil, fmt.Errorf("pending file changed during the run: expected ID %s at offset %d, found %v", types.RecordKey(id), ref.off, rec.ID)
+	}
+	return rec.Data.Raw, nil
+}
+
+func (r *pendingReader) Close() error {
+	return r.f.Close()
+}
+
+// decodePromptRecord decodes a pending line keeping every data field except
+// _raw, of which only the keepRaw keys survive. The ID stays a json.Number
+// so that IDs beyond float64 precision keep their exact key.
+func decodePromptRecord(line []byte, keepRaw []string) (types.PendingRecord, error) {
+	var head struct {
+		ID   any                        `json:"id"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := decodeLine(line, &head); err != nil {
+		return types.PendingRecord{}, err
+	}
+	rec := types.PendingRecord{ID: head.ID}
+	if head.Data == nil {
+		return rec, nil
+	}
+	rec.Data = make(map[string]any, len(head.Data))
+	for k, v := range head.Data {
+		if k == "_raw" {
+			continue
+		}
+		var val any
+		if err := json.Unmarshal(v, &val); err != nil {
+			return types.PendingRecord{}, err
+		}
+		rec.Data[k] = val
+	}
+	if raw, ok := head.Data["_raw"]; ok {
+		var full map[string]json.RawMessage
+		if json.Unmarshal(raw, &full) == nil && full != nil {
+			kept := map[string]any{}
+			for _, k := range keepRaw {
+				var val any
+				if v, ok := full[k]; ok && json.Unmarshal(v, &val) == nil {
+					kept[k] = val
+				}
+			}
+			rec.Data["_raw"] = kept
+		}
+	}
+	return rec, nil
+}
+
+// countPending counts the records of path and those of them want selects,
+// decoding only the IDs, so progress and the worker count can be sized
+// before the records themselves are read.
+func countPending(path string, want func(id any) bool) (records, selected int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), maxPendingLine)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var head struct {
+			ID any `json:"id"`
+		}
+		if decodeLine(line, &head) != nil {
+			continue
+		}
+		records++
+		if want(head.ID) {
+			selected++
+		}
+	}
+	return records, selected, sc.Err()
+}
@@ -0,0 +1,159 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audit-workflow/internal/types"
+)
+
+func TestPendingReader_LongLinesAndRaw(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "pending.jsonl")
+
+	long := strings.Repeat("x", 200*1024)
+	lines := []string{
+		`{"id":1,"data":{"name":"a","_raw":{"cve_id":"CVE-2024-1","poc_id":"p1","body":"` + long + `"}}}`,
+		"",
+		`not json`,
+		`{"id":2,"data":{"description":"` + long + `"}}` + "\r",
+	}
+	if err := os.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	all := func(any) bool { return true }
+	r, err := openPendingReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rec, ref1, err := r.Next(all)
+	if err != nil || types.RecordKey(rec.ID) != "1" {
+		t.Fatalf("first record = %+v, %v", rec, err)
+	}
+	raw, _ := rec.Data["_raw"].(map[string]any)
+	if len(raw) != 2 || raw["cve_id"] != "CVE-2024-1" || raw["poc_id"] != "p1" {
+		t.Fatalf("prompt record should keep only the rule fields of _raw: %v", raw)
+	}
+	rec2, ref2, err := r.Next(all)
+	if err != nil || types.RecordKey(rec2.ID) != "2" || len(rec2.Data["description"].(string)) != len(long) {
+		t.Fatalf("second record = %v, %v", rec2.ID, err)
+	}
+	if _, _, err := r.Next(all); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	full, err := r.Raw(ref1, rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail map[string]string
+	if err := json.Unmarshal(full, &detail); err != nil || detail["body"] != long {
+		t.Fatalf("Raw did not return the full detail: %v", err)
+	}
+	if full, err := r.Raw(ref2, rec2.ID); err != nil || full != nil {
+		t.Fatalf("record without _raw: %s, %v", full, err)
+	}
+	if _, err := r.Raw(ref2, 1); err == nil {
+		t.Fatal("Raw should reject a ref that points at another ID")
+	}
+}
+
+func TestRunRiskAnalysis_ResultsKeepFullRaw(t *testing.T) {
+	srv := httptest.NewServer(&fakeBackend{})
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	writePending(t, cfg, 1, 2, 3)
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	f, err := os.Open(cfg.PendingAuditsResultsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line struct {
+			ID   float64 `json:"id"`
+			Data struct {
+				Name      string         `json:"name"`
+				RiskScore any            `json:"risk_score"`
+				Raw       map[string]any `json:"_raw"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Data.Raw["id"] != line.ID || line.Data.Name == "" || line.Data.RiskScore == nil {
+			t.Fatalf("result line lost record data: %s", sc.Text())
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 result lines, got %d", n)
+	}
+}
+
+func TestRunRiskAnalysis_IDsBeyondFloatPrecision(t *testing.T) {
+	backend := &fakeBackend{}
+	riskCalls := 0
+	backend.onRisk = func() { riskCalls++ }
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	// 2^53+1 has no float64 of its own; it must keep its exact key from
+	// the pending file through results, resume and sharding.
+	const big = 9007199254740993
+	writePending(t, cfg, 1, big)
+
+	if total, selected, err := countPending(cfg.PendingAuditsPath(), func(id any) bool {
+		return types.RecordKey(id) == "9007199254740993"
+	}); err != nil || total != 2 || selected != 1 {
+		t.Fatalf("countPending = %d, %d, %v", total, selected, err)
+	}
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "[1 9007199254740993]"
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != want || riskCalls != 2 {
+		t.Fatalf("results %s with %d risk calls, want %s", got, riskCalls, want)
+	}
+	if failures, _ := LoadFailures(cfg.PendingAuditsFailuresPath()); len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if riskCalls != 2 {
+		t.Fatalf("resume analysed again: %d risk calls", riskCalls)
+	}
+
+	ids, err := CountShards(cfg.PendingAuditsPath(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Shard{Index: shardOf(json.Number("9007199254740993"), 4), Count: 4}
+	r, err := openPendingReader(cfg.PendingAuditsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rec, _, err := r.Next(func(id any) bool { return s.Contains(id) && types.RecordKey(id) != "1" })
+	if err != nil || types.RecordKey(rec.ID) != "9007199254740993" || ids[s.Index-1] == 0 {
+		t.Fatalf("shard %d: %v, %v (counts %v)", s.Index, rec.ID, err, ids)
+	}
+}
@@ -0,0 +1,121 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"audit-workflow/internal/components/tools/submit"
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/fetch"
+	"audit-workflow/internal/stop"
+	"audit-workflow/internal/tracing"
+	"audit-workflow/internal/types"
+)
+
+type PipelineOptions struct {
+	// Resume skips IDs already analysed (their results are submitted as
+	// they are) and IDs already submitted.
+	Resume bool
+	// Taxonomy is loaded from cfg.ATTCKCSVPath() when nil.
+	Taxonomy *taxonomy.Taxonomy
+	// Buffer is the capacity of the channels between stages; a full channel
+	// blocks the upstream stage. Defaults to ai.concurrency.
+	Buffer int
+	// Stop, when closed, stops fetching and starting new analyses; records
+	// already analysed are still submitted. See Shutdown.
+	Stop <-chan struct{}
+}
+
+// RunPipeline runs fetch, AI and submit at the same time: fetched records
+// flow straight into the AI workers and scored results straight into
+// submission. pending_audits.jsonl, pending_audits_results.jsonl and
+// submitted_ids.jsonl are written as in the staged run, so a later staged or
+// resumed run picks up where this one stopped. The first stage error cancels
+// the others. A run stopped through opt.Stop returns ErrInterrupted.
+func RunPipeline(ctx context.Context, cfg *config.RootConfig, opt PipelineOptions) error {
+	tax := opt.Taxonomy
+	if tax == nil {
+		var err error
+		tax, err = LoadTaxonomy(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	buffer := opt.Buffer
+	if buffer <= 0 {
+		buffer = cfg.AI.Concurrency
+	}
+	if buffer <= 0 {
+		buffer = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fetched := make(chan types.PendingRecord, buffer)
+	analyzed := make(chan types.RiskRecord, buffer)
+
+	type stageErr struct {
+		stage string
+		err   error
+	}
+	errCh := make(chan stageErr, 3)
+	fetchCtx, cancelFetch := contextUntilStop(ctx, opt.Stop)
+	defer cancelFetch()
+	go func() {
+		fetchCtx, span := tracing.Start(fetchCtx, "fetch")
+		defer span.End()
+		err := fetch.Stream(fetchCtx, cfg, fetched)
+		if err != nil && stop.Requested(opt.Stop) && ctx.Err() == nil {
+			// Stopped on request; the AI stage reports the interruption.
+			err = nil
+		}
+		span.RecordError(err)
+		errCh <- stageErr{"fetch", err}
+	}()
+	go func() {
+		ctx, span := tracing.Start(ctx, "ai")
+		defer span.End()
+		err := RunRiskAnalysisStream(ctx, cfg, RiskAnalysisOptions{Resume: opt.Resume, Taxonomy: tax, Stop: opt.Stop}, fetched, analyzed)
+		if errors.Is(err, ErrInterrupted) {
+			err = nil
+		}
+		span.RecordError(err)
+		errCh <- stageErr{"ai", err}
+	}()
+	go func() {
+		ctx, span := tracing.Start(ctx, "submit")
+		defer span.End()
+		err := submit.Stream(ctx, cfg, submit.SubmitOptions{Resume: opt.Resume, Taxonomy: tax}, analyzed)
+		if err != nil {
+			// Unblock the AI stage if submit stopped before draining.
+			cancel()
+		}
+		span.RecordError(err)
+		errCh <- stageErr{"submit", err}
+	}()
+
+	var first error
+	for i := 0; i < 3; i++ {
+		r := <-errCh
+		if r.err == nil || first != nil {
+			continue
+		}
+		if errors.Is(r.err, context.Canceled) && ctx.Err() != nil {
+			// Cancelled because another stage failed; report that one.
+			continue
+		}
+		first = fmt.Errorf("%s failed: %w", r.stage, r.err)
+		cancel()
+	}
+	if first == nil && stop.Requested(opt.Stop) {
+		return ErrInterrupted
+	}
+	if first == nil && ctx.Err() != nil {
+		// The caller's context was cancelled.
+		return ctx.Err()
+	}
+	return first
+}
@@ -0,0 +1,313 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/metrics"
+	"audit-workflow/internal/types"
+)
+
+// fakeBackend serves the Yuheng endpoints used by fetch/submit and an
+// OpenAI-compatible chat endpoint answering both prompts.
+type fakeBackend struct {
+	ids []int
+
+	mu        sync.Mutex
+	submitted []string
+	// failRisk makes the risk call fail for these IDs.
+	failRisk map[string]bool
+	// onRisk, if set, is called before answering each risk call.
+	onRisk func()
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON := func(v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.URL.Path == "/api/login":
+		writeJSON(map[string]any{"data": map[string]any{"access_token": "tok"}})
+	case r.URL.Path == "/api/lines/operation":
+		items := []map[string]any{}
+		if r.URL.Query().Get("page_no") == "1" {
+			for _, id := range b.ids {
+				items = append(items, map[string]any{"id": id})
+			}
+		}
+		writeJSON(map[string]any{"data": map[string]any{"data": items}})
+	case strings.HasPrefix(r.URL.Path, "/api/operation_side/audit/lines/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/operation_side/audit/lines/")
+		writeJSON(map[string]any{"data": map[string]any{"id": json.Number(id), "name": "record " + id, "req_pkg": "GET /rec-" + id + "/"}})
+	case strings.HasPrefix(r.URL.Path, "/api/operation_side/lines/") && r.Method == http.MethodPut:
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/operation_side/lines/"), "/review")
+		b.mu.Lock()
+		b.submitted = append(b.submitted, id)
+		b.mu.Unlock()
+		writeJSON(map[string]any{"code": 0})
+	case r.URL.Path == "/v1/chat/completions":
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		prompt := ""
+		if len(req.Messages) > 0 {
+			prompt = req.Messages[0].Content
+		}
+		reply := `{"risk_score": 7, "level_id": 2, "technique_name": "利用面向公众的应用程序"}`
+		if strings.Contains(prompt, "候选战术列表") {
+			reply = `{"tactic_name": "初始访问"}`
+		} else {
+			b.mu.Lock()
+			if b.onRisk != nil {
+				b.onRisk()
+			}
+			for id := range b.failRisk {
+				if strings.Contains(prompt, "/rec-"+id+"/") {
+					reply = ""
+				}
+			}
+			b.mu.Unlock()
+		}
+		if reply == "" {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(map[string]any{"choices": []map[string]any{{"message": map[string]any{"content": reply}}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *fakeBackend) submittedIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := append([]string(nil), b.submitted...)
+	sort.Strings(out)
+	return out
+}
+
+// newTestConfig points every stage at srv and a temp state dir.
+func newTestConfig(t *testing.T, srv *httptest.Server) *config.RootConfig {
+	t.Helper()
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "ATT&CK.csv")
+	if err := os.WriteFile(csvPath, []byte(recordTestCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	promptPath := filepath.Join(dir, "risk.json")
+	prompt, _ := json.Marshal(map[string]string{"template": "tactic={tactic_name_selected}\n{technique_candidates}\n{context}"})
+	if err := os.WriteFile(promptPath, prompt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &config.RootConfig{
+		Paths: config.PathsConfig{StateDir: filepath.Join(dir, "state")},
+		Yuheng: config.YuhengConfig{
+			BaseURL:      srv.URL,
+			TimeoutS:     5,
+			ListEndpoint: "/api/lines/operation",
+			ListMethod:   "GET",
+			ListPageSize: 1000,
+		},
+		AI: config.AIConfig{
+			Provider:    "openai",
+			Model:       "test",
+			TimeoutS:    5,
+			BaseURL:     srv.URL,
+			PromptPath:  promptPath,
+			Concurrency: 2,
+			Context:     config.AIContextConfig{TotalMaxRunes: 2600, NameMaxRunes: 200, DescriptionMaxRunes: 1200, POCMaxRunes: 800, ReqMaxRunes: 400, RespMaxRunes: 400},
+			ATTCK:       config.AIAttckConfig{CSVPath: csvPath, TechniqueTopK: 5, CandidateMaxRunes: 2000, SubMaxPerTechnique: 3},
+		},
+	}
+}
+
+// readJSONLIDs returns the IDs of the lines of path, sorted.
+func readJSONLIDs(t *testing.T, path string) []string {
+	t.Helper()
+	ids := jsonlIDsInOrder(t, path)
+	sort.Strings(ids)
+	return ids
+}
+
+// jsonlIDsInOrder returns the IDs of the lines of path in file order.
+func jsonlIDsInOrder(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec struct {
+			ID any `json:"id"`
+		}
+		if err := decodeLine(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		ids = append(ids, types.RecordKey(rec.ID))
+	}
+	return ids
+}
+
+func TestRunPipeline_StreamsAllStages(t *testing.T) {
+	// 3000000 reads back from the state files as a float64, which must
+	// still match the int fetch produces when the run is resumed.
+	backend := &fakeBackend{ids: []int{1, 2, 3000000}}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+
+	if err := RunPipeline(context.Background(), cfg, PipelineOptions{}); err != nil {
+		t.Fatalf("RunPipeline: %v", err)
+	}
+
+	want := "[1 2 3000000]"
+	if got := fmt.Sprint(backend.submittedIDs()); got != want {
+		t.Fatalf("submitted %s, want %s", got, want)
+	}
+	if metrics.Records.Value("submit", "success") < 3 || metrics.ModelCalls.Value("openai", "risk", "success") < 3 ||
+		metrics.PlatformRequests.Value("/api/operation_side/lines/:id/review", "200") < 3 {
+		t.Fatalf("stage metrics were not recorded")
+	}
+	for _, p := range []string{cfg.PendingAuditsPath(), cfg.PendingAuditsResultsPath(), cfg.SubmittedIDsPath()} {
+		if got := fmt.Sprint(readJSONLIDs(t, p)); got != want {
+			t.Fatalf("%s has %s, want %s", filepath.Base(p), got, want)
+		}
+	}
+
+	// A resumed run re-fetches but neither re-analyses nor re-submits.
+	backend.submitted = nil
+	if err := RunPipeline(context.Background(), cfg, PipelineOptions{Resume: true}); err != nil {
+		t.Fatalf("resumed RunPipeline: %v", err)
+	}
+	if got := backend.submittedIDs(); len(got) != 0 {
+		t.Fatalf("resumed run submitted again: %v", got)
+	}
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != want {
+		t.Fatalf("resumed run rewrote results: %s", got)
+	}
+}
+
+func TestBuildWorkflow_RejectsStreamingRetryFailed(t *testing.T) {
+	_, err := BuildWorkflowWithOptions(context.Background(), &config.RootConfig{}, WorkflowOptions{Streaming: true, RetryFailed: true})
+	if err == nil || !strings.Contains(err.Error(), "streaming") {
+		t.Fatalf("err = %v, want a streaming error", err)
+	}
+}
+
+func writePending(t *testing.T, cfg *config.RootConfig, ids ...int) {
+	t.Helper()
+	if err := os.MkdirAll(cfg.StateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	for _, id := range ids {
+		b, _ := json.Marshal(map[string]any{"id": id, "data": map[string]any{
+			"name":    fmt.Sprintf("record %d", id),
+			"req_pkg": fmt.Sprintf("GET /rec-%d/", id),
+			"_raw":    map[string]any{"id": id},
+		}})
+		sb.Write(b)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(cfg.PendingAuditsPath(), []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunRiskAnalysis_DeadLetterAndRetryFailed(t *testing.T) {
+	backend := &fakeBackend{failRisk: map[string]bool{"2": true}}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	writePending(t, cfg, 1, 2, 3)
+	ctx := context.Background()
+
+	if err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != "[1 3]" {
+		t.Fatalf("results: %s", got)
+	}
+	failures, err := LoadFailures(cfg.PendingAuditsFailuresPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := failures["2"]
+	if len(failures) != 1 || !ok || f.ErrorClass != FailureModel || f.Attempts != 1 || f.FirstFailedAt == "" {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+
+	// Still failing: only ID 2 is retried and its attempt count grows.
+	if err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{RetryFailed: true}); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	failures, _ = LoadFailures(cfg.PendingAuditsFailuresPath())
+	if again := failures["2"]; again.Attempts != 2 || again.FirstFailedAt != f.FirstFailedAt {
+		t.Fatalf("unexpected failure after retry: %+v", again)
+	}
+
+	backend.mu.Lock()
+	backend.failRisk = nil
+	backend.mu.Unlock()
+	if err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{RetryFailed: true}); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != "[1 2 3]" {
+		t.Fatalf("results after retry: %s", got)
+	}
+	processed, _ := loadProcessedIDs(cfg.PendingAuditsResultsPath())
+	if len(processed) != 3 {
+		t.Fatalf("expected 3 processed IDs, got %v", processed)
+	}
+}
+
+func TestRunRiskAnalysis_StopSyncsAndResumes(t *testing.T) {
+	stop := make(chan struct{})
+	var once sync.Once
+	backend := &fakeBackend{onRisk: func() { once.Do(func() { close(stop) }) }}
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	cfg.AI.Concurrency = 1
+	writePending(t, cfg, 1, 2, 3, 4, 5)
+	ctx := context.Background()
+
+	err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{Stop: stop})
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("expected ErrInterrupted, got %v", err)
+	}
+	// The record in flight when Stop closed is finished and on disk.
+	got := readJSONLIDs(t, cfg.PendingAuditsResultsPath())
+	if len(got) == 0 || len(got) >= 5 {
+		t.Fatalf("unexpected results after stop: %v", got)
+	}
+
+	backend.mu.Lock()
+	backend.onRisk = nil
+	backend.mu.Unlock()
+	if err := RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if got := fmt.Sprint(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); got != "[1 2 3 4 5]" {
+		t.Fatalf("results after resume: %s", got)
+	}
+}
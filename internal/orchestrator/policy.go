@@ -0,0 +1,153 @@
+package orchestrator
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/components/policy"
+	"audit-workflow/internal/components/rules"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/review"
+)
+
+// recordPolicy applies the policy file (ai.policy_path) to records before
+// their record graph runs.
+type recordPolicy struct {
+	set *policy.Set
+	env policy.Env
+}
+
+// loadRecordPolicy loads cfg.AI.PolicyPath; it returns nil when there are no
+// policies. Findings rejected in review are loaded for rejected_duplicate
+// conditions and recognised by their dedup fingerprint.
+func loadRecordPolicy(cfg *config.RootConfig, ruleSet *rules.Set) (*recordPolicy, error) {
+	set, err := policy.Load(cfg.AI.PolicyPath)
+	if err != nil {
+		return nil, err
+	}
+	if set.Empty() {
+		return nil, nil
+	}
+	p := &recordPolicy{set: set}
+	rejected := 0
+	if set.UsesRejectedDuplicate() {
+		groups := newDedupGroups(cfg, ruleSet)
+		fps, err := rejectedFingerprints(cfg, groups)
+		if err != nil {
+			return nil, err
+		}
+		rejected = len(fps)
+		p.env.RejectedDuplicate = func(data map[string]any) bool {
+			return fps[groups.fingerprint(data)]
+		}
+	}
+	aiLog().Info("loaded record policies", "policies", len(set.Policies), "path", cfg.AI.PolicyPath, "rejected_findings", rejected)
+	return p, nil
+}
+
+// rejectedFingerprints returns the fingerprints of the queued records whose
+// latest review decision is a rejection.
+func rejectedFingerprints(cfg *config.RootConfig, groups *dedupGroups) (map[string]bool, error) {
+	decisions, err := review.LoadDecisions(cfg.ReviewDecisionsPath())
+	if err != nil {
+		return nil, fmt.Errorf("load review decisions failed: %w", err)
+	}
+	items, err := review.LoadQueue(cfg.ReviewQueuePath())
+	if err != nil {
+		return nil, fmt.Errorf("load review queue failed: %w", err)
+	}
+	fps := map[string]bool{}
+	for _, it := range items {
+		if d, ok := decisions[review.Key(it.ID)]; ok && d.Decision == review.Reject {
+			fps[groups.fingerprint(it.Data)] = true
+		}
+	}
+	return fps, nil
+}
+
+// applyPolicy returns the result of j when a policy handles it. Skipped
+// records produce no line; rejected and manual ones are written without a
+// score, with the policy's ID, action and reason, for Submit to act on.
+func (a *analysis) applyPolicy(j job, total int) (result, bool) {
+	start := time.Now()
+	p := a.policy.set.Match(j.rec.Data, a.policy.env)
+	if p == nil {
+		return result{}, false
+	}
+	attrs := append(a.recordAttrs(j, total, start), "policy_id", p.ID, "policy_action", p.Action)
+	r := result{idx: j.idx, id: j.rec.ID, attrs: attrs, policy: p}
+	if p.Action == policy.ActionSkip {
+		return r, true
+	}
+	data := maps.Clone(j.rec.Data)
+	if data == nil {
+		data = map[string]any{}
+	}
+	data[policy.FieldID] = p.ID
+	data[policy.FieldAction] = p.Action
+	if p.Reason != "" {
+		data[policy.FieldReason] = p.Reason
+	}
+	line, err := a.resultLine(j, data)
+	if err != nil {
+		r.fail = &RecordError{Class: FailureInternal, Err: err}
+		return r, true
+	}
+	r.wrote, r.line, r.data = true, line, data
+	return r, true
+}
+
+// policyHandled reports whether result data was written by a policy rather
+// than by the model, so that Resume does not analyse it again.
+func policyHandled(action any) bool {
+	switch action {
+	case policy.ActionReject, policy.ActionManual:
+		return true
+	}
+	return false
+}
+
+// policyTally counts the records policies handled in a run.
+type policyTally struct {
+	actions map[string]int
+	ids     map[string]int
+}
+
+func (t *policyTally) add(p *policy.Policy) {
+	if t.actions == nil {
+		t.actions, t.ids = map[string]int{}, map[string]int{}
+	}
+	t.actions[p.Action]++
+	t.ids[p.ID]++
+}
+
+// count copies the action counts into c.
+func (t *policyTally) count(c *RunCounts) {
+	c.PolicySkipped = t.actions[policy.ActionSkip]
+	c.PolicyRejected = t.actions[policy.ActionReject]
+	c.PolicyManual = t.actions[policy.ActionManual]
+}
+
+// log writes the run summary line of the policies, if any applied.
+func (t *policyTally) log() {
+	if len(t.ids) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(t.ids))
+	for id := range t.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s=%d", id, t.ids[id])
+	}
+	aiLog().Info("records handled by policy",
+		policy.ActionSkip, t.actions[policy.ActionSkip],
+		policy.ActionReject, t.actions[policy.ActionReject],
+		policy.ActionManual, t.actions[policy.ActionManual],
+		"by_policy", strings.Join(parts, ","))
+}
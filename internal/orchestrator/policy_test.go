@@ -0,0 +1,129 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audit-workflow/internal/review"
+)
+
+func TestRunRiskAnalysis_PolicyActions(t *testing.T) {
+	backend := &fakeBackend{}
+	riskCalls := 0
+	backend.onRisk = func() { riskCalls++ }
+	srv := httptest.NewServer(backend)
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	cfg.AI.PolicyPath = filepath.Join(t.TempDir(), "policy.json")
+	policies := `{"policies": [
+	  {"id": "no-req", "when": {"field": "req_pkg", "empty": true}, "action": "skip"},
+	  {"id": "weak-pass", "when": {"field": "_raw.type", "equals": "弱口令"}, "action": "reject", "reason": "不在范围内"},
+	  {"id": "by-hand", "when": {"field": "name", "regex": "^manual"}, "action": "manual"},
+	  {"id": "seen", "when": {"rejected_duplicate": true}, "action": "reject", "reason": "已驳回"}
+	]}`
+	if err := os.WriteFile(cfg.AI.PolicyPath, []byte(policies), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.StateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A finding rejected in an earlier review, on another host.
+	rejected := map[string]any{"name": "weblogic 反序列化", "req_pkg": "GET http://10.0.0.9/wls-wsat/ HTTP/1.1"}
+	writeLines(t, cfg.ReviewQueuePath(), review.NewItem(90, rejected, []string{review.ReasonHighScore}))
+	writeLines(t, cfg.ReviewDecisionsPath(), review.Decision{ID: 90, Decision: review.Reject})
+
+	records := []map[string]any{
+		{"id": 1, "data": map[string]any{"name": "sql 注入", "req_pkg": "GET /rec-1/", "_raw": map[string]any{"id": 1}}},
+		{"id": 2, "data": map[string]any{"name": "empty", "req_pkg": "", "_raw": map[string]any{"id": 2}}},
+		{"id": 3, "data": map[string]any{"name": "ssh", "req_pkg": "GET /rec-3/", "_raw": map[string]any{"id": 3, "type": "弱口令", "bulk": "x"}}},
+		{"id": 4, "data": map[string]any{"name": "manual check", "req_pkg": "GET /rec-4/", "_raw": map[string]any{"id": 4}}},
+		{"id": 5, "data": map[string]any{"name": "weblogic 反序列化", "req_pkg": "GET http://app.example.com/wls-wsat/ HTTP/1.1", "_raw": map[string]any{"id": 5}}},
+	}
+	var pending []any
+	for _, r := range records {
+		pending = append(pending, r)
+	}
+	writeLines(t, cfg.PendingAuditsPath(), pending...)
+
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if riskCalls != 1 {
+		t.Fatalf("risk calls = %d, want only record 1 scored", riskCalls)
+	}
+
+	type line struct {
+		ID    json.Number    `json:"id"`
+		RunID string         `json:"run_id"`
+		Data  map[string]any `json:"data"`
+	}
+	lines := map[string]line{}
+	f, err := os.Open(cfg.PendingAuditsResultsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var l line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		lines[l.ID.String()] = l
+	}
+	f.Close()
+	if len(lines) != 4 || lines["2"].Data != nil {
+		t.Fatalf("results = %v, want 1, 3, 4 and 5", lines)
+	}
+	want := map[string][2]string{"3": {"reject", "weak-pass"}, "4": {"manual", "by-hand"}, "5": {"reject", "seen"}}
+	for id, w := range want {
+		d := lines[id].Data
+		if d["policy_action"] != w[0] || d["policy_id"] != w[1] || d["risk_score"] != nil {
+			t.Fatalf("record %s = %v, want policy %s %s and no score", id, d, w[1], w[0])
+		}
+	}
+	if raw, _ := lines["3"].Data["_raw"].(map[string]any); raw["bulk"] != "x" || lines["3"].Data["policy_reason"] != "不在范围内" {
+		t.Fatalf("rejected record should keep its full raw detail and reason: %v", lines["3"].Data)
+	}
+
+	m, err := LoadRunManifest(cfg, lines["1"].RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := fileHash(cfg.AI.PolicyPath); m.PolicyHash == "" || m.PolicyHash != want {
+		t.Fatalf("policy_hash = %q, want %q", m.PolicyHash, want)
+	}
+	if c := m.Counts; c.Items != 5 || c.Written != 4 || c.PolicySkipped != 1 || c.PolicyRejected != 2 || c.PolicyManual != 1 {
+		t.Fatalf("counts = %+v", c)
+	}
+
+	// Resume leaves the records policies wrote alone.
+	if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{Resume: true}); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if got := len(readJSONLIDs(t, cfg.PendingAuditsResultsPath())); riskCalls != 1 || got != 4 {
+		t.Fatalf("resume wrote %d lines with %d risk calls", got, riskCalls)
+	}
+}
+
+func writeLines(t *testing.T, path string, vs ...any) {
+	t.Helper()
+	var sb strings.Builder
+	for _, v := range vs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(b)
+		sb.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,587 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	modelcomp "audit-workflow/internal/components/model"
+	"audit-workflow/internal/components/parser"
+	promptcomp "audit-workflow/internal/components/prompt"
+	"audit-workflow/internal/components/rules"
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/metrics"
+	"audit-workflow/internal/review"
+	"audit-workflow/internal/tracing"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Node keys of the per-record analysis graph.
+const (
+	NodeTrim         = "trim"
+	NodeRules        = "rules"
+	NodeTacticPrompt = "tactic_prompt"
+	NodeTacticModel  = "tactic_model"
+	NodeTacticParse  = "tactic_parse"
+	NodeCandidates   = "candidates"
+	NodeRiskPrompt   = "risk_prompt"
+	NodeRiskModel    = "risk_model"
+	NodeRiskParse    = "risk_parse"
+	NodeSanitize     = "sanitize"
+	NodeJudge        = "judge"
+)
+
+// RecordState flows through the per-record graph; every node fills in its
+// own part and passes the state on.
+type RecordState struct {
+	ID   any
+	Data map[string]any
+	// Debug prints the prompts and the raw risk response.
+	Debug bool
+
+	Context string
+	// Rule is the pre-classification rule that fired, if any.
+	Rule *rules.Rule
+	// Tactic is preset by a hint rule or parsed from the tactic reply.
+	Tactic string
+	// TacticSource is "hint", "model" or "fallback".
+	TacticSource   string
+	TacticMessages []*schema.Message
+	TacticReply    string
+	// TacticErr is the failed tactic call, if any; the record goes on with
+	// the fallback tactic.
+	TacticErr error
+
+	Candidates   []taxonomy.TechniqueCandidate
+	RiskMessages []*schema.Message
+	RiskReply    string
+
+	Score int
+	// ScoreSource is "rule", "json" or "text".
+	ScoreSource string
+	// Parsed holds the structured fields of the risk reply.
+	Parsed map[string]any
+	// Sanitized records what Sanitize did to the model's ATT&CK selection.
+	Sanitized []SanitizeDecision
+	// JudgeReply is the judge model's reply to the risk prompt, if asked.
+	JudgeReply string
+}
+
+// SanitizeDecision is one ATT&CK field checked by Sanitize: the model's
+// value, the value kept and why they differ.
+type SanitizeDecision struct {
+	Field  string `json:"field"`
+	Model  string `json:"model"`
+	Kept   string `json:"kept"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Failure classes of RecordError, recorded in the dead-letter file.
+const (
+	FailurePrompt   = "prompt"
+	FailureRule     = "rule"
+	FailureModel    = "model"
+	FailureParse    = "parse"
+	FailureCanceled = "canceled"
+	FailureInternal = "internal"
+)
+
+// RecordError is returned by the record graph for records that produce no
+// result line.
+type RecordError struct {
+	Class string
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	switch e.Class {
+	case FailurePrompt:
+		return "Prompt Format Error: " + e.Err.Error()
+	case FailureRule:
+		return "Rule Error: " + e.Err.Error()
+	default:
+		return "Error: " + e.Err.Error()
+	}
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// RecordNodes holds what the record graph nodes share. Each node is a method
+// so it can be run on its own.
+type RecordNodes struct {
+	Cfg              *config.RootConfig
+	Taxonomy         *taxonomy.Taxonomy
+	Rules            *rules.Set
+	TacticCandidates []string
+	TacticTemplate   promptcomp.ChatTemplate
+	RiskTemplate     promptcomp.ChatTemplate
+	Model            modelcomp.ChatModel
+	// JudgeModel is optional; when set it is asked the risk prompt again and
+	// its verdict is kept under review.FieldJudge for Submit to compare.
+	JudgeModel modelcomp.ChatModel
+	// Embedder is optional; without it candidates are ranked lexically.
+	Embedder embedding.Embedder
+	// Wait is called with the messages of each model request before it is
+	// sent; nil means no pacing.
+	Wait func(context.Context, []*schema.Message) error
+	// Observe, if set, receives the latency and error of each model request.
+	Observe func(time.Duration, error)
+}
+
+// Trim builds the trimmed prompt context from the record fields.
+func (n *RecordNodes) Trim(ctx context.Context, s *RecordState) (*RecordState, error) {
+	if s.Data == nil {
+		s.Data = map[string]any{}
+	}
+	s.Context = BuildTrimmedContext(n.Cfg, s.Data)
+	return s, nil
+}
+
+// ApplyRules runs the pre-classification rules. An assign rule fills in the
+// verdict; a hint rule prefixes the context and may preset the tactic.
+func (n *RecordNodes) ApplyRules(ctx context.Context, s *RecordState) (*RecordState, error) {
+	rule := n.Rules.Match(s.Data)
+	if rule == nil {
+		return s, nil
+	}
+	s.Rule = rule
+	s.Data["rule_id"] = rule.ID
+	s.Data["rule_action"] = rule.Action
+	switch rule.Action {
+	case rules.ActionAssign:
+		if err := rule.Apply(s.Data); err != nil {
+			return nil, &RecordError{Class: FailureRule, Err: err}
+		}
+		s.Score = rule.Assign.RiskScore
+		s.ScoreSource = "rule"
+	case rules.ActionHint:
+		if text := strings.TrimSpace(rule.Hint.Text); text != "" {
+			s.Context = "规则提示：" + text + "\n\n" + s.Context
+		}
+		if tactic := strings.TrimSpace(rule.Hint.TacticName); isInList(tactic, n.TacticCandidates) {
+			s.Tactic = tactic
+			s.TacticSource = "hint"
+		}
+	}
+	return s, nil
+}
+
+// PrepareRecord runs the trim and rules nodes on data as the record graph
+// does, so that tools see the context and preset tactic the model is given.
+func PrepareRecord(ctx context.Context, cfg *config.RootConfig, tax *taxonomy.Taxonomy, ruleSet *rules.Set, id any, data map[string]any) (*RecordState, error) {
+	n := &RecordNodes{Cfg: cfg, Taxonomy: tax, Rules: ruleSet, TacticCandidates: buildTacticCandidates(cfg, tax)}
+	s, err := n.Trim(ctx, &RecordState{ID: id, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return n.ApplyRules(ctx, s)
+}
+
+// afterRules ends the graph for assign rules and skips the tactic call when
+// a hint rule preset the tactic.
+func (n *RecordNodes) afterRules(ctx context.Context, s *RecordState) (string, error) {
+	switch {
+	case s.ScoreSource == "rule":
+		return compose.END, nil
+	case s.Tactic != "":
+		return NodeCandidates, nil
+	default:
+		return NodeTacticPrompt, nil
+	}
+}
+
+// TacticPrompt formats the tactic selection prompt.
+func (n *RecordNodes) TacticPrompt(ctx context.Context, s *RecordState) (*RecordState, error) {
+	tacticCandidatesJSON, _ := json.Marshal(n.TacticCandidates)
+	msgs, err := n.TacticTemplate.Format(ctx, map[string]any{
+		"context":           s.Context,
+		"tactic_candidates": string(tacticCandidatesJSON),
+	})
+	if err != nil {
+		return nil, &RecordError{Class: FailurePrompt, Err: err}
+	}
+	s.TacticMessages = msgs
+	if s.Debug {
+		var promptText string
+		if len(msgs) > 0 {
+			promptText = msgs[0].Content
+		}
+		aiLog().Info("debug tactic prompt", logging.KeyRecordID, s.ID, "prompt", truncate(promptText, 500)+"...")
+	}
+	return s, nil
+}
+
+// TacticModel asks the model for a tactic. A failed call is not fatal:
+// TacticParse falls back to the first candidate.
+func (n *RecordNodes) TacticModel(ctx context.Context, s *RecordState) (*RecordState, error) {
+	if err := n.wait(ctx, s.TacticMessages); err != nil {
+		return nil, &RecordError{Class: FailureCanceled, Err: err}
+	}
+	resp, err := n.generate(ctx, "tactic", s.TacticMessages)
+	if err == nil && resp != nil {
+		s.TacticReply = resp.Content
+	}
+	s.TacticErr = err
+	return s, nil
+}
+
+// generate calls the model for stage (tactic or risk), records metrics and
+// a span and reports the outcome to Observe; calls cut short by ctx are not
+// observed. The span carries prompt and reply sizes, not their text.
+func (n *RecordNodes) generate(ctx context.Context, stage string, msgs []*schema.Message) (*schema.Message, error) {
+	provider, modelName := "", ""
+	if n.Cfg != nil {
+		provider, modelName = n.Cfg.AI.Provider, n.Cfg.AI.Model
+	}
+	model, observe := n.Model, n.Observe
+	if stage == "judge" {
+		// The judge is another model; its latency says nothing about the
+		// chat model's capacity.
+		model, observe = n.JudgeModel, nil
+		if n.Cfg != nil {
+			modelName = n.Cfg.AI.Judge.Model
+		}
+	}
+	ctx, span := tracing.Start(ctx, "model "+stage,
+		"gen_ai.system", provider,
+		"gen_ai.request.model", modelName,
+		"audit.model_stage", stage,
+		"gen_ai.prompt.messages", len(msgs),
+		"gen_ai.prompt.chars", promptChars(msgs),
+		"gen_ai.prompt.estimated_tokens", estimateTokens(msgs))
+	defer span.End()
+
+	start := time.Now()
+	resp, err := model.Generate(ctx, msgs)
+	d := time.Since(start)
+	outcome := "success"
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		outcome = "canceled"
+	case isThrottleError(err):
+		outcome = "throttled"
+	default:
+		outcome = "error"
+	}
+	metrics.ModelCalls.Inc(provider, stage, outcome)
+	metrics.ModelCallSeconds.Observe(d.Seconds(), provider, stage)
+	span.SetAttributes("audit.outcome", outcome)
+	span.RecordError(err)
+	if resp != nil {
+		span.SetAttributes("gen_ai.response.chars", len([]rune(resp.Content)))
+	}
+	if resp != nil && resp.ResponseMeta != nil && resp.ResponseMeta.Usage != nil {
+		metrics.ModelTokens.Add(float64(resp.ResponseMeta.Usage.PromptTokens), provider, stage, "prompt")
+		metrics.ModelTokens.Add(float64(resp.ResponseMeta.Usage.CompletionTokens), provider, stage, "completion")
+		span.SetAttributes(
+			"gen_ai.usage.input_tokens", resp.ResponseMeta.Usage.PromptTokens,
+			"gen_ai.usage.output_tokens", resp.ResponseMeta.Usage.CompletionTokens)
+	}
+
+	if observe != nil && ctx.Err() == nil {
+		observe(d, err)
+	}
+	return resp, err
+}
+
+// TacticParse reads tactic_name from the reply, falling back to the first
+// tactic candidate when it is missing or not offered.
+func (n *RecordNodes) TacticParse(ctx context.Context, s *RecordState) (*RecordState, error) {
+	s.Tactic = strings.TrimSpace(parseJSONStringField(s.TacticReply, "tactic_name"))
+	s.TacticSource = "model"
+	if !isInList(s.Tactic, n.TacticCandidates) {
+		s.Tactic = n.TacticCandidates[0]
+		s.TacticSource = "fallback"
+	}
+	return s, nil
+}
+
+// Candidates ranks the techniques of the selected tactic against the context.
+func (n *RecordNodes) Candidates(ctx context.Context, s *RecordState) (*RecordState, error) {
+	var queryVec []float64
+	if n.Embedder != nil {
+		vecs, err := n.Embedder.EmbedStrings(ctx, []string{s.Context})
+		if err == nil && len(vecs) == 1 {
+			queryVec = vecs[0]
+		} else if err != nil {
+			aiLog().Warn("embed context failed, using lexical candidates", logging.KeyRecordID, s.ID, "error", err)
+		}
+	}
+	s.Candidates = n.Taxonomy.GenerateTechniqueCandidatesWithVector(
+		s.Tactic,
+		s.Context,
+		queryVec,
+		n.Cfg.AI.ATTCK.TechniqueTopK,
+		n.Cfg.AI.ATTCK.SubMaxPerTechnique,
+	)
+	return s, nil
+}
+
+// RiskPrompt formats the risk assessment prompt with the selected tactic and
+// the technique candidates.
+func (n *RecordNodes) RiskPrompt(ctx context.Context, s *RecordState) (*RecordState, error) {
+	msgs, err := n.RiskTemplate.Format(ctx, map[string]any{
+		"context":              s.Context,
+		"tactic_name_selected": s.Tactic,
+		"technique_candidates": taxonomy.FormatTechniqueCandidates(s.Tactic, s.Candidates, n.Cfg.AI.ATTCK.CandidateMaxRunes),
+	})
+	if err != nil {
+		return nil, &RecordError{Class: FailurePrompt, Err: err}
+	}
+	s.RiskMessages = msgs
+	if s.Debug {
+		var promptText string
+		if len(msgs) > 0 {
+			promptText = msgs[0].Content
+		}
+		aiLog().Info("debug risk prompt", logging.KeyRecordID, s.ID, "prompt", truncate(promptText, 500)+"...")
+	}
+	return s, nil
+}
+
+// RiskModel asks the model for the risk assessment.
+func (n *RecordNodes) RiskModel(ctx context.Context, s *RecordState) (*RecordState, error) {
+	if err := n.wait(ctx, s.RiskMessages); err != nil {
+		return nil, &RecordError{Class: FailureCanceled, Err: err}
+	}
+	resp, err := n.generate(ctx, "risk", s.RiskMessages)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &RecordError{Class: FailureCanceled, Err: err}
+		}
+		return nil, &RecordError{Class: FailureModel, Err: err}
+	}
+	s.RiskReply = resp.Content
+	if s.Debug {
+		aiLog().Info("debug risk response", logging.KeyRecordID, s.ID, "response", s.RiskReply)
+	}
+	return s, nil
+}
+
+// RiskParse applies the structured reply to the record, or falls back to
+// reading a bare score from the text. A reply without a usable score fails
+// the record.
+func (n *RecordNodes) RiskParse(ctx context.Context, s *RecordState) (*RecordState, error) {
+	structuredScore, structuredData, err := parser.ParseStructuredJSON(s.RiskReply)
+	if err != nil {
+		s.Score = parseScore(s.RiskReply)
+		s.ScoreSource = "text"
+	} else {
+		s.Score = structuredScore
+		s.Parsed = structuredData
+		parser.ApplyStructuredFields(s.Data, structuredData)
+		s.ScoreSource = "json"
+	}
+	if _, ok := recordResultData(s)["risk_score"]; !ok {
+		return nil, &RecordError{Class: FailureParse, Err: fmt.Errorf("no risk score in reply: %s", truncate(s.RiskReply, 200))}
+	}
+	return s, nil
+}
+
+// Sanitize keeps the model's technique/sub-technique only when they were
+// among the offered candidates.
+func (n *RecordNodes) Sanitize(ctx context.Context, s *RecordState) (*RecordState, error) {
+	if s.ScoreSource != "json" {
+		return s, nil
+	}
+	fields := []string{"tactic_name", "technique_name", "sub_technique_name"}
+	before := make([]string, len(fields))
+	for i, f := range fields {
+		before[i] = firstString(s.Data[f])
+	}
+	allowedTech, allowedSub := buildAllowedFromCandidates(s.Candidates)
+	sanitizeATTCKSelection(s.Data, s.Tactic, allowedTech, allowedSub)
+	s.Sanitized = nil
+	for i, f := range fields {
+		d := SanitizeDecision{Field: f, Model: before[i], Kept: firstString(s.Data[f])}
+		switch {
+		case d.Model == d.Kept:
+		case f == "tactic_name":
+			d.Reason = "set to the selected tactic"
+		case f == "sub_technique_name" && d.Model != "" && s.Data["technique_name"] == "":
+			d.Reason = "technique dropped"
+		default:
+			d.Reason = "not among the candidates"
+		}
+		s.Sanitized = append(s.Sanitized, d)
+	}
+	return s, nil
+}
+
+// Judge asks the judge model the risk prompt and keeps its score and
+// technique under review.FieldJudge. Records the model did not score and
+// failed judge calls are left without a judge verdict.
+func (n *RecordNodes) Judge(ctx context.Context, s *RecordState) (*RecordState, error) {
+	if n.JudgeModel == nil || len(s.RiskMessages) == 0 {
+		return s, nil
+	}
+	if err := n.wait(ctx, s.RiskMessages); err != nil {
+		return nil, &RecordError{Class: FailureCanceled, Err: err}
+	}
+	resp, err := n.generate(ctx, "judge", s.RiskMessages)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &RecordError{Class: FailureCanceled, Err: err}
+		}
+		aiLog().Warn("judge call failed, record kept without a judge verdict", logging.KeyRecordID, s.ID, "error", err)
+		return s, nil
+	}
+	s.JudgeReply = resp.Content
+	verdict := map[string]any{}
+	if score, data, err := parser.ParseStructuredJSON(s.JudgeReply); err == nil {
+		verdict["risk_score"] = score
+		if tech := firstString(data["technique_name"]); tech != "" {
+			verdict["technique_name"] = tech
+		}
+	} else if score := parseScore(s.JudgeReply); score >= 0 {
+		verdict["risk_score"] = score
+	}
+	if len(verdict) == 0 {
+		aiLog().Warn("judge reply has no verdict", logging.KeyRecordID, s.ID, "reply", truncate(s.JudgeReply, 200))
+		return s, nil
+	}
+	s.Data[review.FieldJudge] = verdict
+	return s, nil
+}
+
+// promptChars counts the runes of all message contents.
+func promptChars(msgs []*schema.Message) int {
+	c := 0
+	for _, m := range msgs {
+		if m != nil {
+			c += len([]rune(m.Content))
+		}
+	}
+	return c
+}
+
+func (n *RecordNodes) wait(ctx context.Context, msgs []*schema.Message) error {
+	if n.Wait == nil {
+		return nil
+	}
+	return n.Wait(ctx, msgs)
+}
+
+// BuildRecordGraph compiles the per-record analysis graph:
+//
+//	trim → rules → tactic_prompt → tactic_model → tactic_parse → candidates
+//	     → risk_prompt → risk_model → risk_parse → sanitize → judge
+//
+// An assign rule ends the graph after rules; a hint rule with a tactic
+// jumps straight to candidates. The judge node does nothing unless a judge
+// model is set.
+func BuildRecordGraph(ctx context.Context, n *RecordNodes) (compose.Runnable[*RecordState, *RecordState], error) {
+	if len(n.TacticCandidates) == 0 {
+		return nil, fmt.Errorf("no tactic candidates available")
+	}
+
+	graph := compose.NewGraph[*RecordState, *RecordState]()
+	nodes := []struct {
+		key string
+		fn  func(context.Context, *RecordState) (*RecordState, error)
+	}{
+		{NodeTrim, n.Trim},
+		{NodeRules, n.ApplyRules},
+		{NodeTacticPrompt, n.TacticPrompt},
+		{NodeTacticModel, n.TacticModel},
+		{NodeTacticParse, n.TacticParse},
+		{NodeCandidates, n.Candidates},
+		{NodeRiskPrompt, n.RiskPrompt},
+		{NodeRiskModel, n.RiskModel},
+		{NodeRiskParse, n.RiskParse},
+		{NodeSanitize, n.Sanitize},
+		{NodeJudge, n.Judge},
+	}
+	for _, node := range nodes {
+		if err := graph.AddLambdaNode(node.key, compose.InvokableLambda(node.fn), compose.WithNodeName(node.key)); err != nil {
+			return nil, err
+		}
+	}
+
+	edges := [][2]string{
+		{compose.START, NodeTrim},
+		{NodeTrim, NodeRules},
+		{NodeTacticPrompt, NodeTacticModel},
+		{NodeTacticModel, NodeTacticParse},
+		{NodeTacticParse, NodeCandidates},
+		{NodeCandidates, NodeRiskPrompt},
+		{NodeRiskPrompt, NodeRiskModel},
+		{NodeRiskModel, NodeRiskParse},
+		{NodeRiskParse, NodeSanitize},
+		{NodeSanitize, NodeJudge},
+		{NodeJudge, compose.END},
+	}
+	for _, e := range edges {
+		if err := graph.AddEdge(e[0], e[1]); err != nil {
+			return nil, err
+		}
+	}
+	branch := compose.NewGraphBranch(n.afterRules, map[string]bool{
+		compose.END:      true,
+		NodeCandidates:   true,
+		NodeTacticPrompt: true,
+	})
+	if err := graph.AddBranch(NodeRules, branch); err != nil {
+		return nil, err
+	}
+
+	return graph.Compile(ctx, compose.WithGraphName("risk_record"))
+}
+
+// recordResultData returns the result data for a finished record state, with
+// risk_score normalised or dropped when invalid and score_source recorded
+// unless the data already carries one (copied from a dedup representative).
+func recordResultData(s *RecordState) map[string]any {
+	newData := map[string]any{}
+	for k, v := range s.Data {
+		newData[k] = v
+	}
+	if _, ok := newData["score_source"]; !ok && s.ScoreSource != "" {
+		newData["score_source"] = s.ScoreSource
+	}
+
+	if existing, ok := newData["risk_score"]; ok {
+		if v, ok := parser.NormalizeRiskScore(existing); ok {
+			newData["risk_score"] = v
+		} else {
+			delete(newData, "risk_score")
+		}
+	} else if v, ok := parser.NormalizeRiskScore(s.Score); ok {
+		newData["risk_score"] = v
+	}
+	return newData
+}
+
+// recordResultLine renders a pending_audits_results.jsonl line, stamped
+// with the run ID and the prompt and taxonomy hashes when prov is set.
+func recordResultLine(id any, data map[string]any, prov provenance) []byte {
+	line := map[string]any{"id": id, "generated_at": utcISO(), "data": data}
+	if prov.RunID != "" {
+		line["run_id"] = prov.RunID
+		line["prompt_hash"] = prov.PromptHash
+		line["taxonomy_hash"] = prov.TaxonomyHash
+	}
+	if prov.Shard != "" {
+		line["shard"] = prov.Shard
+	}
+	b, _ := json.Marshal(line)
+	return b
+}
+
+// recordLogAttrs describes the outcome of a finished record state as log
+// fields.
+func recordLogAttrs(s *RecordState) []any {
+	attrs := []any{"score", s.Score, "score_source", s.ScoreSource}
+	if s.ScoreSource == "rule" {
+		attrs = append(attrs, "rule_id", s.Rule.ID)
+	}
+	return attrs
+}
@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	promptcomp "audit-workflow/internal/components/prompt"
+	"audit-workflow/internal/components/rules"
+	"audit-workflow/internal/components/tools/taxonomy"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/review"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	einoprompt "github.com/cloudwego/eino/components/prompt"
+	"github.com/cloudwego/eino/schema"
+)
+
+const recordTestCSV = `tactic_id,tactic_name,technique_id,technique_name,sub_technique_name,sub_technique_id,name_en,code_official
+1,侦察,1,主动扫描,,0,Active Scanning,T1595
+1,侦察,1,主动扫描,漏洞扫描,3,Vulnerability Scanning,T1595.002
+3,初始访问,20,利用面向公众的应用程序,,0,Exploit Public-Facing Application,T1190
+`
+
+// scriptedModel replies with the given contents in order and records the
+// prompts it was called with.
+type scriptedModel struct {
+	replies []string
+	errs    []error
+	prompts []string
+}
+
+func (m *scriptedModel) Generate(ctx context.Context, msgs []*schema.Message, _ ...einomodel.Option) (*schema.Message, error) {
+	i := len(m.prompts)
+	var prompt string
+	if len(msgs) > 0 {
+		prompt = msgs[0].Content
+	}
+	m.prompts = append(m.prompts, prompt)
+	if i < len(m.errs) && m.errs[i] != nil {
+		return nil, m.errs[i]
+	}
+	if i >= len(m.replies) {
+		return nil, errors.New("unexpected model call")
+	}
+	return schema.AssistantMessage(m.replies[i], nil), nil
+}
+
+func newTestRecordNodes(t *testing.T, model *scriptedModel, rulesJSON string) *RecordNodes {
+	t.Helper()
+	tax, err := taxonomy.Parse(strings.NewReader(recordTestCSV))
+	if err != nil {
+		t.Fatalf("parse taxonomy: %v", err)
+	}
+	set := &rules.Set{}
+	if rulesJSON != "" {
+		if set, err = rules.Parse([]byte(rulesJSON)); err != nil {
+			t.Fatalf("parse rules: %v", err)
+		}
+	}
+	cfg := &config.RootConfig{AI: config.AIConfig{ATTCK: config.AIAttckConfig{TechniqueTopK: 5, CandidateMaxRunes: 2000, SubMaxPerTechnique: 3}}}
+	return &RecordNodes{
+		Cfg:              cfg,
+		Taxonomy:         tax,
+		Rules:            set,
+		TacticCandidates: tax.ListTactics(),
+		TacticTemplate:   promptcomp.BuildATTCKTacticTemplate(),
+		RiskTemplate: einoprompt.FromMessages(schema.FString,
+			schema.UserMessage("tactic={tactic_name_selected}\n{technique_candidates}\n{context}")),
+		Model: model,
+	}
+}
+
+func TestRecordGraph_ModelPath(t *testing.T) {
+	model := &scriptedModel{replies: []string{
+		`{"tactic_name": "初始访问"}`,
+		`{"risk_score": 8, "level_id": 3, "tactic_name": "侦察", "technique_name": "不存在的技术", "eval_description": "x"}`,
+	}}
+	g, err := BuildRecordGraph(context.Background(), newTestRecordNodes(t, model, ""))
+	if err != nil {
+		t.Fatalf("BuildRecordGraph: %v", err)
+	}
+
+	s, err := g.Invoke(context.Background(), &RecordState{ID: 7, Data: map[string]any{"name": "SQL 注入"}})
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if len(model.prompts) != 2 {
+		t.Fatalf("expected 2 model calls, got %d", len(model.prompts))
+	}
+	if !strings.Contains(model.prompts[1], "tactic=初始访问") || !strings.Contains(model.prompts[1], "利用面向公众的应用程序") {
+		t.Fatalf("risk prompt lacks tactic/candidates: %q", model.prompts[1])
+	}
+	if s.Score != 8 || s.ScoreSource != "json" {
+		t.Fatalf("unexpected score %d (%s)", s.Score, s.ScoreSource)
+	}
+	if s.Data["tactic_name"] != "初始访问" || s.Data["technique_name"] != "" {
+		t.Fatalf("selection not sanitized: %v / %v", s.Data["tactic_name"], s.Data["technique_name"])
+	}
+
+	var line struct {
+		ID   int            `json:"id"`
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(recordResultLine(s.ID, recordResultData(s), provenance{}), &line); err != nil {
+		t.Fatalf("result line: %v", err)
+	}
+	if line.ID != 7 || line.Data["risk_score"] != float64(8) {
+		t.Fatalf("unexpected result line: %+v", line)
+	}
+}
+
+func TestRecordGraph_JudgeVerdict(t *testing.T) {
+	model := &scriptedModel{replies: []string{
+		`{"tactic_name": "初始访问"}`,
+		`{"risk_score": 8, "technique_name": "利用面向公众的应用程序"}`,
+	}}
+	judge := &scriptedModel{replies: []string{`{"risk_score": 4, "technique_name": "有效账户"}`}}
+	n := newTestRecordNodes(t, model, "")
+	n.JudgeModel = judge
+	g, err := BuildRecordGraph(context.Background(), n)
+	if err != nil {
+		t.Fatalf("BuildRecordGraph: %v", err)
+	}
+	s, err := g.Invoke(context.Background(), &RecordState{ID: 7, Data: map[string]any{"name": "SQL 注入"}})
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if len(judge.prompts) != 1 || judge.prompts[0] != model.prompts[1] {
+		t.Fatalf("judge should get the risk prompt once: %q", judge.prompts)
+	}
+	want := map[string]any{"risk_score": 4, "technique_name": "有效账户"}
+	if got := s.Data[review.FieldJudge]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("judge verdict = %v, want %v", got, want)
+	}
+
+	// A failed judge call keeps the record without a verdict.
+	model = &scriptedModel{replies: []string{`{"tactic_name": "初始访问"}`, `{"risk_score": 8}`}}
+	n = newTestRecordNodes(t, model, "")
+	n.JudgeModel = &scriptedModel{errs: []error{errors.New("boom")}}
+	if g, err = BuildRecordGraph(context.Background(), n); err != nil {
+		t.Fatalf("BuildRecordGraph: %v", err)
+	}
+	s, err = g.Invoke(context.Background(), &RecordState{ID: 8, Data: map[string]any{"name": "SQL 注入"}})
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if _, ok := s.Data[review.FieldJudge]; ok || s.Score != 8 {
+		t.Fatalf("unexpected state after judge failure: %+v", s)
+	}
+}
+
+func TestRecordGraph_AssignRuleSkipsModel(t *testing.T) {
+	model := &scriptedModel{}
+	g, err := BuildRecordGraph(context.Background(), newTestRecordNodes(t, model, `{"rules":[
+		{"id":"r1","match":{"name":"log4j"},"action":"assign","assign":{"risk_score":9,"tactic_name":"初始访问"}}]}`))
+	if err != nil {
+		t.Fatalf("BuildRecordGraph: %v", err)
+	}
+	s, err := g.Invoke(context.Background(), &RecordState{ID: 1, Data: map[string]any{"name": "Log4j RCE"}})
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if len(model.prompts) != 0 {
+		t.Fatalf("expected no model calls, got %d", len(model.prompts))
+	}
+	if s.ScoreSource != "rule" || s.Data["rule_id"] != "r1" || s.Data["risk_score"] != 9 {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+	if got := fmt.Sprint(recordLogAttrs(s)); got != "[score 9 score_source rule rule_id r1]" {
+		t.Fatalf("unexpected log: %q", got)
+	}
+}
+
+func TestRecordGraph_HintRuleSkipsTacticCall(t *testing.T) {
+	model := &scriptedModel{replies: []string{`{"risk_score": 3}`}}
+	g, err := BuildRecordGraph(context.Background(), newTestRecordNodes(t, model, `{"rules":[
+		{"id":"scan","match":{"name":"scan"},"action":"hint","hint":{"text":"扫描类","tactic_name":"侦察"}}]}`))
+	if err != nil {
+		t.Fatalf("BuildRecordGraph: %v", err)
+	}
+	s, err := g.Invoke(context.Background(), &RecordState{ID: 2, Data: map[string]any{"name": "port scan"}})
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if len(model.prompts) != 1 {
+		t.Fatalf("expected only the risk call, got %d", len(model.prompts))
+	}
+	if !strings.Contains(model.prompts[0], "tactic=侦察") || !strings.Contains(model.prompts[0], "规则提示：扫描类") {
+		t.Fatalf("hint not applied: %q", model.prompts[0])
+	}
+	if s.Data["tactic_name"] != "侦察" {
+		t.Fatalf("unexpected tactic: %v", s.Data["tactic_name"])
+	}
+}
+
+func TestPrepareRecord_AppliesHint(t *testing.T) {
+	n := newTestRecordNodes(t, &scriptedModel{}, `{"rules":[
+		{"id":"scan","match":{"name":"scan"},"action":"hint","hint":{"text":"扫描类","tactic_name":"侦察"}}]}`)
+	s, err := PrepareRecord(context.Background(), n.Cfg, n.Taxonomy, n.Rules, 2, map[string]any{"name": "port scan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.Context, "规则提示：扫描类\n\n") || s.Tactic != "侦察" || s.TacticSource != "hint" {
+		t.Fatalf("hint not applied: %q, %s (%s)", s.Context, s.Tactic, s.TacticSource)
+	}
+}
+
+func TestRecordNodes_Individually(t *testing.T) {
+	ctx := context.Background()
+	n := newTestRecordNodes(t, &scriptedModel{errs: []error{errors.New("boom")}}, "")
+
+	s, _ := n.TacticParse(ctx, &RecordState{TacticReply: `{"tactic_name": "不存在"}`})
+	if s.Tactic != n.TacticCandidates[0] {
+		t.Fatalf("expected fallback to first tactic, got %q", s.Tactic)
+	}
+
+	var re *RecordError
+	if _, err := n.RiskModel(ctx, &RecordState{Data: map[string]any{}}); !errors.As(err, &re) || re.Class != FailureModel {
+		t.Fatalf("expected a model failure, got %v", err)
+	}
+	if _, err := n.RiskParse(ctx, &RecordState{Data: map[string]any{}, RiskReply: `{"eval_description": "no score"}`}); !errors.As(err, &re) || re.Class != FailureParse {
+		t.Fatalf("expected a parse failure, got %v", err)
+	}
+
+	s, _ = n.RiskParse(ctx, &RecordState{Data: map[string]any{}, RiskReply: "风险评分：6"})
+	if s.ScoreSource != "text" || s.Score != 6 {
+		t.Fatalf("expected text score 6, got %d (%s)", s.Score, s.ScoreSource)
+	}
+}
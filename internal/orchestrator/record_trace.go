@@ -0,0 +1,354 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/types"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// RecordTrace is everything the AI stage saw and decided for one record,
+// written when ai.record_trace is set.
+type RecordTrace struct {
+	ID          any    `json:"id"`
+	RunID       string `json:"run_id,omitempty"`
+	GeneratedAt string `json:"generated_at"`
+	Context     string `json:"context"`
+	RuleID      string `json:"rule_id,omitempty"`
+	RuleAction  string `json:"rule_action,omitempty"`
+
+	TacticCandidates []string       `json:"tactic_candidates,omitempty"`
+	TacticPrompt     []TraceMessage `json:"tactic_prompt,omitempty"`
+	TacticReply      string         `json:"tactic_reply,omitempty"`
+	TacticError      string         `json:"tactic_error,omitempty"`
+	Tactic           string         `json:"tactic,omitempty"`
+	TacticSource     string         `json:"tactic_source,omitempty"`
+
+	TechniqueCandidates []TraceCandidate `json:"technique_candidates,omitempty"`
+	RiskPrompt          []TraceMessage   `json:"risk_prompt,omitempty"`
+	RiskReply           string           `json:"risk_reply,omitempty"`
+
+	Score       int                `json:"score,omitempty"`
+	ScoreSource string             `json:"score_source,omitempty"`
+	Parsed      map[string]any     `json:"parsed,omitempty"`
+	Sanitize    []SanitizeDecision `json:"sanitize,omitempty"`
+	Result      map[string]any     `json:"result,omitempty"`
+
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// TraceMessage is one rendered prompt message.
+type TraceMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// TraceCandidate is a technique offered to the risk prompt.
+type TraceCandidate struct {
+	Technique string   `json:"technique"`
+	Subs      []string `json:"subs,omitempty"`
+}
+
+// newRecordTrace captures s after the record graph ran. fail is the record
+// failure, if any; s then holds what the graph got to before failing.
+func newRecordTrace(s *RecordState, tacticCandidates []string, fail *RecordError) *RecordTrace {
+	t := &RecordTrace{
+		ID:           s.ID,
+		GeneratedAt:  utcISO(),
+		Context:      s.Context,
+		TacticReply:  s.TacticReply,
+		Tactic:       s.Tactic,
+		TacticSource: s.TacticSource,
+		RiskReply:    s.RiskReply,
+		Score:        s.Score,
+		ScoreSource:  s.ScoreSource,
+		Parsed:       s.Parsed,
+		Sanitize:     s.Sanitized,
+	}
+	if s.Rule != nil {
+		t.RuleID, t.RuleAction = s.Rule.ID, s.Rule.Action
+	}
+	if s.TacticMessages != nil {
+		t.TacticCandidates = tacticCandidates
+		t.TacticPrompt = traceMessages(s.TacticMessages)
+	}
+	if s.TacticErr != nil {
+		t.TacticError = s.TacticErr.Error()
+	}
+	for _, c := range s.Candidates {
+		t.TechniqueCandidates = append(t.TechniqueCandidates, TraceCandidate{Technique: c.TechniqueName, Subs: c.SubNames})
+	}
+	t.RiskPrompt = traceMessages(s.RiskMessages)
+	if fail != nil {
+		t.ErrorClass, t.Error = fail.Class, fail.Err.Error()
+	} else {
+		t.Result = recordResultData(s)
+	}
+	return t
+}
+
+func traceMessages(msgs []*schema.Message) []TraceMessage {
+	var out []TraceMessage
+	for _, m := range msgs {
+		if m != nil {
+			out = append(out, TraceMessage{Role: string(m.Role), Content: m.Content})
+		}
+	}
+	return out
+}
+
+// recordTraceWriter writes record traces in the ai.record_trace layout.
+// A nil writer discards them. It is safe for concurrent use.
+type recordTraceWriter struct {
+	w   *jsonl.Writer
+	dir string
+}
+
+// openRecordTraces returns nil when ai.record_trace is empty. The JSONL file
+// is appended to across runs; LoadRecordTrace returns the latest entry.
+func openRecordTraces(cfg *config.RootConfig) (*recordTraceWriter, error) {
+	switch cfg.AI.RecordTrace {
+	case "":
+		return nil, nil
+	case "jsonl":
+		w, err := jsonl.Open(cfg.RecordTracesPath(), true)
+		if err != nil {
+			return nil, err
+		}
+		return &recordTraceWriter{w: w}, nil
+	case "files":
+		if err := os.MkdirAll(cfg.RecordTraceDir(), 0o755); err != nil {
+			return nil, err
+		}
+		return &recordTraceWriter{dir: cfg.RecordTraceDir()}, nil
+	default:
+		return nil, fmt.Errorf("unknown ai.record_trace %q (want jsonl or files)", cfg.AI.RecordTrace)
+	}
+}
+
+func (tw *recordTraceWriter) Write(t *RecordTrace) error {
+	if tw == nil {
+		return nil
+	}
+	if tw.w != nil {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return tw.w.WriteLine(b)
+	}
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recordTraceFile(tw.dir, t.ID), append(b, '\n'), 0o644)
+}
+
+// writer returns the JSONL writer for checkpoint syncing, or nil.
+func (tw *recordTraceWriter) writer() *jsonl.Writer {
+	if tw == nil {
+		return nil
+	}
+	return tw.w
+}
+
+func (tw *recordTraceWriter) Close() error {
+	if tw == nil || tw.w == nil {
+		return nil
+	}
+	return tw.w.Close()
+}
+
+// writeTrace stores t; a trace that cannot be written is logged, not fatal.
+func (a *analysis) writeTrace(t *RecordTrace) {
+	t.RunID = a.prov.RunID
+	if err := a.traces.Write(t); err != nil {
+		aiLog().Warn("write record trace failed", logging.KeyRecordID, t.ID, "error", err)
+	}
+}
+
+// recordTraceFile keeps the ID usable as a file name.
+func recordTraceFile(dir string, id any) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, types.RecordKey(id))
+	return filepath.Join(dir, name+".json")
+}
+
+// ErrNoRecordTrace is returned by LoadRecordTrace for IDs without a trace.
+var ErrNoRecordTrace = errors.New("no trace for record")
+
+// LoadRecordTrace reads the latest trace of id from path, which is either a
+// record_traces.jsonl file or a record_traces directory.
+func LoadRecordTrace(path string, id string) (*RecordTrace, error) {
+	id = types.RecordKey(id)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		b, err := os.ReadFile(recordTraceFile(path, id))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w %s in %s", ErrNoRecordTrace, id, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeRecordTrace(b)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var found *RecordTrace
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		// Cheap filter before decoding the whole line.
+		if !bytes.Contains(line, []byte(id)) {
+			continue
+		}
+		t, err := decodeRecordTrace(line)
+		if err != nil {
+			continue
+		}
+		if types.RecordKey(t.ID) == id {
+			found = t
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w %s in %s", ErrNoRecordTrace, id, path)
+	}
+	return found, nil
+}
+
+// decodeRecordTrace keeps numeric IDs as written, not as float64.
+func decodeRecordTrace(b []byte) (*RecordTrace, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var t RecordTrace
+	if err := dec.Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// FormatRecordTrace writes t as readable sections, prompts and replies in
+// full.
+func FormatRecordTrace(w io.Writer, t *RecordTrace) {
+	section := func(title string) { fmt.Fprintf(w, "\n== %s ==\n", title) }
+
+	fmt.Fprintf(w, "record %v  (traced %s", t.ID, t.GeneratedAt)
+	if t.RunID != "" {
+		fmt.Fprintf(w, ", run %s", t.RunID)
+	}
+	fmt.Fprintln(w, ")")
+	if t.Error != "" {
+		fmt.Fprintf(w, "FAILED [%s]: %s\n", t.ErrorClass, t.Error)
+	}
+	if t.RuleID != "" {
+		fmt.Fprintf(w, "rule: %s (%s)\n", t.RuleID, t.RuleAction)
+	}
+
+	section("context")
+	fmt.Fprintln(w, t.Context)
+
+	if len(t.TacticPrompt) > 0 {
+		section("tactic candidates")
+		fmt.Fprintln(w, strings.Join(t.TacticCandidates, ", "))
+		section("tactic prompt")
+		writeMessages(w, t.TacticPrompt)
+		section("tactic reply")
+		if t.TacticError != "" {
+			fmt.Fprintf(w, "error: %s\n", t.TacticError)
+		}
+		fmt.Fprintln(w, t.TacticReply)
+	}
+	if t.Tactic != "" {
+		fmt.Fprintf(w, "\ntactic: %s (%s)\n", t.Tactic, t.TacticSource)
+	}
+
+	if len(t.TechniqueCandidates) > 0 {
+		section("technique candidates")
+		for i, c := range t.TechniqueCandidates {
+			fmt.Fprintf(w, "%2d. %s\n", i+1, c.Technique)
+			for _, sub := range c.Subs {
+				fmt.Fprintf(w, "      - %s\n", sub)
+			}
+		}
+	}
+	if len(t.RiskPrompt) > 0 {
+		section("risk prompt")
+		writeMessages(w, t.RiskPrompt)
+		section("risk reply")
+		fmt.Fprintln(w, t.RiskReply)
+	}
+
+	if t.ScoreSource != "" {
+		section("parse")
+		fmt.Fprintf(w, "score: %d (source %s)\n", t.Score, t.ScoreSource)
+		writeFields(w, t.Parsed)
+	}
+	if len(t.Sanitize) > 0 {
+		section("sanitize")
+		for _, d := range t.Sanitize {
+			if d.Reason == "" {
+				fmt.Fprintf(w, "%s: kept %q\n", d.Field, d.Kept)
+			} else {
+				fmt.Fprintf(w, "%s: model %q -> %q (%s)\n", d.Field, d.Model, d.Kept, d.Reason)
+			}
+		}
+	}
+	if t.Result != nil {
+		section("result")
+		writeFields(w, t.Result)
+	}
+}
+
+func writeMessages(w io.Writer, msgs []TraceMessage) {
+	for _, m := range msgs {
+		fmt.Fprintf(w, "[%s]\n%s\n", m.Role, m.Content)
+	}
+}
+
+func writeFields(w io.Writer, m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "_raw" {
+			continue
+		}
+		v := m[k]
+		if _, ok := v.(string); !ok {
+			b, _ := json.Marshal(v)
+			v = string(b)
+		}
+		fmt.Fprintf(w, "%s: %v\n", k, v)
+	}
+}
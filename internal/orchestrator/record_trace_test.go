@@ -0,0 +1,70 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunRiskAnalysis_RecordTrace(t *testing.T) {
+	for _, mode := range []string{"jsonl", "files"} {
+		t.Run(mode, func(t *testing.T) {
+			backend := &fakeBackend{failRisk: map[string]bool{"2": true}}
+			srv := httptest.NewServer(backend)
+			defer srv.Close()
+			cfg := newTestConfig(t, srv)
+			cfg.AI.RecordTrace = mode
+			writePending(t, cfg, 1, 2, 3000000)
+			if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{}); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			path := cfg.RecordTracesPath()
+			if mode == "files" {
+				path = cfg.RecordTraceDir()
+			}
+
+			ok, err := LoadRecordTrace(path, "1")
+			if err != nil {
+				t.Fatalf("load trace 1: %v", err)
+			}
+			if !strings.Contains(ok.Context, "/rec-1/") || len(ok.TacticPrompt) == 0 || !strings.Contains(ok.TacticPrompt[0].Content, "候选战术列表") {
+				t.Fatalf("missing context or tactic prompt: %+v", ok)
+			}
+			if ok.Tactic != "初始访问" || ok.TacticSource != "model" || len(ok.TacticCandidates) == 0 || len(ok.TechniqueCandidates) == 0 {
+				t.Fatalf("unexpected tactic stage: %+v", ok)
+			}
+			if len(ok.RiskPrompt) == 0 || !strings.Contains(ok.RiskReply, `"risk_score": 7`) || ok.ScoreSource != "json" || ok.Score != 7 {
+				t.Fatalf("unexpected risk stage: %+v", ok)
+			}
+			if len(ok.Sanitize) != 3 || ok.Parsed == nil || ok.Result == nil || ok.Error != "" {
+				t.Fatalf("unexpected parse/sanitize: %+v", ok)
+			}
+
+			failed, err := LoadRecordTrace(path, "2")
+			if err != nil {
+				t.Fatalf("load trace 2: %v", err)
+			}
+			if failed.ErrorClass != FailureModel || len(failed.RiskPrompt) == 0 || failed.Result != nil {
+				t.Fatalf("unexpected failed trace: %+v", failed)
+			}
+			// Read back as a float64, the large ID must keep its digits.
+			if large, err := LoadRecordTrace(path, "3000000"); err != nil || !strings.Contains(large.Context, "/rec-3000000/") {
+				t.Fatalf("load trace 3000000: %v", err)
+			}
+			if _, err := LoadRecordTrace(path, "3"); !errors.Is(err, ErrNoRecordTrace) {
+				t.Fatalf("expected ErrNoRecordTrace, got %v", err)
+			}
+
+			var sb strings.Builder
+			FormatRecordTrace(&sb, ok)
+			out := sb.String()
+			for _, want := range []string{"record 1", "== tactic prompt ==", "== technique candidates ==", "== risk reply ==", "score: 7 (source json)", "== sanitize =="} {
+				if !strings.Contains(out, want) {
+					t.Fatalf("formatted trace lacks %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
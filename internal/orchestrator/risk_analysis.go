@@ -4,10 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,282 +20,751 @@ import (
 
 	modelcomp "audit-workflow/internal/components/model"
 	"audit-workflow/internal/components/parser"
+	"audit-workflow/internal/components/policy"
 	promptcomp "audit-workflow/internal/components/prompt"
+	"audit-workflow/internal/components/rules"
 	"audit-workflow/internal/components/tools/taxonomy"
 	"audit-workflow/internal/config"
+	"audit-workflow/internal/jsonl"
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/metrics"
+	"audit-workflow/internal/progress"
+	"audit-workflow/internal/stop"
+	"audit-workflow/internal/tracing"
 	"audit-workflow/internal/types"
 )
 
 type RiskAnalysisOptions struct {
 	Resume bool
+	// RetryFailed analyses only the IDs in pending_audits_failures.jsonl that
+	// have no scored result yet, appending to the results file.
+	RetryFailed bool
+	// Stop, when closed, stops dispatching new records; records already
+	// started finish unless ctx is cancelled. See Shutdown.
+	Stop <-chan struct{}
+	// Taxonomy is loaded from cfg.ATTCKCSVPath() when nil.
+	Taxonomy *taxonomy.Taxonomy
+	// Shard restricts the run to the records of one shard (see ParseShard).
+	// Results and failures then go to the shard's own files, to be combined
+	// with MergeShardResults before submitting.
+	Shard Shard
 }
 
 func RunRiskAnalysis(ctx context.Context, cfg *config.RootConfig) error {
 	return RunRiskAnalysisWithOptions(ctx, cfg, RiskAnalysisOptions{})
 }
 
-func RunRiskAnalysisWithOptions(ctx context.Context, cfg *config.RootConfig, opt RiskAnalysisOptions) error {
+func RunRiskAnalysisWithOptions(ctx context.Context, cfg *config.RootConfig, opt RiskAnalysisOptions) (err error) {
 	inFile := cfg.PendingAuditsPath()
-	outResultsFile := cfg.PendingAuditsResultsPath()
+	outResultsFile := opt.Shard.ResultsPath(cfg)
 	if err := os.MkdirAll(filepath.Dir(outResultsFile), 0o755); err != nil {
 		return fmt.Errorf("create state dir failed: %w", err)
 	}
 
-	items, err := loadPendingRecords(inFile)
+	resume := opt.Resume || opt.RetryFailed
+	processed := map[string]bool{}
+	if resume {
+		processed, err = loadProcessedIDs(outResultsFile)
+		if err != nil {
+			return err
+		}
+	}
+	var retry map[string]FailureRecord
+	if opt.RetryFailed {
+		retry, err = LoadFailures(opt.Shard.FailuresPath(cfg))
+		if err != nil {
+			return fmt.Errorf("load failures file failed: %w", err)
+		}
+	}
+	// The pending file is read twice, both times streaming: once for the
+	// IDs only to size the run, then to analyse the records. pick applies
+	// the shard and resume checks; skip is set for records left out because
+	// of Resume or RetryFailed.
+	pick := func(rawID any) (analyse, skip bool) {
+		if !opt.Shard.Contains(rawID) {
+			return false, false
+		}
+		id := types.RecordKey(rawID)
+		if resume && processed[id] {
+			return false, true
+		}
+		if opt.RetryFailed {
+			if _, failed := retry[id]; !failed {
+				return false, true
+			}
+		}
+		return true, false
+	}
+	records, total, err := countPending(inFile, func(id any) bool {
+		analyse, _ := pick(id)
+		return analyse
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("read %s failed: %w", filepath.Base(inFile), err)
 	}
-	if len(items) == 0 {
-		fmt.Printf("[Info] No items found in %s\n", filepath.Base(inFile))
+	if records == 0 {
+		aiLog().Info("no items found", "file", filepath.Base(inFile))
 		return nil
 	}
+	src, err := openPendingReader(inFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
 
-	csvPath := resolveATTCKCSVPath(cfg)
-	if csvPath == "" {
-		return fmt.Errorf("ATT&CK.csv not found (set ai.attck.csv_path or place it at ./ATT&CK.csv or ../ATT&CK.csv)")
+	a, err := newAnalysis(ctx, cfg, opt)
+	if err != nil {
+		return err
 	}
-	if err := taxonomy.Load(csvPath); err != nil {
-		return fmt.Errorf("load ATT&CK.csv failed: %w", err)
+	a.source = src
+	if a.policy != nil {
+		src.keepRaw = append(slices.Clone(rules.RawFields), a.policy.set.RawFields()...)
 	}
 
-	tacticCandidates := buildTacticCandidates(cfg)
-	if len(tacticCandidates) == 0 {
-		return fmt.Errorf("no tactic candidates available")
+	wfResults, err := jsonl.Open(outResultsFile, resume)
+	if err != nil {
+		return fmt.Errorf("open results file failed: %w", err)
+	}
+	defer wfResults.Close()
+	failures, err := openFailureLog(opt.Shard.FailuresPath(cfg))
+	if err != nil {
+		return fmt.Errorf("open failures file failed: %w", err)
 	}
-	tacticCandidatesJSON, _ := json.Marshal(tacticCandidates)
+	defer failures.Close()
+	a.traces, err = openRecordTraces(cfg)
+	if err != nil {
+		return fmt.Errorf("open record traces failed: %w", err)
+	}
+	defer a.traces.Close()
 
-	tmpl, err := promptcomp.BuildRiskTemplate(cfg)
+	mode := "batch"
+	if opt.RetryFailed {
+		mode = "retry_failed"
+	}
+	manifest, err := startRunManifest(cfg, mode, opt)
+	if err != nil {
+		return err
+	}
+	defer func() { manifest.finish(err) }()
+	a.prov = manifest.provenance()
+
+	aiLog().Info("starting risk analysis", "items", total, "shard", opt.Shard.String(), logging.KeyProvider, cfg.AI.Provider, "model", cfg.AI.Model, "concurrency", a.conc.describe(cfg.AI.Concurrency))
+
+	workers := a.conc.Workers(cfg.AI.Concurrency, total)
+	prog := a.startProgress(total, workers)
+	defer prog.Stop()
+
+	var readErr error
+	var policies policyTally
+	items, skipped, written, handled, failed, deduped := 0, 0, 0, 0, 0, 0
+	want := func(id any) bool {
+		analyse, skip := pick(id)
+		if skip {
+			skipped++
+		}
+		return analyse
+	}
+	err = a.run(ctx, total, workers, func(ctx context.Context, jobs chan<- job) {
+		for ctx.Err() == nil {
+			rec, ref, err := src.Next(want)
+			if err != nil {
+				if err != io.EOF {
+					readErr = fmt.Errorf("read %s failed: %w", filepath.Base(inFile), err)
+				}
+				return
+			}
+			metrics.AIQueueDepth.Set(float64(total - items))
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- job{idx: items, rec: rec, ref: ref}:
+				items++
+			}
+		}
+	}, func(r result) error {
+		if r.fail == nil || r.fail.Class != FailureCanceled {
+			handled++
+		}
+		if r.fail != nil && r.fail.Class != FailureCanceled {
+			failed++
+		}
+		if r.policy != nil && r.fail == nil {
+			policies.add(r.policy)
+		}
+		if r.wrote && len(r.line) > 0 {
+			if err := wfResults.WriteLine(r.line); err != nil {
+				return fmt.Errorf("write results file failed: %w", err)
+			}
+			written++
+			if r.deduped {
+				deduped++
+			}
+		}
+		return reportResult(prog, failures, r)
+	})
+	prog.Stop()
+	manifest.count(func(c *RunCounts) {
+		*c = RunCounts{Items: items, Written: written, Failed: failed, Skipped: skipped, Interrupted: items - handled, Deduplicated: deduped}
+		policies.count(c)
+	})
+	policies.log()
+	if err == nil {
+		err = readErr
+	}
 	if err != nil {
-		return fmt.Errorf("load prompt template failed: %w", err)
+		return err
 	}
-	tacticTmpl := promptcomp.BuildATTCKTacticTemplate()
+	printFailureSummary(failures, opt.Shard.FailuresPath(cfg))
 
-	processed := map[string]bool{}
+	if stop.Requested(opt.Stop) || ctx.Err() != nil {
+		if err := syncCheckpoints(wfResults, failures.w, a.traces.writer()); err != nil {
+			return err
+		}
+		aiLog().Warn("risk analysis stopped early, rerun with Resume to continue",
+			"finished", handled, "items", total, "written", written, "file", filepath.Base(outResultsFile), "remaining", total-handled)
+		if stop.Requested(opt.Stop) {
+			return ErrInterrupted
+		}
+		return ctx.Err()
+	}
+
+	aiLog().Info("risk analysis done", "written", written, "deduplicated", deduped, "file", filepath.Base(outResultsFile))
+	return nil
+}
+
+// RunRiskAnalysisStream analyses records from in as they arrive and sends
+// every scored result on out; results are still appended to
+// pending_audits_results.jsonl. With opt.Resume, IDs already in the results
+// file are not analysed again: their scored result is forwarded as is.
+// out is closed when RunRiskAnalysisStream returns.
+func RunRiskAnalysisStream(ctx context.Context, cfg *config.RootConfig, opt RiskAnalysisOptions, in <-chan types.PendingRecord, out chan<- types.RiskRecord) (err error) {
+	defer close(out)
+
+	outResultsFile := opt.Shard.ResultsPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(outResultsFile), 0o755); err != nil {
+		return fmt.Errorf("create state dir failed: %w", err)
+	}
+
+	a, err := newAnalysis(ctx, cfg, opt)
+	if err != nil {
+		return err
+	}
+
+	previous := &resultIndex{}
 	if opt.Resume {
-		processed, err = loadProcessedIDs(outResultsFile)
+		previous, err = openResultIndex(outResultsFile)
 		if err != nil {
 			return err
 		}
+		defer previous.Close()
 	}
 
-	wfResults, err := openResultsFile(outResultsFile, opt.Resume)
+	wfResults, err := jsonl.Open(outResultsFile, opt.Resume)
 	if err != nil {
 		return fmt.Errorf("open results file failed: %w", err)
 	}
 	defer wfResults.Close()
+	failures, err := openFailureLog(opt.Shard.FailuresPath(cfg))
+	if err != nil {
+		return fmt.Errorf("open failures file failed: %w", err)
+	}
+	defer failures.Close()
+	a.traces, err = openRecordTraces(cfg)
+	if err != nil {
+		return fmt.Errorf("open record traces failed: %w", err)
+	}
+	defer a.traces.Close()
 
-	debug := strings.ToLower(os.Getenv("AI_DEBUG"))
-	debugMode := debug == "1" || debug == "true" || debug == "yes"
-	toProcess := make([]types.PendingRecord, 0, len(items))
-	toProcessIdx := make([]int, 0, len(items))
-	for idx, rec := range items {
-		if opt.Resume && processed[fmt.Sprint(rec.ID)] {
-			continue
+	manifest, err := startRunManifest(cfg, "stream", opt)
+	if err != nil {
+		return err
+	}
+	defer func() { manifest.finish(err) }()
+	a.prov = manifest.provenance()
+
+	aiLog().Info("starting streaming risk analysis", logging.KeyProvider, cfg.AI.Provider, "model", cfg.AI.Model, "concurrency", a.conc.describe(cfg.AI.Concurrency))
+
+	forward := func(ctx context.Context, rec types.RiskRecord) error {
+		if _, ok := parser.NormalizeRiskScore(rec.Data["risk_score"]); !ok && !policyHandled(rec.Data[policy.FieldAction]) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- rec:
+			return nil
 		}
-		toProcess = append(toProcess, rec)
-		toProcessIdx = append(toProcessIdx, idx)
 	}
 
-	fmt.Printf("[Info] Starting risk analysis for %d items using %s (model: %s, concurrency: %d)...\n", len(toProcess), cfg.AI.Provider, cfg.AI.Model, cfg.AI.Concurrency)
+	workers := a.conc.Workers(cfg.AI.Concurrency, 0)
+	prog := a.startProgress(0, workers)
+	defer prog.Stop()
 
-	limiter := newLLMLimiter(cfg.AI.RateLimitQPS)
-	if limiter != nil {
-		defer limiter.Close()
+	var policies policyTally
+	received, written, reused, failed, handled, deduped := 0, 0, 0, 0, 0, 0
+	err = a.run(ctx, 0, workers, func(ctx context.Context, jobs chan<- job) {
+		idx := 0
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rec, ok := <-in:
+				if !ok {
+					return
+				}
+				if !opt.Shard.Contains(rec.ID) {
+					continue
+				}
+				received++
+				prog.AddTotal(1)
+				metrics.AIQueueDepth.Set(float64(len(in) + 1))
+				prev, ok, err := previous.Read(rec.ID)
+				if err != nil {
+					aiLog().Warn("analysing record again", logging.KeyRecordID, rec.ID, "error", err)
+				}
+				if ok && err == nil {
+					reused++
+					prog.Skip()
+					if forward(ctx, prev) != nil {
+						return
+					}
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case jobs <- job{idx: idx, rec: rec}:
+					idx++
+				}
+			}
+		}
+	}, func(r result) error {
+		if r.fail == nil || r.fail.Class != FailureCanceled {
+			handled++
+		}
+		if r.fail != nil && r.fail.Class != FailureCanceled {
+			failed++
+		}
+		if r.policy != nil && r.fail == nil {
+			policies.add(r.policy)
+		}
+		if err := reportResult(prog, failures, r); err != nil {
+			return err
+		}
+		if !r.wrote || len(r.line) == 0 {
+			return nil
+		}
+		if err := wfResults.WriteLine(r.line); err != nil {
+			return fmt.Errorf("write results file failed: %w", err)
+		}
+		written++
+		if r.deduped {
+			deduped++
+		}
+		return forward(ctx, types.RiskRecord{ID: r.id, Data: r.data})
+	})
+	prog.Stop()
+	manifest.count(func(c *RunCounts) {
+		*c = RunCounts{Items: received, Written: written, Failed: failed, Skipped: reused, Interrupted: received - reused - handled, Deduplicated: deduped}
+		policies.count(c)
+	})
+	policies.log()
+	if err != nil {
+		return err
+	}
+	printFailureSummary(failures, opt.Shard.FailuresPath(cfg))
+
+	if stop.Requested(opt.Stop) {
+		if err := syncCheckpoints(wfResults, failures.w, a.traces.writer()); err != nil {
+			return err
+		}
+		aiLog().Warn("streaming analysis stopped early, rerun with Resume to continue", "written", written, "file", filepath.Base(outResultsFile))
+		return ErrInterrupted
 	}
 
-	type job struct {
-		idx int
-		rec types.PendingRecord
+	aiLog().Info("streaming analysis done", "written", written, "reused", reused, "deduplicated", deduped, "file", filepath.Base(outResultsFile))
+	return ctx.Err()
+}
+
+type job struct {
+	idx int
+	rec types.PendingRecord
+	// ref locates the record in analysis.source; unset for streamed records,
+	// which carry their full data.
+	ref lineRef
+}
+
+type result struct {
+	idx   int
+	id    any
+	wrote bool
+	line  []byte
+	data  map[string]any
+	// attrs are the log fields of the record.
+	attrs []any
+	// fail is set for records that produced no result line.
+	fail *RecordError
+	// deduped is set for records that took their group's verdict.
+	deduped bool
+	// policy is set for records a policy handled instead of the model.
+	policy *policy.Policy
+}
+
+// reportResult counts r in prog, appends failures to the dead-letter file
+// and logs the outcome. Interrupted records are only logged at debug level.
+func reportResult(prog *progress.Reporter, failures *failureLog, r result) error {
+	log := aiLog()
+	switch {
+	case r.policy != nil && r.fail == nil:
+		prog.Skip()
+		log.Info("record handled by policy", r.attrs...)
+		return nil
+	case r.fail == nil:
+		prog.Success()
+		log.Info("record analysed", r.attrs...)
+		return nil
+	case r.fail.Class == FailureCanceled:
+		log.Debug("record interrupted", r.attrs...)
+		return nil
 	}
-	type result struct {
-		idx   int
-		id    any
-		wrote bool
-		line  []byte
-		log   string
+	prog.Failure()
+	attempt, err := failures.Record(r.id, r.fail)
+	log.Warn("record failed", append(r.attrs, "error_class", r.fail.Class, logging.KeyAttempt, attempt, "error", r.fail.Err)...)
+	return err
+}
+
+func printFailureSummary(failures *failureLog, path string) {
+	if failures.count > 0 {
+		aiLog().Warn("records failed, rerun with RetryFailed to analyse only those", "failed", failures.count, "file", filepath.Base(path))
 	}
-	type workerProcessor func(context.Context, int, types.PendingRecord) result
+}
+
+func aiLog() *slog.Logger {
+	return logging.Stage("ai")
+}
 
-	initWorker := func() (workerProcessor, error) {
-		chatModel, err := modelcomp.NewChatModel(ctx, cfg)
+type workerProcessor func(context.Context, job) result
+
+// analysis holds what the AI workers of one run share.
+type analysis struct {
+	cfg     *config.RootConfig
+	stop    <-chan struct{}
+	nodes   RecordNodes
+	limiter *llmLimiter
+	// conc is nil unless adaptive concurrency is enabled.
+	conc  *concurrencyController
+	debug bool
+	// traces is nil unless ai.record_trace is set.
+	traces *recordTraceWriter
+	// prov is stamped onto result lines.
+	prov provenance
+	// source is the pending file records were read from without their raw
+	// detail; nil when records arrive with full data.
+	source *pendingReader
+	// dedup is nil unless ai.dedup.enabled is set.
+	dedup *dedupGroups
+	// policy is nil unless ai.policy_path has policies.
+	policy *recordPolicy
+}
+
+func newAnalysis(ctx context.Context, cfg *config.RootConfig, opt RiskAnalysisOptions) (*analysis, error) {
+	tax := opt.Taxonomy
+	if tax == nil {
+		var err error
+		tax, err = LoadTaxonomy(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("init chat model failed: %w", err)
+			return nil, err
 		}
+	}
 
-		waitLLM := func(ctx context.Context) error {
-			if limiter != nil {
-				return limiter.Wait(ctx)
-			}
-			t := time.NewTimer(100 * time.Millisecond)
-			defer t.Stop()
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-t.C:
-				return nil
-			}
+	embedder, err := modelcomp.NewEmbedder(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init embedder failed: %w", err)
+	}
+	if embedder != nil {
+		indexPath := cfg.ATTCKEmbeddingIndexPath(cfg.ATTCKCSVPath())
+		n, err := tax.BuildVectorIndex(ctx, embedder, taxonomy.VectorIndexOptions{
+			Model:  cfg.AI.ATTCK.Embedding.Model,
+			Path:   indexPath,
+			Weight: cfg.AI.ATTCK.Embedding.SemanticWeight(),
+		})
+		if err != nil {
+			aiLog().Warn("build technique vector index failed, using lexical candidates only", "error", err)
+			embedder = nil
+		} else {
+			aiLog().Info("technique vector index ready", "embedded", n, "index", indexPath)
 		}
+	}
 
-		return func(ctx context.Context, idx int, rec types.PendingRecord) result {
-			total := len(items)
-			data := rec.Data
-			if data == nil {
-				data = map[string]any{}
-			}
+	tacticCandidates := buildTacticCandidates(cfg, tax)
+	if len(tacticCandidates) == 0 {
+		return nil, fmt.Errorf("no tactic candidates available")
+	}
+	ruleSet, err := LoadRules(cfg, tax)
+	if err != nil {
+		return nil, err
+	}
 
-			contextText := buildTrimmedContext(cfg, data)
+	tmpl, err := promptcomp.BuildRiskTemplate(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("load prompt template failed: %w", err)
+	}
 
-			tacticMsgs, err := tacticTmpl.Format(ctx, map[string]any{
-				"context":           contextText,
-				"tactic_candidates": string(tacticCandidatesJSON),
-			})
-			if err != nil {
-				return result{idx: idx, id: rec.ID, wrote: false, log: fmt.Sprintf("[%d/%d] ID: %v -> Prompt Format Error: %v", idx+1, total, rec.ID, err)}
-			}
+	debug := strings.ToLower(os.Getenv("AI_DEBUG"))
+	a := &analysis{
+		cfg:  cfg,
+		stop: opt.Stop,
+		nodes: RecordNodes{
+			Cfg:              cfg,
+			Taxonomy:         tax,
+			Rules:            ruleSet,
+			TacticCandidates: tacticCandidates,
+			TacticTemplate:   promptcomp.BuildATTCKTacticTemplate(),
+			RiskTemplate:     tmpl,
+			Embedder:         embedder,
+		},
+		limiter: newLLMLimiter(cfg.AI),
+		conc:    newConcurrencyController(cfg),
+		debug:   debug == "1" || debug == "true" || debug == "yes",
+	}
+	if cfg.AI.Dedup.Enabled {
+		a.dedup = newDedupGroups(cfg, ruleSet)
+	}
+	if a.policy, err = loadRecordPolicy(cfg, ruleSet); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
 
-			if debugMode && idx == 0 {
-				fmt.Println("=== DEBUG PROMPT BEGIN ===")
-				if len(tacticMsgs) > 0 {
-					fmt.Println(truncate(tacticMsgs[0].Content, 500) + "...")
-				}
-				fmt.Println("=== DEBUG PROMPT END ===")
-			}
+// newWorker builds one worker's chat model and record graph. total is only
+// used for the progress log; 0 means unknown.
+func (a *analysis) newWorker(ctx context.Context, total int) (workerProcessor, error) {
+	chatModel, err := modelcomp.NewChatModel(ctx, a.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init chat model failed: %w", err)
+	}
 
-			if err := waitLLM(ctx); err != nil {
-				return result{idx: idx, id: rec.ID, wrote: false, log: fmt.Sprintf("[%d/%d] ID: %v -> Error: %v", idx+1, total, rec.ID, err)}
-			}
-			tacticResp, err := chatModel.Generate(ctx, tacticMsgs)
-			selectedTactic := ""
-			if err == nil {
-				selectedTactic = parseJSONStringField(tacticResp.Content, "tactic_name")
-			}
-			selectedTactic = strings.TrimSpace(selectedTactic)
-			if !isInList(selectedTactic, tacticCandidates) {
-				selectedTactic = tacticCandidates[0]
-			}
+	nodes := a.nodes
+	nodes.Model = chatModel
+	if a.cfg.AI.Judge.Model != "" {
+		judgeCfg := *a.cfg
+		judgeCfg.AI.Model = a.cfg.AI.Judge.Model
+		if a.cfg.AI.Judge.BaseURL != "" {
+			judgeCfg.AI.BaseURL = a.cfg.AI.Judge.BaseURL
+		}
+		if nodes.JudgeModel, err = modelcomp.NewChatModel(ctx, &judgeCfg); err != nil {
+			return nil, fmt.Errorf("init judge model failed: %w", err)
+		}
+	}
+	if a.limiter != nil {
+		nodes.Wait = a.limiter.Wait
+	}
+	if a.conc != nil {
+		nodes.Observe = a.conc.Observe
+	}
+	recordGraph, err := BuildRecordGraph(ctx, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("build record graph failed: %w", err)
+	}
 
-			techCands := taxonomy.GenerateTechniqueCandidates(
-				selectedTactic,
-				contextText,
-				cfg.AI.ATTCK.TechniqueTopK,
-				cfg.AI.ATTCK.SubMaxPerTechnique,
-			)
-			techCandsText := taxonomy.FormatTechniqueCandidates(selectedTactic, techCands, cfg.AI.ATTCK.CandidateMaxRunes)
-			allowedTech, allowedSub := buildAllowedFromCandidates(techCands)
-
-			msgs, err := tmpl.Format(ctx, map[string]any{
-				"context":              contextText,
-				"tactic_name_selected": selectedTactic,
-				"technique_candidates": techCandsText,
-			})
+	// process runs the record graph on j. With group set (deduplication on)
+	// it also returns the verdict the record adds to its data, for the
+	// other members of the group.
+	//
+	// Only this takes an adaptive concurrency slot: dedup members waiting
+	// for their representative and records a policy handles make no model
+	// calls.
+	process := func(ctx context.Context, j job, group string) (result, map[string]any) {
+		idx, rec := j.idx, j.rec
+		if a.conc != nil {
+			// A record still waiting for a slot when the run is stopped is
+			// not started.
+			slotCtx, cancel := contextUntilStop(ctx, a.stop)
+			err := a.conc.Acquire(slotCtx)
+			cancel()
 			if err != nil {
-				return result{idx: idx, id: rec.ID, wrote: false, log: fmt.Sprintf("[%d/%d] ID: %v -> Prompt Format Error: %v", idx+1, total, rec.ID, err)}
-			}
-
-			var promptText string
-			if len(msgs) > 0 {
-				promptText = msgs[0].Content
+				return result{idx: idx, id: rec.ID, attrs: a.recordAttrs(j, total, time.Now()), fail: &RecordError{Class: FailureCanceled, Err: err}}, nil
 			}
-			if debugMode && idx == 0 {
-				fmt.Println("=== DEBUG PROMPT2 BEGIN ===")
-				fmt.Println(truncate(promptText, 500) + "...")
-				fmt.Println("=== DEBUG PROMPT2 END ===")
+			defer a.conc.Release()
+		}
+		ctx, span := tracing.Start(ctx, "record", "audit.record_id", types.RecordKey(rec.ID))
+		defer span.End()
+		start := time.Now()
+		var before map[string]any
+		if group != "" {
+			before = maps.Clone(rec.Data)
+		}
+		state := &RecordState{
+			ID:    rec.ID,
+			Data:  rec.Data,
+			Debug: a.debug && idx == 0,
+		}
+		s, err := recordGraph.Invoke(ctx, state)
+		attrs := a.recordAttrs(j, total, start)
+		if err != nil {
+			var re *RecordError
+			if !errors.As(err, &re) {
+				re = &RecordError{Class: FailureInternal, Err: err}
+				if ctx.Err() != nil {
+					re.Class = FailureCanceled
+				}
 			}
-
-			if err := waitLLM(ctx); err != nil {
-				return result{idx: idx, id: rec.ID, wrote: false, log: fmt.Sprintf("[%d/%d] ID: %v -> Error: %v", idx+1, total, rec.ID, err)}
+			span.SetAttributes("audit.failure_class", re.Class)
+			span.RecordError(re.Err)
+			if re.Class != FailureCanceled {
+				a.writeTrace(newRecordTrace(state, nodes.TacticCandidates, re))
 			}
-			respMsg, err := chatModel.Generate(ctx, msgs)
+			return result{idx: idx, id: rec.ID, wrote: false, attrs: attrs, fail: re}, nil
+		}
+		var verdict map[string]any
+		if group != "" {
+			verdict = dedupVerdict(before, recordResultData(s))
+			s.Data[dedupGroupField] = group
+			attrs = append(attrs, dedupGroupField, group)
+		}
+		data := recordResultData(s)
+		attrs = append(attrs, recordLogAttrs(s)...)
+		span.SetAttributes("audit.score", s.Score, "audit.score_source", s.ScoreSource)
+		a.writeTrace(newRecordTrace(s, nodes.TacticCandidates, nil))
+		line, err := a.resultLine(j, data)
+		if err != nil {
+			return result{idx: idx, id: rec.ID, attrs: attrs, fail: &RecordError{Class: FailureInternal, Err: err}}, nil
+		}
+		return result{idx: idx, id: rec.ID, wrote: true, line: line, data: data, attrs: attrs}, verdict
+	}
 
-			var rawResponse string
-			var score int
-			var logLine string
+	analyse := func(ctx context.Context, j job) result {
+		r, _ := process(ctx, j, "")
+		return r
+	}
+	if a.dedup != nil {
+		analyse = a.dedupProcessor(process, total)
+	}
+	if a.policy == nil {
+		return analyse, nil
+	}
+	return func(ctx context.Context, j job) result {
+		if r, ok := a.applyPolicy(j, total); ok {
+			return r
+		}
+		return analyse(ctx, j)
+	}, nil
+}
 
-			if err != nil {
-				score = -1
-				logLine = fmt.Sprintf("[%d/%d] ID: %v -> Error: %v", idx+1, total, rec.ID, err)
-			} else {
-				rawResponse = respMsg.Content
-				if debugMode && idx == 0 {
-					fmt.Println("=== DEBUG RESPONSE BEGIN ===")
-					fmt.Println(rawResponse)
-					fmt.Println("=== DEBUG RESPONSE END ===")
-				}
-				structuredScore, structuredData, parseErr := parser.ParseStructuredJSON(rawResponse)
-				if parseErr != nil {
-					score = parseScore(rawResponse)
-					logLine = fmt.Sprintf("[%d/%d] ID: %v -> Score(text): %d", idx+1, total, rec.ID, score)
-				} else {
-					if structuredScore >= 0 {
-						score = structuredScore
-					}
-					parser.ApplyStructuredFields(data, structuredData)
-					sanitizeATTCKSelection(data, selectedTactic, allowedTech, allowedSub)
-					logLine = fmt.Sprintf("[%d/%d] ID: %v -> Score(json): %d", idx+1, total, rec.ID, score)
-				}
+// dedupProcessor runs process on the representative of each dedup group and
+// hands its verdict to the other members.
+func (a *analysis) dedupProcessor(process func(context.Context, job, string) (result, map[string]any), total int) workerProcessor {
+	return func(ctx context.Context, j job) result {
+		start := time.Now()
+		fp := a.dedup.fingerprint(j.rec.Data)
+		for {
+			g, leader := a.dedup.join(fp, j.rec.ID)
+			if leader {
+				r, verdict := process(ctx, j, g.id)
+				a.dedup.finish(g, verdict)
+				return r
 			}
-
-			newData := map[string]any{}
-			for k, v := range data {
-				newData[k] = v
+			select {
+			case <-ctx.Done():
+				return result{idx: j.idx, id: j.rec.ID, attrs: a.recordAttrs(j, total, start), fail: &RecordError{Class: FailureCanceled, Err: ctx.Err()}}
+			case <-g.done:
 			}
-
-			if existing, ok := newData["risk_score"]; ok {
-				if v, ok := parser.NormalizeRiskScore(existing); ok {
-					newData["risk_score"] = v
-				} else {
-					delete(newData, "risk_score")
-				}
-			} else if v, ok := parser.NormalizeRiskScore(score); ok {
-				newData["risk_score"] = v
+			// A failed representative leaves the group; the next member to
+			// join becomes the new one.
+			if g.verdict != nil {
+				return a.shareVerdict(ctx, j, g, a.recordAttrs(j, total, start))
 			}
+		}
+	}
+}
+
+// recordAttrs are the log fields every record outcome starts with.
+func (a *analysis) recordAttrs(j job, total int, start time.Time) []any {
+	attrs := []any{logging.KeyRecordID, j.rec.ID, "progress", progressTag(j.idx, total), logging.KeyDuration, time.Since(start)}
+	if a.conc != nil {
+		attrs = append(attrs, "concurrency", a.conc.Limit())
+	}
+	return attrs
+}
 
-			resultsRec := map[string]any{"id": rec.ID, "generated_at": utcISO(), "data": newData}
-			bResults, _ := json.Marshal(resultsRec)
-			return result{idx: idx, id: rec.ID, wrote: true, line: bResults, log: logLine}
-		}, nil
+// resultLine renders the results file line of j with data. Records read
+// through a.source get their full raw detail back for Submit.
+func (a *analysis) resultLine(j job, data map[string]any) ([]byte, error) {
+	if a.source == nil {
+		return recordResultLine(j.rec.ID, data, a.prov), nil
 	}
+	raw, err := a.source.Raw(j.ref, j.rec.ID)
+	if err != nil {
+		return nil, err
+	}
+	lineData := make(map[string]any, len(data))
+	for k, v := range data {
+		lineData[k] = v
+	}
+	delete(lineData, "_raw")
+	if len(raw) > 0 {
+		lineData["_raw"] = raw
+	}
+	return recordResultLine(j.rec.ID, lineData, a.prov), nil
+}
 
-	workers := cfg.AI.Concurrency
+// run processes the jobs produced by feed with the given number of workers
+// and hands every result to emit from a single goroutine. feed must return
+// once its ctx is done, which happens on ctx cancellation or a.stop; an emit
+// error stops the run and is returned.
+func (a *analysis) run(ctx context.Context, total, workers int, feed func(context.Context, chan<- job), emit func(result) error) error {
 	if workers <= 0 {
 		workers = 1
 	}
-	if workers > len(toProcess) && len(toProcess) > 0 {
-		workers = len(toProcess)
-	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	jobsCh := make(chan job)
-	resultsCh := make(chan result)
+	if a.conc != nil {
+		metrics.AIConcurrency.Set(float64(a.conc.Limit()))
+	} else {
+		metrics.AIConcurrency.Set(float64(workers))
+	}
+	defer metrics.AIQueueDepth.Set(0)
 
-	var wg sync.WaitGroup
+	processors := make([]workerProcessor, 0, workers)
 	for i := 0; i < workers; i++ {
-		processor, err := initWorker()
+		p, err := a.newWorker(ctx, total)
 		if err != nil {
 			return err
 		}
+		processors = append(processors, p)
+	}
+
+	jobsCh := make(chan job)
+	resultsCh := make(chan result)
+
+	var wg sync.WaitGroup
+	for _, p := range processors {
 		wg.Add(1)
 		go func(p workerProcessor) {
 			defer wg.Done()
 			for j := range jobsCh {
-				r := p(ctx, j.idx, j.rec)
-				select {
-				case <-ctx.Done():
-					return
-				case resultsCh <- r:
-				}
+				metrics.AIInFlight.Add(1)
+				r := p(ctx, j)
+				metrics.AIInFlight.Add(-1)
+				// Always hand the result over, even when cancelled: the
+				// collector drains resultsCh until it is closed.
+				resultsCh <- r
 			}
-		}(processor)
+		}(p)
 	}
 
+	// Feeding also stops on a.stop; workers keep ctx so that started
+	// records can finish.
+	feedCtx, cancelFeed := contextUntilStop(ctx, a.stop)
+	defer cancelFeed()
+	feedDone := make(chan struct{})
 	go func() {
-		for i, rec := range toProcess {
-			select {
-			case <-ctx.Done():
-				close(jobsCh)
-				return
-			case jobsCh <- job{idx: toProcessIdx[i], rec: rec}:
-			}
-		}
+		defer close(feedDone)
+		feed(feedCtx, jobsCh)
 		close(jobsCh)
 	}()
 
@@ -299,75 +773,43 @@ func RunRiskAnalysisWithOptions(ctx context.Context, cfg *config.RootConfig, opt
 		close(resultsCh)
 	}()
 
-	written := 0
+	var emitErr error
 	for r := range resultsCh {
-		if strings.TrimSpace(r.log) != "" {
-			fmt.Println(r.log)
+		if emitErr != nil {
+			continue
 		}
-		if r.wrote && len(r.line) > 0 {
-			_, _ = wfResults.Write(r.line)
-			_, _ = wfResults.WriteString("\n")
-			written++
+		if err := emit(r); err != nil {
+			emitErr = err
+			cancel()
 		}
 	}
-
-	fmt.Printf("[Success] Completed. %d records processed, results written to %s\n", written, filepath.Base(outResultsFile))
-	return nil
+	cancel()
+	<-feedDone
+	return emitErr
 }
 
-type llmLimiter struct {
-	tokens <-chan struct{}
-	stop   func()
-}
-
-func newLLMLimiter(qps int) *llmLimiter {
-	if qps <= 0 {
-		return nil
-	}
-	tokens := make(chan struct{}, qps)
-	done := make(chan struct{})
-	interval := time.Second / time.Duration(qps)
-	ticker := time.NewTicker(interval)
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				select {
-				case tokens <- struct{}{}:
-				default:
-				}
-			}
-		}
-	}()
-	return &llmLimiter{
-		tokens: tokens,
-		stop:   func() { close(done) },
+// startProgress starts the AI progress reporter; workers is shown as the
+// concurrency unless it is adaptive.
+func (a *analysis) startProgress(total, workers int) *progress.Reporter {
+	prog := progress.Start(a.cfg, "ai", total)
+	if a.conc != nil {
+		prog.SetConcurrency(a.conc.Limit)
+	} else {
+		prog.SetConcurrency(func() int { return workers })
 	}
+	return prog
 }
 
-func (l *llmLimiter) Wait(ctx context.Context) error {
-	if l == nil {
-		return nil
+func progressTag(idx, total int) string {
+	if total > 0 {
+		return fmt.Sprintf("%d/%d", idx+1, total)
 	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-l.tokens:
-		return nil
-	}
-}
-
-func (l *llmLimiter) Close() {
-	if l == nil || l.stop == nil {
-		return
-	}
-	l.stop()
+	return fmt.Sprint(idx + 1)
 }
 
-func loadPendingRecords(path string) ([]types.PendingRecord, error) {
+// FindRecord scans a JSONL file of {"id":...,"data":{...}} lines and returns
+// the first record whose ID matches id, or nil when none does.
+func FindRecord(path, id string) (*types.PendingRecord, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -377,7 +819,7 @@ func loadPendingRecords(path string) ([]types.PendingRecord, error) {
 	}
 	defer f.Close()
 
-	var items []types.PendingRecord
+	id = strings.TrimSpace(id)
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 	for scanner.Scan() {
@@ -385,23 +827,32 @@ func loadPendingRecords(path string) ([]types.PendingRecord, error) {
 		if line == "" {
 			continue
 		}
+		var head struct {
+			ID any `json:"id"`
+		}
+		if err := decodeLine([]byte(line), &head); err != nil || types.RecordKey(head.ID) != id {
+			continue
+		}
 		var rec types.PendingRecord
 		if err := json.Unmarshal([]byte(line), &rec); err != nil {
-			continue
+			return nil, err
 		}
-		items = append(items, rec)
+		return &rec, nil
 	}
-	if err := scanner.Err(); err != nil {
-		return items, err
-	}
-	return items, nil
+	return nil, scanner.Err()
 }
 
-func openResultsFile(path string, resume bool) (*os.File, error) {
-	if resume {
-		return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+// syncCheckpoints flushes and fsyncs the given checkpoint files.
+func syncCheckpoints(ws ...*jsonl.Writer) error {
+	for _, w := range ws {
+		if w == nil {
+			continue
+		}
+		if err := w.Sync(); err != nil {
+			return fmt.Errorf("sync checkpoint failed: %w", err)
+		}
 	}
-	return os.Create(path)
+	return nil
 }
 
 func loadProcessedIDs(path string) (map[string]bool, error) {
@@ -423,13 +874,22 @@ func loadProcessedIDs(path string) (map[string]bool, error) {
 			continue
 		}
 		var rec struct {
-			ID any `json:"id"`
+			ID   any `json:"id"`
+			Data struct {
+				RiskScore    any `json:"risk_score"`
+				PolicyAction any `json:"policy_action"`
+			} `json:"data"`
 		}
-		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		if err := decodeLine([]byte(line), &rec); err != nil {
+			continue
+		}
+		// Lines without a valid score (written by older versions for failed
+		// model calls) do not count as processed, unless a policy wrote them.
+		if _, ok := parser.NormalizeRiskScore(rec.Data.RiskScore); !ok && !policyHandled(rec.Data.PolicyAction) {
 			continue
 		}
-		if strings.TrimSpace(fmt.Sprint(rec.ID)) != "" {
-			ids[fmt.Sprint(rec.ID)] = true
+		if id := types.RecordKey(rec.ID); id != "" {
+			ids[id] = true
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -438,6 +898,80 @@ func loadProcessedIDs(path string) (map[string]bool, error) {
 	return ids, nil
 }
 
+// resultIndex locates the scored records of pending_audits_results.jsonl by
+// ID, so that a resumed streaming run can forward them without holding them
+// in memory; a later line for the same ID replaces an earlier one.
+type resultIndex struct {
+	f    *os.File
+	refs map[string]lineRef
+}
+
+// openResultIndex indexes path. A missing file yields an empty index.
+func openResultIndex(path string) (*resultIndex, error) {
+	x := &resultIndex{refs: map[string]lineRef{}}
+	err := scanJSONLRefs(path, func(line []byte, ref lineRef) error {
+		var rec struct {
+			ID   any `json:"id"`
+			Data struct {
+				RiskScore    any `json:"risk_score"`
+				PolicyAction any `json:"policy_action"`
+			} `json:"data"`
+		}
+		if decodeLine(line, &rec) != nil {
+			return nil
+		}
+		if _, ok := parser.NormalizeRiskScore(rec.Data.RiskScore); !ok && !policyHandled(rec.Data.PolicyAction) {
+			return nil
+		}
+		if id := types.RecordKey(rec.ID); id != "" {
+			x.refs[id] = ref
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return x, nil
+		}
+		return nil, err
+	}
+	if len(x.refs) > 0 {
+		if x.f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// Read reads back the indexed record of id; ok is false when there is none.
+func (x *resultIndex) Read(id any) (rec types.RiskRecord, ok bool, err error) {
+	ref, ok := x.refs[types.RecordKey(id)]
+	if !ok {
+		return rec, false, nil
+	}
+	line, err := readLine(x.f, ref)
+	if err != nil {
+		return rec, true, fmt.Errorf("read previous result failed: %w", err)
+	}
+	var head struct {
+		ID any `json:"id"`
+	}
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, true, fmt.Errorf("decode previous result failed: %w", err)
+	}
+	// Keep the exact ID; Submit keys it like the fetched one.
+	if decodeLine(line, &head) == nil {
+		rec.ID = head.ID
+	}
+	return rec, true, nil
+}
+
+func (x *resultIndex) Close() error {
+	if x.f == nil {
+		return nil
+	}
+	return x.f.Close()
+}
+
 func parseScore(text string) int {
 	re := regexp.MustCompile(`\d+`)
 	match := re.FindString(text)
@@ -472,7 +1006,9 @@ func truncate(s string, max int) string {
 	return string(r[:max])
 }
 
-func buildTrimmedContext(cfg *config.RootConfig, data map[string]any) string {
+// BuildTrimmedContext joins name, description, PoC, request and response of a
+// record into the prompt context, applying the ai.context budgets.
+func BuildTrimmedContext(cfg *config.RootConfig, data map[string]any) string {
 	if cfg == nil {
 		return ""
 	}
@@ -534,29 +1070,79 @@ func buildTrimmedContext(cfg *config.RootConfig, data map[string]any) string {
 	return b.String()
 }
 
-func resolveATTCKCSVPath(cfg *config.RootConfig) string {
-	var candidates []string
-	if cfg != nil && strings.TrimSpace(cfg.AI.ATTCK.CSVPath) != "" {
-		candidates = append(candidates, strings.TrimSpace(cfg.AI.ATTCK.CSVPath))
+// LoadTaxonomy loads ATT&CK.csv from the path resolved by cfg.ATTCKCSVPath
+// with the ai.attck.alias_path alias table and, when ai.attck.stix_path is
+// set, joins the MITRE STIX bundle into it.
+func LoadTaxonomy(cfg *config.RootConfig) (*taxonomy.Taxonomy, error) {
+	csvPath := cfg.ATTCKCSVPath()
+	if csvPath == "" {
+		return nil, fmt.Errorf("ATT&CK.csv not found (set ai.attck.csv_path or place it at ./ATT&CK.csv or ../ATT&CK.csv)")
+	}
+	tax, err := taxonomy.Load(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("load ATT&CK.csv failed: %w", err)
+	}
+	aliases, err := taxonomy.LoadAliases(cfg.AI.ATTCK.AliasPath)
+	if err != nil {
+		return nil, err
+	}
+	tax.SetAliases(aliases)
+
+	stixPath := strings.TrimSpace(cfg.AI.ATTCK.STIXPath)
+	if stixPath == "" {
+		return tax, nil
+	}
+	bundle, err := taxonomy.LoadSTIX(stixPath)
+	if err != nil {
+		return nil, fmt.Errorf("load STIX bundle failed: %w", err)
 	}
-	candidates = append(candidates,
-		"ATT&CK.csv",
-		"../ATT&CK.csv",
-	)
+	rep := tax.AttachSTIX(bundle)
+	log := logging.Stage("taxonomy")
+	log.Info("ATT&CK STIX joined", "matched", rep.Matched, "only_in_stix", len(rep.OnlyInSTIX), "only_in_csv", len(rep.OnlyInCSV),
+		"deprecated", len(rep.DeprecatedInCSV), "missing_code", rep.MissingCode, "tactic_mismatches", len(rep.TacticMismatches))
+	if len(rep.OnlyInCSV) > 0 {
+		log.Warn("ATT&CK codes in CSV but not in STIX", "codes", strings.Join(rep.OnlyInCSV, ", "))
+	}
+	if len(rep.DeprecatedInCSV) > 0 {
+		log.Warn("ATT&CK codes deprecated by MITRE", "codes", strings.Join(rep.DeprecatedInCSV, ", "))
+	}
+	for _, m := range rep.TacticMismatches {
+		log.Warn("ATT&CK technique filed under a tactic STIX does not list", "technique", m)
+	}
+	return tax, nil
+}
 
-	for _, p := range candidates {
-		if p == "" {
+// LoadRules loads ai.rules_path and canonicalises the ATT&CK names of assign
+// rules against tax, so rule verdicts submit like model ones.
+func LoadRules(cfg *config.RootConfig, tax *taxonomy.Taxonomy) (*rules.Set, error) {
+	set, err := rules.Load(cfg.AI.RulesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range set.Rules {
+		if r.Assign == nil || strings.TrimSpace(r.Assign.TacticName) == "" {
 			continue
 		}
-		if _, err := os.Stat(p); err == nil {
-			return p
+		m, ok := tax.Resolve(r.Assign.TacticName, r.Assign.TechniqueName, r.Assign.SubTechniqueName)
+		if !ok {
+			return nil, fmt.Errorf("rule %s: tactic %q not found in ATT&CK.csv", r.ID, r.Assign.TacticName)
+		}
+		if method := m.Method(); method != string(taxonomy.MatchExact) {
+			logging.Stage("taxonomy").Warn("rule ATT&CK selection resolved inexactly", "rule_id", r.ID, "match_method", method,
+				"tactic", m.TacticName, "technique", m.TechniqueName, "sub_technique", m.SubTechniqueName)
 		}
+		r.Assign.TacticName = m.TacticName
+		r.Assign.TechniqueName = m.TechniqueName
+		r.Assign.SubTechniqueName = m.SubTechniqueName
 	}
-	return ""
+	if n := len(set.Rules); n > 0 {
+		aiLog().Info("loaded pre-classification rules", "rules", n, "path", cfg.AI.RulesPath)
+	}
+	return set, nil
 }
 
-func buildTacticCandidates(cfg *config.RootConfig) []string {
-	all := taxonomy.ListTactics()
+func buildTacticCandidates(cfg *config.RootConfig, tax *taxonomy.Taxonomy) []string {
+	all := tax.ListTactics()
 	if cfg == nil || len(cfg.AI.ATTCK.TacticAllowlist) == 0 {
 		return all
 	}
@@ -1,12 +1,10 @@
 package orchestrator
 
 import (
-	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
-	"time"
 
 	"audit-workflow/internal/config"
 )
@@ -33,7 +31,7 @@ func TestBuildTrimmedContext_RespectsBudgets(t *testing.T) {
 		"resp_pkg":         strings.Repeat("响", 50),
 	}
 
-	out := buildTrimmedContext(cfg, data)
+	out := BuildTrimmedContext(cfg, data)
 	if out == "" {
 		t.Fatalf("expected non-empty output")
 	}
@@ -46,46 +44,38 @@ func TestBuildTrimmedContext_RespectsBudgets(t *testing.T) {
 	}
 }
 
-func TestLoadPendingRecords_LongLines(t *testing.T) {
-	dir := t.TempDir()
-	p := filepath.Join(dir, "pending.jsonl")
+func TestResultIndex_ReadsLatestScoredLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	content := `{"id": 1, "data": {"risk_score": 3}}
+{"id": 2, "data": {"risk_score": null}}
 
-	long := strings.Repeat("x", 200*1024)
-	line1 := `{"id":1,"data":{"name":"a"}}` + "\n"
-	line2 := `{"id":2,"data":{"description":"` + long + `"}}` + "\n"
-	if err := os.WriteFile(p, []byte(line1+line2), 0o644); err != nil {
-		t.Fatalf("write file: %v", err)
+{"id": 3000000, "data": {"policy_action": "reject"}}
+{"id": 1, "data": {"risk_score": 8, "_raw": {"bulk": "x"}}}
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
 	}
-
-	items, err := loadPendingRecords(p)
+	x, err := openResultIndex(path)
 	if err != nil {
-		t.Fatalf("load: %v", err)
+		t.Fatal(err)
 	}
-	if len(items) != 2 {
-		t.Fatalf("expected 2 items, got %d", len(items))
+	defer x.Close()
+	if len(x.refs) != 2 {
+		t.Fatalf("indexed %v, want IDs 1 and 3000000", x.refs)
 	}
-}
-
-func TestLLMLimiter_WaitHonorsContextCancel(t *testing.T) {
-	limiter := newLLMLimiter(1)
-	defer limiter.Close()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
-	defer cancel()
-
-	if err := limiter.Wait(ctx); err == nil {
-		t.Fatalf("expected context cancellation error")
+	rec, ok, err := x.Read(1)
+	if err != nil || !ok || rec.Data["risk_score"] != 8.0 || rec.Data["_raw"].(map[string]any)["bulk"] != "x" {
+		t.Fatalf("Read(1) = %v, %v, %v", rec, ok, err)
+	}
+	if _, ok, _ := x.Read(3e6); !ok {
+		t.Fatal("float ID 3e6 not found")
+	}
+	if _, ok, _ := x.Read(2); ok {
+		t.Fatal("unscored line should not be indexed")
 	}
-}
-
-func TestLLMLimiter_WaitEventuallyReturns(t *testing.T) {
-	limiter := newLLMLimiter(1000)
-	defer limiter.Close()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-	defer cancel()
 
-	if err := limiter.Wait(ctx); err != nil {
-		t.Fatalf("expected nil, got %v", err)
+	missing, err := openResultIndex(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || len(missing.refs) != 0 || missing.Close() != nil {
+		t.Fatalf("missing file: %v, %v", missing, err)
 	}
 }
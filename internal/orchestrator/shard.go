@@ -0,0 +1,327 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/types"
+)
+
+// Shard selects a disjoint subset of the records, so that several machines
+// with their own API keys can analyse the same pending_audits.jsonl. Index
+// is 1-based; the zero Shard covers every record.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses "i/n" (for example "2/5"). An empty spec is the zero
+// Shard.
+func ParseShard(spec string) (Shard, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return Shard{}, nil
+	}
+	i, n, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard %q, want i/n", spec)
+	}
+	idx, err1 := strconv.Atoi(strings.TrimSpace(i))
+	cnt, err2 := strconv.Atoi(strings.TrimSpace(n))
+	if err1 != nil || err2 != nil || cnt < 1 || idx < 1 || idx > cnt {
+		return Shard{}, fmt.Errorf("invalid shard %q, want i/n with 1 <= i <= n", spec)
+	}
+	return Shard{Index: idx, Count: cnt}, nil
+}
+
+// Enabled reports whether s splits the records at all.
+func (s Shard) Enabled() bool {
+	return s.Count > 1
+}
+
+func (s Shard) String() string {
+	if !s.Enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Contains reports whether the record id belongs to s: FNV-1a of the ID
+// modulo Count, so every machine agrees without coordination.
+func (s Shard) Contains(id any) bool {
+	if !s.Enabled() {
+		return true
+	}
+	return shardOf(id, s.Count) == s.Index
+}
+
+// shardOf returns the 1-based shard of id among count shards.
+func shardOf(id any, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(types.RecordKey(id)))
+	return int(h.Sum32()%uint32(count)) + 1
+}
+
+// ResultsPath is the results file of the shard; shards write their own so
+// they can share a state dir. The zero Shard uses the regular file.
+func (s Shard) ResultsPath(cfg *config.RootConfig) string {
+	return s.path(cfg.PendingAuditsResultsPath())
+}
+
+// FailuresPath is the dead-letter file of the shard.
+func (s Shard) FailuresPath(cfg *config.RootConfig) string {
+	return s.path(cfg.PendingAuditsFailuresPath())
+}
+
+func (s Shard) path(p string) string {
+	if !s.Enabled() {
+		return p
+	}
+	ext := filepath.Ext(p)
+	return fmt.Sprintf("%s.shard-%d-of-%d%s", strings.TrimSuffix(p, ext), s.Index, s.Count, ext)
+}
+
+// MergeReport describes a MergeShardResults run.
+type MergeReport struct {
+	Files   int
+	Lines   int
+	Written int
+	// Overlaps are IDs with results in more than one file; the latest
+	// generated_at wins.
+	Overlaps []string
+	// Gaps are pending IDs without a result in any file. GapsByShard
+	// counts them per expected shard when the shard count is known.
+	Gaps        []string
+	GapsByShard map[string]int
+	// Misplaced are results stamped with a shard their ID does not hash to,
+	// which means the shards were run with different counts.
+	Misplaced []string
+	// Unknown are results for IDs not in the pending file.
+	Unknown []string
+}
+
+// mergeEntry locates the result kept for an ID: its line in inputs[file].
+type mergeEntry struct {
+	file        int
+	ref         lineRef
+	generatedAt string
+}
+
+// MergeShardResults combines shard result files into out, ordered as in
+// pendingPath, and reports overlaps, gaps and mismatched shards. Result
+// lines are copied as written, read back from the inputs only when out is
+// written. out is replaced atomically.
+func MergeShardResults(pendingPath, out string, inputs []string) (*MergeReport, error) {
+	rep := &MergeReport{Files: len(inputs), GapsByShard: map[string]int{}}
+	best := map[string]mergeEntry{}
+	seenIn := map[string]map[string]bool{}
+	shardCount := 0
+	for i, in := range inputs {
+		err := scanJSONLRefs(in, func(line []byte, ref lineRef) error {
+			var hdr struct {
+				ID          any    `json:"id"`
+				GeneratedAt string `json:"generated_at"`
+				Shard       string `json:"shard"`
+			}
+			if decodeLine(line, &hdr) != nil || hdr.ID == nil {
+				return nil
+			}
+			rep.Lines++
+			id := types.RecordKey(hdr.ID)
+			if seenIn[id] == nil {
+				seenIn[id] = map[string]bool{}
+			}
+			seenIn[id][in] = true
+			if s, err := ParseShard(hdr.Shard); err == nil && s.Enabled() {
+				shardCount = s.Count
+				if !s.Contains(hdr.ID) {
+					rep.Misplaced = append(rep.Misplaced, id)
+				}
+			}
+			if prev, ok := best[id]; !ok || hdr.GeneratedAt >= prev.generatedAt {
+				best[id] = mergeEntry{file: i, ref: ref, generatedAt: hdr.GeneratedAt}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", in, err)
+		}
+	}
+	for id, files := range seenIn {
+		if len(files) > 1 {
+			rep.Overlaps = append(rep.Overlaps, id)
+		}
+	}
+
+	var order []string
+	inPending := map[string]bool{}
+	err := scanJSONL(pendingPath, func(line []byte) error {
+		var hdr struct {
+			ID any `json:"id"`
+		}
+		if decodeLine(line, &hdr) != nil || hdr.ID == nil {
+			return nil
+		}
+		id := types.RecordKey(hdr.ID)
+		if inPending[id] {
+			return nil
+		}
+		inPending[id] = true
+		order = append(order, id)
+		if _, ok := best[id]; !ok {
+			rep.Gaps = append(rep.Gaps, id)
+			if shardCount > 1 {
+				rep.GapsByShard[fmt.Sprintf("%d/%d", shardOf(hdr.ID, shardCount), shardCount)]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", pendingPath, err)
+	}
+	var unknown []string
+	for id := range best {
+		if !inPending[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	sort.Strings(unknown)
+	rep.Unknown = unknown
+	sort.Strings(rep.Overlaps)
+	sort.Strings(rep.Misplaced)
+
+	files := make([]*os.File, len(inputs))
+	for i, in := range inputs {
+		f, err := os.Open(in)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return nil, err
+	}
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	// Pending order first, then results for IDs the pending file lacks.
+	for _, id := range append(order, unknown...) {
+		e, ok := best[id]
+		if !ok {
+			continue
+		}
+		line, err := readLine(files[e.file], e.ref)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("read %s: %w", inputs[e.file], err)
+		}
+		w.Write(bytes.TrimSpace(line))
+		w.WriteByte('\n')
+		rep.Written++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// decodeLine decodes a JSON line keeping numbers as json.Number.
+func decodeLine(line []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// scanJSONL calls fn for every non-empty line of path.
+func scanJSONL(path string, fn func([]byte) error) error {
+	return scanJSONLRefs(path, func(line []byte, _ lineRef) error { return fn(line) })
+}
+
+// scanJSONLRefs calls fn for every non-empty line of path with where the
+// line is in the file, so that it can be read back later with readLine.
+func scanJSONLRefs(path string, fn func([]byte, lineRef) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var off int64
+	var ref lineRef
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			ref = lineRef{off: off, n: len(token)}
+		}
+		off += int64(advance)
+		return advance, token, err
+	})
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line, ref); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// readLine reads back the line at ref of f.
+func readLine(f *os.File, ref lineRef) ([]byte, error) {
+	buf := make([]byte, ref.n)
+	if _, err := f.ReadAt(buf, ref.off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// CountShards returns how many distinct records of pendingPath fall into
+// each of count shards; index 0 is shard 1.
+func CountShards(pendingPath string, count int) ([]int, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("invalid shard count %d", count)
+	}
+	counts := make([]int, count)
+	seen := map[string]bool{}
+	err := scanJSONL(pendingPath, func(line []byte) error {
+		var hdr struct {
+			ID any `json:"id"`
+		}
+		if decodeLine(line, &hdr) != nil || hdr.ID == nil {
+			return nil
+		}
+		if id := types.RecordKey(hdr.ID); !seen[id] {
+			seen[id] = true
+			counts[shardOf(hdr.ID, count)-1]++
+		}
+		return nil
+	})
+	return counts, err
+}
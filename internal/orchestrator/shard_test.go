@@ -0,0 +1,113 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"audit-workflow/internal/types"
+)
+
+func TestParseShard(t *testing.T) {
+	s, err := ParseShard(" 2/5 ")
+	if err != nil || s != (Shard{Index: 2, Count: 5}) || s.String() != "2/5" {
+		t.Fatalf("ParseShard(2/5) = %+v, %v", s, err)
+	}
+	if s, err := ParseShard(""); err != nil || s.Enabled() || !s.Contains(7) {
+		t.Fatalf("empty shard should cover everything: %+v, %v", s, err)
+	}
+	for _, bad := range []string{"2", "0/3", "4/3", "a/b", "1/0"} {
+		if _, err := ParseShard(bad); err == nil {
+			t.Errorf("ParseShard(%q) should fail", bad)
+		}
+	}
+}
+
+func TestShard_DisjointCover(t *testing.T) {
+	const n = 4
+	perShard := make([]int, n)
+	for id := 1; id <= 400; id++ {
+		owners := 0
+		for i := 1; i <= n; i++ {
+			s := Shard{Index: i, Count: n}
+			// IDs decoded from JSON are float64; fetch produces ints.
+			if s.Contains(float64(id)) != s.Contains(id) || s.Contains(json.Number(types.RecordKey(id))) != s.Contains(id) {
+				t.Fatalf("ID %d hashes differently by type", id)
+			}
+			if s.Contains(id) {
+				owners++
+				perShard[i-1]++
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("ID %d is in %d shards", id, owners)
+		}
+	}
+	for i, c := range perShard {
+		if c == 0 {
+			t.Fatalf("shard %d/%d is empty: %v", i+1, n, perShard)
+		}
+	}
+}
+
+func TestRunRiskAnalysis_ShardsAndMerge(t *testing.T) {
+	srv := httptest.NewServer(&fakeBackend{})
+	defer srv.Close()
+	cfg := newTestConfig(t, srv)
+	ids := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	writePending(t, cfg, ids...)
+
+	shards := []Shard{{Index: 1, Count: 2}, {Index: 2, Count: 2}}
+	for _, s := range shards {
+		if err := RunRiskAnalysisWithOptions(context.Background(), cfg, RiskAnalysisOptions{Shard: s}); err != nil {
+			t.Fatalf("run shard %s: %v", s, err)
+		}
+		for _, id := range readJSONLIDs(t, s.ResultsPath(cfg)) {
+			if !s.Contains(id) {
+				t.Fatalf("shard %s analysed foreign ID %s", s, id)
+			}
+		}
+	}
+	if _, err := os.Stat(cfg.PendingAuditsResultsPath()); !os.IsNotExist(err) {
+		t.Fatalf("sharded runs must not write the unsharded results file: %v", err)
+	}
+
+	// Overlap: shard 1's file again under another name, as if copied twice.
+	dup := cfg.PendingAuditsResultsPath() + ".copy"
+	b, _ := os.ReadFile(shards[0].ResultsPath(cfg))
+	if err := os.WriteFile(dup, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := cfg.PendingAuditsResultsPath()
+	rep, err := MergeShardResults(cfg.PendingAuditsPath(), out, []string{shards[0].ResultsPath(cfg), shards[1].ResultsPath(cfg), dup})
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if rep.Written != len(ids) || len(rep.Gaps) != 0 || len(rep.Misplaced) != 0 || len(rep.Unknown) != 0 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if len(rep.Overlaps) != len(readJSONLIDs(t, dup)) {
+		t.Fatalf("overlaps = %v, want every ID of shard 1", rep.Overlaps)
+	}
+	want := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	if got := jsonlIDsInOrder(t, out); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged IDs = %v, want pending order %v", got, want)
+	}
+
+	// Gap: without shard 2 its IDs are reported missing, per shard.
+	rep, err = MergeShardResults(cfg.PendingAuditsPath(), out+".partial", []string{shards[0].ResultsPath(cfg)})
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	missing := len(ids) - len(readJSONLIDs(t, shards[0].ResultsPath(cfg)))
+	if len(rep.Gaps) != missing || rep.GapsByShard["2/2"] != missing {
+		t.Fatalf("gaps = %v by shard %v, want %d in 2/2", rep.Gaps, rep.GapsByShard, missing)
+	}
+	counts, err := CountShards(cfg.PendingAuditsPath(), 2)
+	if err != nil || counts[1] != missing || counts[0]+counts[1] != len(ids) {
+		t.Fatalf("CountShards = %v, %v", counts, err)
+	}
+}
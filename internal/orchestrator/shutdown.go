@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"audit-workflow/internal/logging"
+	"audit-workflow/internal/stop"
+)
+
+// ErrInterrupted is returned by a stage that stopped early because of a
+// shutdown signal. Its checkpoints are complete; rerun with Resume.
+var ErrInterrupted = errors.New("interrupted by shutdown signal")
+
+// Shutdown turns SIGINT/SIGTERM into a two-step stop. On the first signal
+// Stopping is closed: stages stop starting new records. In-flight records
+// get the grace period to finish; after it, or on a second signal, Context
+// is cancelled and remaining work is abandoned.
+type Shutdown struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping chan struct{}
+	sigCh    chan os.Signal
+	done     chan struct{}
+}
+
+func NewShutdown(parent context.Context, grace time.Duration) *Shutdown {
+	ctx, cancel := context.WithCancel(parent)
+	s := &Shutdown{
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+		sigCh:    make(chan os.Signal, 2),
+		done:     make(chan struct{}),
+	}
+	signal.Notify(s.sigCh, os.Interrupt, syscall.SIGTERM)
+	go s.watch(grace)
+	return s
+}
+
+func (s *Shutdown) watch(grace time.Duration) {
+	select {
+	case <-s.done:
+		return
+	case <-s.ctx.Done():
+		return
+	case sig := <-s.sigCh:
+		logging.Stage("shutdown").Warn("signal received: no new records will be started, waiting for in-flight ones (signal again to abort)", "signal", sig.String(), "grace", grace)
+		close(s.stopping)
+	}
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-s.done:
+	case <-s.ctx.Done():
+	case <-t.C:
+		logging.Stage("shutdown").Warn("grace period over, abandoning in-flight records")
+		s.cancel()
+	case <-s.sigCh:
+		logging.Stage("shutdown").Warn("second signal, abandoning in-flight records")
+		s.cancel()
+	}
+}
+
+// Context is cancelled when in-flight work must be abandoned.
+func (s *Shutdown) Context() context.Context { return s.ctx }
+
+// Stopping is closed on the first signal.
+func (s *Shutdown) Stopping() <-chan struct{} { return s.stopping }
+
+// Interrupted reports whether a signal has been received.
+func (s *Shutdown) Interrupted() bool { return stop.Requested(s.stopping) }
+
+// Close releases the signal handler.
+func (s *Shutdown) Close() {
+	signal.Stop(s.sigCh)
+	close(s.done)
+	s.cancel()
+}
+
+// contextUntilStop returns a context that is also cancelled when stop closes.
+func contextUntilStop(ctx context.Context, stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if stop != nil {
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
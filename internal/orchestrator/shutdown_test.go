@@ -0,0 +1,60 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func sendInterrupt(t *testing.T) {
+	t.Helper()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s not closed", what)
+	}
+}
+
+func TestShutdown_SecondSignalCancels(t *testing.T) {
+	s := NewShutdown(context.Background(), time.Minute)
+	defer s.Close()
+
+	sendInterrupt(t)
+	waitClosed(t, s.Stopping(), "Stopping")
+	if !s.Interrupted() {
+		t.Fatal("Interrupted = false after the first signal")
+	}
+	if err := s.Context().Err(); err != nil {
+		t.Fatalf("first signal cancelled the context: %v", err)
+	}
+
+	sendInterrupt(t)
+	waitClosed(t, s.Context().Done(), "Context")
+}
+
+func TestShutdown_GraceTimeoutCancels(t *testing.T) {
+	s := NewShutdown(context.Background(), 50*time.Millisecond)
+	defer s.Close()
+
+	sendInterrupt(t)
+	waitClosed(t, s.Stopping(), "Stopping")
+	waitClosed(t, s.Context().Done(), "Context")
+}
+
+func TestShutdown_CloseWithoutSignal(t *testing.T) {
+	s := NewShutdown(context.Background(), time.Minute)
+	s.Close()
+	waitClosed(t, s.Context().Done(), "Context")
+	if s.Interrupted() {
+		t.Fatal("Interrupted = true without a signal")
+	}
+}
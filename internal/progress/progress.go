@@ -0,0 +1,337 @@
+// Package progress reports stage progress: a single redrawn status line on a
+// terminal, periodic JSON events otherwise.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/metrics"
+)
+
+const (
+	ModeAuto = "auto"
+	ModeTTY  = "tty"
+	ModeJSON = "json"
+	ModeOff  = "off"
+)
+
+// redrawInterval is how often the terminal status line is refreshed.
+const redrawInterval = 500 * time.Millisecond
+
+// Reporter counts the records of one stage. All methods are safe for
+// concurrent use and on a nil Reporter.
+type Reporter struct {
+	stage    string
+	out      io.Writer
+	tty      bool
+	interval time.Duration
+
+	mu                    sync.Mutex
+	total                 int
+	success, failed, skip int
+	start                 time.Time
+	concurrency           func() int
+	stop, done            chan struct{}
+	stopOnce              sync.Once
+}
+
+// Snapshot is one progress event; it is also the JSON event format.
+type Snapshot struct {
+	Event       string  `json:"event"`
+	Stage       string  `json:"stage"`
+	Done        int     `json:"done"`
+	Total       int     `json:"total,omitempty"`
+	Success     int     `json:"success"`
+	Failed      int     `json:"failed"`
+	Skipped     int     `json:"skipped"`
+	PerMinute   float64 `json:"per_minute"`
+	ETASeconds  float64 `json:"eta_s,omitempty"`
+	Concurrency int     `json:"concurrency,omitempty"`
+	Final       bool    `json:"final,omitempty"`
+	Time        string  `json:"ts"`
+}
+
+// Start begins reporting stage to stderr according to cfg.Progress. total
+// may be 0 when unknown and raised later with AddTotal. With progress off
+// nothing is printed, but counts still feed the records metric.
+func Start(cfg *config.RootConfig, stage string, total int) *Reporter {
+	mode, interval := ModeAuto, 10*time.Second
+	if cfg != nil {
+		if cfg.Progress.Mode != "" {
+			mode = cfg.Progress.Mode
+		}
+		if cfg.Progress.IntervalS > 0 {
+			interval = time.Duration(cfg.Progress.IntervalS * float64(time.Second))
+		}
+	}
+	if mode == ModeAuto {
+		mode = ModeJSON
+		if isTerminal(os.Stderr) {
+			mode = ModeTTY
+		}
+	}
+	switch mode {
+	case ModeTTY:
+		return start(stage, total, os.Stderr, true, 0)
+	case ModeJSON:
+		return start(stage, total, os.Stderr, false, interval)
+	default:
+		return start(stage, total, nil, false, 0)
+	}
+}
+
+func start(stage string, total int, out io.Writer, tty bool, interval time.Duration) *Reporter {
+	r := &Reporter{
+		stage:    stage,
+		out:      out,
+		tty:      tty,
+		interval: interval,
+		total:    total,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch {
+	case out == nil:
+	case tty:
+		board.add(r)
+	default:
+		go r.loop()
+	}
+	return r
+}
+
+// loop emits the JSON events of r.
+func (r *Reporter) loop() {
+	defer close(r.done)
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			r.render(r.Snapshot(), true)
+			return
+		case <-t.C:
+			r.render(r.Snapshot(), false)
+		}
+	}
+}
+
+// SetConcurrency sets the source of the concurrency shown.
+func (r *Reporter) SetConcurrency(f func() int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.concurrency = f
+	r.mu.Unlock()
+}
+
+// AddTotal raises the expected record count.
+func (r *Reporter) AddTotal(n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.total += n
+	r.mu.Unlock()
+}
+
+func (r *Reporter) Success() { r.add("success", func() { r.success++ }) }
+func (r *Reporter) Failure() { r.add("failed", func() { r.failed++ }) }
+func (r *Reporter) Skip()    { r.add("skipped", func() { r.skip++ }) }
+
+func (r *Reporter) add(outcome string, inc func()) {
+	if r == nil {
+		return
+	}
+	metrics.Records.Inc(r.stage, outcome)
+	r.mu.Lock()
+	inc()
+	r.mu.Unlock()
+}
+
+// Snapshot returns the current counters and rates.
+func (r *Reporter) Snapshot() Snapshot {
+	if r == nil {
+		return Snapshot{}
+	}
+	r.mu.Lock()
+	s := Snapshot{
+		Event:   "progress",
+		Stage:   r.stage,
+		Done:    r.success + r.failed + r.skip,
+		Total:   r.total,
+		Success: r.success,
+		Failed:  r.failed,
+		Skipped: r.skip,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+	}
+	conc := r.concurrency
+	elapsed := time.Since(r.start)
+	r.mu.Unlock()
+
+	if conc != nil {
+		s.Concurrency = conc()
+	}
+	if elapsed > 0 {
+		s.PerMinute = float64(s.Done) / elapsed.Minutes()
+	}
+	if s.PerMinute > 0 && s.Total > s.Done {
+		s.ETASeconds = float64(s.Total-s.Done) / s.PerMinute * 60
+	}
+	return s
+}
+
+// Stop prints the final state and stops reporting. It may be called more
+// than once.
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() {
+		if r.out == nil {
+			return
+		}
+		if r.tty {
+			board.remove(r)
+			return
+		}
+		close(r.stop)
+		<-r.done
+	})
+}
+
+func (r *Reporter) render(s Snapshot, final bool) {
+	s.Final = final
+	b, _ := json.Marshal(s)
+	fmt.Fprintf(r.out, "%s\n", b)
+}
+
+// board draws the status lines of all terminal reporters on one line, so
+// that the concurrent stages of the streaming pipeline share it.
+var board = &statusBoard{}
+
+type statusBoard struct {
+	mu        sync.Mutex
+	reporters []*Reporter
+	stop      chan struct{}
+	done      chan struct{}
+	// out and line are where the status line is drawn and what it shows;
+	// line is empty when nothing is drawn.
+	out  io.Writer
+	line string
+}
+
+func (b *statusBoard) add(r *Reporter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reporters = append(b.reporters, r)
+	if len(b.reporters) == 1 {
+		b.stop, b.done = make(chan struct{}), make(chan struct{})
+		b.out = r.out
+		go b.loop(r.out, b.stop, b.done)
+	}
+}
+
+// remove prints the final line of r and stops drawing when it was the last.
+func (b *statusBoard) remove(r *Reporter) {
+	b.mu.Lock()
+	for i, x := range b.reporters {
+		if x == r {
+			b.reporters = append(b.reporters[:i], b.reporters[i+1:]...)
+			break
+		}
+	}
+	fmt.Fprintf(r.out, "\r\033[K%s\n", FormatLine(r.Snapshot()))
+	b.line = ""
+	var stop, done chan struct{}
+	if len(b.reporters) == 0 {
+		stop, done = b.stop, b.done
+	}
+	b.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (b *statusBoard) loop(out io.Writer, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(redrawInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			b.mu.Lock()
+			line := ""
+			for i, r := range b.reporters {
+				if i > 0 {
+					line += "  "
+				}
+				line += FormatLine(r.Snapshot())
+			}
+			if line != "" {
+				fmt.Fprintf(out, "\r\033[K%s", line)
+			}
+			b.line = line
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Writer returns w wrapped so that every write first clears the terminal
+// status line and then redraws it, which keeps log records written to the
+// same terminal from running into the line.
+func Writer(w io.Writer) io.Writer {
+	return clearingWriter{w}
+}
+
+type clearingWriter struct {
+	w io.Writer
+}
+
+func (c clearingWriter) Write(p []byte) (int, error) {
+	board.mu.Lock()
+	defer board.mu.Unlock()
+	if board.line == "" {
+		return c.w.Write(p)
+	}
+	fmt.Fprint(board.out, "\r\033[K")
+	n, err := c.w.Write(p)
+	fmt.Fprint(board.out, board.line)
+	return n, err
+}
+
+// FormatLine renders s as the terminal status line.
+func FormatLine(s Snapshot) string {
+	line := fmt.Sprintf("[%s] %d", s.Stage, s.Done)
+	if s.Total > 0 {
+		line += fmt.Sprintf("/%d", s.Total)
+	}
+	line += fmt.Sprintf(" | ok %d fail %d", s.Success, s.Failed)
+	if s.Skipped > 0 {
+		line += fmt.Sprintf(" skip %d", s.Skipped)
+	}
+	line += fmt.Sprintf(" | %.1f/min", s.PerMinute)
+	if s.ETASeconds > 0 {
+		line += " | ETA " + (time.Duration(s.ETASeconds) * time.Second).String()
+	}
+	if s.Concurrency > 0 {
+		line += fmt.Sprintf(" | c=%d", s.Concurrency)
+	}
+	return line
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,91 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReporter_JSONEvents(t *testing.T) {
+	var buf bytes.Buffer
+	r := start("ai", 4, &buf, false, 10*time.Millisecond)
+	r.SetConcurrency(func() int { return 3 })
+	r.Success()
+	r.Failure()
+	r.Skip()
+	time.Sleep(30 * time.Millisecond)
+	r.Stop()
+	r.Stop()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected periodic and final events, got %q", buf.String())
+	}
+	var last Snapshot
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if !last.Final || last.Stage != "ai" || last.Done != 3 || last.Total != 4 || last.Success != 1 || last.Failed != 1 || last.Concurrency != 3 {
+		t.Fatalf("unexpected final event: %+v", last)
+	}
+	if last.PerMinute <= 0 || last.ETASeconds <= 0 {
+		t.Fatalf("expected rate and ETA: %+v", last)
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	got := FormatLine(Snapshot{Stage: "submit", Done: 30, Total: 90, Success: 28, Failed: 2, PerMinute: 60, ETASeconds: 60, Concurrency: 4})
+	want := "[submit] 30/90 | ok 28 fail 2 | 60.0/min | ETA 1m0s | c=4"
+	if got != want {
+		t.Fatalf("FormatLine = %q, want %q", got, want)
+	}
+}
+
+func TestReporter_Nil(t *testing.T) {
+	var r *Reporter
+	r.AddTotal(1)
+	r.Success()
+	r.SetConcurrency(nil)
+	r.Stop()
+	if s := r.Snapshot(); s.Done != 0 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+}
+
+func TestWriter_ClearsStatusLine(t *testing.T) {
+	var term bytes.Buffer
+	w := Writer(&term)
+	if _, err := w.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := start("ai", 2, &term, true, 0)
+	r.Success()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		board.mu.Lock()
+		drawn := board.line != ""
+		board.mu.Unlock()
+		if drawn {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("status line not drawn")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := w.Write([]byte("log line\n")); err != nil {
+		t.Fatal(err)
+	}
+	r.Stop()
+
+	out := term.String()
+	if !strings.HasPrefix(out, "before\n\r\033[K[ai]") {
+		t.Fatalf("a write without a status line should pass through: %q", out)
+	}
+	if want := "\r\033[Klog line\n[ai] 1/2"; !strings.Contains(out, want) {
+		t.Fatalf("log line not written on a cleared line and followed by the status line: %q", out)
+	}
+}
@@ -0,0 +1,189 @@
+// Package review holds AI verdicts for human review before submission: the
+// queue of held records, the reviewers' decisions and the interactive
+// session that produces them.
+package review
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/components/parser"
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/types"
+)
+
+// Reasons a record is held for review.
+const (
+	ReasonHighScore   = "high_score"
+	ReasonNoTechnique = "no_technique"
+	ReasonTextScore   = "text_score"
+	ReasonJudge       = "judge_disagreement"
+	// ReasonPolicy is followed by ":<policy id>" for records a policy sent
+	// to manual review; they have no model verdict.
+	ReasonPolicy = "policy"
+)
+
+// FieldJudge holds the judge's verdict in result data: its risk_score and
+// technique_name.
+const FieldJudge = "judge"
+
+// Decisions a reviewer can make.
+const (
+	Accept = "accept"
+	Edit   = "edit"
+	Reject = "reject"
+)
+
+// Item is a record Submit held for review.
+type Item struct {
+	ID       any      `json:"id"`
+	QueuedAt string   `json:"queued_at"`
+	Reasons  []string `json:"reasons"`
+	// Hash identifies the verdict that was held; see Hash.
+	Hash string         `json:"hash"`
+	Data map[string]any `json:"data"`
+}
+
+// Decision is a reviewer's verdict on an Item. Data is the approved data of
+// an edited record; accepted records are submitted as queued.
+type Decision struct {
+	ID         any            `json:"id"`
+	Decision   string         `json:"decision"`
+	Hash       string         `json:"hash"`
+	ReviewedAt string         `json:"reviewed_at"`
+	Reviewer   string         `json:"reviewer,omitempty"`
+	Note       string         `json:"note,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+}
+
+// Reasons returns why data should be held for review under cfg, or nil.
+func Reasons(cfg config.ReviewConfig, data map[string]any) []string {
+	var reasons []string
+	score, ok := parser.NormalizeRiskScore(data["risk_score"])
+	if cfg.MinScore > 0 && ok && score >= cfg.MinScore {
+		reasons = append(reasons, ReasonHighScore)
+	}
+	if cfg.EmptyTechnique && str(data["technique_name"]) == "" {
+		reasons = append(reasons, ReasonNoTechnique)
+	}
+	if cfg.TextScore && str(data["score_source"]) == "text" {
+		reasons = append(reasons, ReasonTextScore)
+	}
+	if cfg.JudgeDisagreement && judgeDisagrees(data, max(cfg.JudgeScoreDelta, 1)) {
+		reasons = append(reasons, ReasonJudge)
+	}
+	return reasons
+}
+
+// judgeDisagrees reports whether the judge verdict in data differs from the
+// model's: scores delta or more apart, or another technique. A missing
+// judge verdict or field agrees.
+func judgeDisagrees(data map[string]any, delta int) bool {
+	judge, ok := data[FieldJudge].(map[string]any)
+	if !ok {
+		return false
+	}
+	score, ok1 := parser.NormalizeRiskScore(data["risk_score"])
+	other, ok2 := parser.NormalizeRiskScore(judge["risk_score"])
+	if ok1 && ok2 && (score-other >= delta || other-score >= delta) {
+		return true
+	}
+	tech, otherTech := str(data["technique_name"]), str(judge["technique_name"])
+	return tech != "" && otherTech != "" && tech != otherTech
+}
+
+// Key is the ID under which queue items and decisions are matched.
+func Key(id any) string {
+	return types.RecordKey(id)
+}
+
+// Hash identifies the verdict in data, everything but the raw record detail,
+// so that a decision applies only to the verdict the reviewer saw: when the
+// AI stage rewrites the record, the new verdict is queued again.
+func Hash(data map[string]any) string {
+	v := make(map[string]any, len(data))
+	for k, val := range data {
+		if k != "_raw" {
+			v[k] = val
+		}
+	}
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewItem returns the queue item holding data for reasons.
+func NewItem(id any, data map[string]any, reasons []string) Item {
+	return Item{ID: id, QueuedAt: utcISO(), Reasons: reasons, Hash: Hash(data), Data: data}
+}
+
+// LoadQueue returns the latest item of every ID in path, in the order the
+// IDs were first queued. A missing file is an empty queue.
+func LoadQueue(path string) ([]Item, error) {
+	var items []Item
+	pos := map[string]int{}
+	err := scan(path, func(line []byte) {
+		var it Item
+		if json.Unmarshal(line, &it) != nil || Key(it.ID) == "" {
+			return
+		}
+		if i, ok := pos[Key(it.ID)]; ok {
+			items[i] = it
+			return
+		}
+		pos[Key(it.ID)] = len(items)
+		items = append(items, it)
+	})
+	return items, err
+}
+
+// LoadDecisions returns the latest decision of every ID in path. A missing
+// file has no decisions.
+func LoadDecisions(path string) (map[string]Decision, error) {
+	decisions := map[string]Decision{}
+	err := scan(path, func(line []byte) {
+		var d Decision
+		if json.Unmarshal(line, &d) != nil || Key(d.ID) == "" {
+			return
+		}
+		switch d.Decision {
+		case Accept, Edit, Reject:
+			decisions[Key(d.ID)] = d
+		}
+	})
+	return decisions, err
+}
+
+func scan(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			fn(line)
+		}
+	}
+	return sc.Err()
+}
+
+func str(v any) string {
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
+
+func utcISO() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,153 @@
+package review
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"audit-workflow/internal/config"
+)
+
+func TestReasons(t *testing.T) {
+	cfg := config.ReviewConfig{Enabled: true, MinScore: 8, EmptyTechnique: true, TextScore: true}
+	cases := []struct {
+		data map[string]any
+		want []string
+	}{
+		{map[string]any{"risk_score": 5.0, "technique_name": "T", "score_source": "json"}, nil},
+		{map[string]any{"risk_score": 9.0, "technique_name": "T"}, []string{ReasonHighScore}},
+		{map[string]any{"risk_score": "8", "score_source": "text"}, []string{ReasonHighScore, ReasonNoTechnique, ReasonTextScore}},
+	}
+	for _, c := range cases {
+		if got := Reasons(cfg, c.data); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Reasons(%v) = %v, want %v", c.data, got, c.want)
+		}
+	}
+	if got := Reasons(config.ReviewConfig{}, cases[2].data); got != nil {
+		t.Errorf("no condition enabled should hold nothing: %v", got)
+	}
+}
+
+func TestReasons_JudgeDisagreement(t *testing.T) {
+	cfg := config.ReviewConfig{Enabled: true, JudgeDisagreement: true, JudgeScoreDelta: 2}
+	judged := func(score any, tech string, judge map[string]any) map[string]any {
+		return map[string]any{"risk_score": score, "technique_name": tech, FieldJudge: judge}
+	}
+	cases := []struct {
+		data map[string]any
+		want []string
+	}{
+		{map[string]any{"risk_score": 5.0, "technique_name": "T"}, nil},
+		{judged(5.0, "T", map[string]any{"risk_score": 6.0, "technique_name": "T"}), nil},
+		{judged(5.0, "T", map[string]any{"risk_score": 3.0}), []string{ReasonJudge}},
+		{judged(5.0, "T", map[string]any{"risk_score": 5.0, "technique_name": "U"}), []string{ReasonJudge}},
+		{judged(5.0, "", map[string]any{"risk_score": 5.0, "technique_name": "U"}), nil},
+	}
+	for _, c := range cases {
+		if got := Reasons(cfg, c.data); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Reasons(%v) = %v, want %v", c.data, got, c.want)
+		}
+	}
+}
+
+func TestHash_IgnoresRawAndNumberType(t *testing.T) {
+	a := map[string]any{"risk_score": 7, "tactic_name": "初始访问", "_raw": map[string]any{"id": 1}}
+	b := map[string]any{"risk_score": 7.0, "tactic_name": "初始访问", "_raw": map[string]any{"id": 1, "x": "y"}}
+	if Hash(a) != Hash(b) {
+		t.Fatal("hash should depend on the verdict only")
+	}
+	b["risk_score"] = 8
+	if Hash(a) == Hash(b) {
+		t.Fatal("a changed verdict should change the hash")
+	}
+}
+
+func TestLoadQueue_LatestPerID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "q.jsonl")
+	var lines []string
+	for _, it := range []Item{
+		NewItem(1, map[string]any{"risk_score": 9}, []string{ReasonHighScore}),
+		NewItem(2, map[string]any{"risk_score": 3}, []string{ReasonTextScore}),
+		NewItem(1, map[string]any{"risk_score": 10}, []string{ReasonHighScore}),
+	} {
+		b, _ := json.Marshal(it)
+		lines = append(lines, string(b))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	items, err := LoadQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || Key(items[0].ID) != "1" || items[0].Data["risk_score"] != 10.0 || Key(items[1].ID) != "2" {
+		t.Fatalf("unexpected queue: %+v", items)
+	}
+	if items, err := LoadQueue(filepath.Join(t.TempDir(), "missing")); err != nil || len(items) != 0 {
+		t.Fatalf("missing queue = %v, %v", items, err)
+	}
+}
+
+func TestSession_Run(t *testing.T) {
+	items := []Item{
+		NewItem(1, map[string]any{"risk_score": 9.0, "tactic_name": "初始访问", "technique_name": ""}, []string{ReasonHighScore}),
+		NewItem(2, map[string]any{"risk_score": 4.0, "tactic_name": "执行"}, []string{ReasonTextScore}),
+		NewItem(3, map[string]any{"risk_score": 2.0}, []string{ReasonNoTechnique}),
+		NewItem(4, map[string]any{"risk_score": 8.0}, []string{ReasonHighScore}),
+		NewItem(5, map[string]any{"risk_score": 8.0}, []string{ReasonHighScore}),
+	}
+	input := strings.Join([]string{
+		"a",
+		// edit: bad score, then 6; new technique; clear the suggestion
+		"e", "11", "6", "", "利用面向公众的应用程序", "", "", "-", "y", "checked by hand",
+		"r", "false positive",
+		"s",
+		"q",
+	}, "\n") + "\n"
+	var out strings.Builder
+	var got []Decision
+	s := &Session{
+		In:       strings.NewReader(input),
+		Out:      &out,
+		Reviewer: "alice",
+		Context:  func(data map[string]any) string { return "ctx" },
+		Record:   func(d Decision) error { got = append(got, d); return nil },
+	}
+	st, err := s.Run(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != (SessionStats{Accepted: 1, Edited: 1, Rejected: 1, Skipped: 1, Quit: true}) {
+		t.Fatalf("stats = %+v", st)
+	}
+	if len(got) != 3 || got[0].Decision != Accept || got[1].Decision != Edit || got[2].Decision != Reject {
+		t.Fatalf("decisions = %+v", got)
+	}
+	if got[0].Hash != items[0].Hash || got[0].Reviewer != "alice" || got[0].ReviewedAt == "" {
+		t.Fatalf("accept = %+v", got[0])
+	}
+	edited := got[1].Data
+	if edited["risk_score"] != 6 || edited["technique_name"] != "利用面向公众的应用程序" || edited["tactic_name"] != "执行" || got[1].Note != "checked by hand" {
+		t.Fatalf("edit = %+v", got[1])
+	}
+	if items[1].Data["risk_score"] != 4.0 {
+		t.Fatal("editing must not change the queued item")
+	}
+	if got[2].Note != "false positive" {
+		t.Fatalf("reject = %+v", got[2])
+	}
+	if !strings.Contains(out.String(), "risk_score must be an integer from 1 to 10") {
+		t.Fatal("invalid score should be reported")
+	}
+}
+
+func TestSession_EndOfInputQuits(t *testing.T) {
+	s := &Session{In: strings.NewReader("a"), Out: &strings.Builder{}, Record: func(Decision) error { return nil }}
+	st, err := s.Run([]Item{NewItem(1, nil, nil), NewItem(2, nil, nil)})
+	if err != nil || st.Accepted != 1 || !st.Quit {
+		t.Fatalf("stats = %+v, %v", st, err)
+	}
+}
@@ -0,0 +1,213 @@
+package review
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// verdictFields are the result fields a reviewer sees and can edit, in order.
+var verdictFields = []string{
+	"risk_score",
+	"tactic_name",
+	"technique_name",
+	"sub_technique_name",
+	"eval_description",
+	"suggestion",
+}
+
+// Session walks a reviewer through queue items on In/Out: it shows each
+// record's context and proposed verdict and asks to accept, edit or reject
+// it. Every decision is passed to Record as soon as it is made, so a
+// session can be quit and resumed at any point.
+type Session struct {
+	In       io.Reader
+	Out      io.Writer
+	Reviewer string
+	// Context renders the record context shown above the verdict.
+	Context func(data map[string]any) string
+	// CheckATTCK, when set, validates edited ATT&CK names; a failing edit
+	// is saved only after confirmation.
+	CheckATTCK func(tactic, technique, sub string) error
+	// Record stores a decision.
+	Record func(Decision) error
+
+	in *bufio.Reader
+}
+
+// SessionStats counts the decisions of a session.
+type SessionStats struct {
+	Accepted, Edited, Rejected, Skipped int
+	// Quit is set when the reviewer stopped before the last item.
+	Quit bool
+}
+
+// errQuit ends a session at the reviewer's request or at the end of input.
+var errQuit = errors.New("quit")
+
+// Run reviews items in order.
+func (s *Session) Run(items []Item) (SessionStats, error) {
+	s.in = bufio.NewReader(s.In)
+	var st SessionStats
+	for i, it := range items {
+		fmt.Fprintf(s.Out, "\n=== [%d/%d] ID %s  reasons: %s  queued %s ===\n", i+1, len(items), Key(it.ID), strings.Join(it.Reasons, ", "), it.QueuedAt)
+		if s.Context != nil {
+			fmt.Fprintf(s.Out, "--- context ---\n%s\n", s.Context(it.Data))
+		}
+		fmt.Fprintln(s.Out, "--- proposed verdict ---")
+		s.printVerdict(it.Data)
+
+		d, err := s.decide(it)
+		if errors.Is(err, errQuit) {
+			st.Quit = true
+			return st, nil
+		}
+		if err != nil {
+			return st, err
+		}
+		if d == nil {
+			st.Skipped++
+			continue
+		}
+		if err := s.Record(*d); err != nil {
+			return st, err
+		}
+		switch d.Decision {
+		case Accept:
+			st.Accepted++
+		case Edit:
+			st.Edited++
+		case Reject:
+			st.Rejected++
+		}
+	}
+	return st, nil
+}
+
+// decide asks for the decision on it; a nil decision skips it.
+func (s *Session) decide(it Item) (*Decision, error) {
+	for {
+		ans, err := s.ask("[a]ccept / [e]dit / [r]eject / [s]kip / [q]uit > ")
+		if err != nil {
+			return nil, err
+		}
+		d := &Decision{ID: it.ID, Hash: it.Hash, Reviewer: s.Reviewer}
+		switch strings.ToLower(ans) {
+		case "a", "accept":
+			d.Decision = Accept
+		case "e", "edit":
+			data, err := s.edit(it.Data)
+			if err != nil {
+				return nil, err
+			}
+			if data == nil {
+				continue
+			}
+			d.Decision = Edit
+			d.Data = data
+			if d.Note, err = s.ask("note (optional): "); err != nil {
+				return nil, err
+			}
+		case "r", "reject":
+			d.Decision = Reject
+			if d.Note, err = s.ask("reason: "); err != nil {
+				return nil, err
+			}
+		case "s", "skip":
+			return nil, nil
+		case "q", "quit":
+			return nil, errQuit
+		default:
+			continue
+		}
+		d.ReviewedAt = utcISO()
+		return d, nil
+	}
+}
+
+// edit prompts for every verdict field of data and returns the edited copy,
+// or nil when the reviewer discarded the edit.
+func (s *Session) edit(data map[string]any) (map[string]any, error) {
+	fmt.Fprintln(s.Out, "Enter keeps a value, - clears it.")
+	out := maps.Clone(data)
+	for _, k := range verdictFields {
+		for {
+			ans, err := s.ask(fmt.Sprintf("%s [%s]: ", k, show(data[k])))
+			if err != nil {
+				return nil, err
+			}
+			if ans == "" {
+				break
+			}
+			if ans == "-" {
+				delete(out, k)
+				break
+			}
+			if k == "risk_score" {
+				n, err := strconv.Atoi(ans)
+				if err != nil || n < 1 || n > 10 {
+					fmt.Fprintln(s.Out, "risk_score must be an integer from 1 to 10")
+					continue
+				}
+				out[k] = n
+				break
+			}
+			out[k] = ans
+			break
+		}
+	}
+	if _, ok := out["risk_score"]; !ok {
+		fmt.Fprintln(s.Out, "[Warning] without risk_score the record will not be submitted")
+	}
+	fmt.Fprintln(s.Out, "--- edited verdict ---")
+	s.printVerdict(out)
+	if s.CheckATTCK != nil && str(out["tactic_name"]) != "" {
+		if err := s.CheckATTCK(str(out["tactic_name"]), str(out["technique_name"]), str(out["sub_technique_name"])); err != nil {
+			fmt.Fprintf(s.Out, "[Warning] %v\n", err)
+		}
+	}
+	ans, err := s.ask("save edit? [y/N] ")
+	if err != nil {
+		return nil, err
+	}
+	if a := strings.ToLower(ans); a != "y" && a != "yes" {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func (s *Session) printVerdict(data map[string]any) {
+	for _, k := range verdictFields {
+		fmt.Fprintf(s.Out, "%-19s %s\n", k+":", show(data[k]))
+	}
+	if src := str(data["score_source"]); src != "" {
+		fmt.Fprintf(s.Out, "%-19s %s\n", "score_source:", src)
+	}
+	if judge, ok := data[FieldJudge].(map[string]any); ok {
+		fmt.Fprintf(s.Out, "%-19s risk_score %s, technique_name %s\n", "judge:", show(judge["risk_score"]), show(judge["technique_name"]))
+	}
+}
+
+// ask prints prompt and reads one trimmed line; the end of input quits.
+func (s *Session) ask(prompt string) (string, error) {
+	fmt.Fprint(s.Out, prompt)
+	line, err := s.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		if errors.Is(err, io.EOF) {
+			return "", errQuit
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func show(v any) string {
+	if v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
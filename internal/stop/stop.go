@@ -0,0 +1,16 @@
+// Package stop is the cooperative stop signal the stages share: a channel
+// that is closed when they should stop starting new records.
+package stop
+
+// Requested reports whether ch is closed; a nil ch never is.
+func Requested(ch <-chan struct{}) bool {
+	if ch == nil {
+		return false
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/eino/callbacks"
+)
+
+var registerOnce sync.Once
+
+// registerEinoHandler adds a global Eino callback handler that opens a span
+// for every graph and node run: the workflow stages and the per-record
+// graph with its nodes. Only names are recorded, not inputs or outputs.
+// Eino global handlers must be added before graphs run, so Setup belongs at
+// startup; the handler is a no-op while no tracer is installed.
+func registerEinoHandler() {
+	registerOnce.Do(func() {
+		callbacks.AppendGlobalHandlers(EinoHandler())
+	})
+}
+
+// einoSpanKey holds the span opened by OnStart, which may be nil, so that
+// OnEnd never ends a span it did not open.
+type einoSpanKey struct{}
+
+// EinoHandler returns the callback handler registered by Setup, for passing
+// to a single run with compose.WithCallbacks instead.
+func EinoHandler() callbacks.Handler {
+	return callbacks.NewHandlerBuilder().
+		OnStartFn(func(ctx context.Context, info *callbacks.RunInfo, _ callbacks.CallbackInput) context.Context {
+			ctx, span := Start(ctx, runName(info), "eino.component", string(info.Component), "eino.type", info.Type)
+			return context.WithValue(ctx, einoSpanKey{}, span)
+		}).
+		OnEndFn(func(ctx context.Context, _ *callbacks.RunInfo, _ callbacks.CallbackOutput) context.Context {
+			einoSpan(ctx).End()
+			return ctx
+		}).
+		OnErrorFn(func(ctx context.Context, _ *callbacks.RunInfo, err error) context.Context {
+			s := einoSpan(ctx)
+			s.RecordError(err)
+			s.End()
+			return ctx
+		}).
+		Build()
+}
+
+func einoSpan(ctx context.Context) *Span {
+	s, _ := ctx.Value(einoSpanKey{}).(*Span)
+	return s
+}
+
+func runName(info *callbacks.RunInfo) string {
+	switch {
+	case info == nil:
+		return "eino"
+	case info.Name != "":
+		return info.Name
+	case info.Type != "":
+		return info.Type
+	default:
+		return string(info.Component)
+	}
+}
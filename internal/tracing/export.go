@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"audit-workflow/internal/config"
+	"audit-workflow/internal/jsonl"
+)
+
+// Setup installs a tracer for cfg.Tracing.Exporter and registers the Eino
+// callback handler. With no exporter it does nothing. The returned func
+// flushes the remaining spans and removes the tracer.
+func Setup(cfg *config.RootConfig) (func(context.Context) error, error) {
+	var exp Exporter
+	switch cfg.Tracing.Exporter {
+	case "", "none", "off":
+		return func(context.Context) error { return nil }, nil
+	case "file":
+		path := cfg.TracePath(time.Now())
+		fe, err := NewFileExporter(path)
+		if err != nil {
+			return nil, err
+		}
+		exp = fe
+	case "otlp":
+		oe, err := NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName)
+		if err != nil {
+			return nil, err
+		}
+		exp = oe
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q (want otlp or file)", cfg.Tracing.Exporter)
+	}
+
+	registerEinoHandler()
+	t := NewTracer(exp)
+	SetTracer(t)
+	return func(ctx context.Context) error {
+		current.CompareAndSwap(t, nil)
+		return t.Shutdown(ctx)
+	}, nil
+}
+
+// FileExporter writes one JSON span per line, for offline inspection.
+type FileExporter struct {
+	w *jsonl.Writer
+}
+
+// NewFileExporter creates path and its directory.
+func NewFileExporter(path string) (*FileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	w, err := jsonl.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{w: w}, nil
+}
+
+func (e *FileExporter) Export(_ context.Context, spans []SpanData) error {
+	for _, sd := range spans {
+		b, err := json.Marshal(sd)
+		if err != nil {
+			return err
+		}
+		if err := e.w.WriteLine(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *FileExporter) Shutdown(context.Context) error {
+	return e.w.Close()
+}
+
+// OTLPExporter posts spans as OTLP/HTTP JSON to <endpoint>/v1/traces.
+type OTLPExporter struct {
+	url     string
+	service string
+	hc      *http.Client
+}
+
+// NewOTLPExporter accepts the collector base URL (http://host:4318) or the
+// full traces URL.
+func NewOTLPExporter(endpoint, service string) (*OTLPExporter, error) {
+	u := strings.TrimRight(strings.TrimSpace(endpoint), "/")
+	if u == "" {
+		return nil, fmt.Errorf("empty tracing endpoint")
+	}
+	if !strings.HasSuffix(u, "/v1/traces") {
+		u += "/v1/traces"
+	}
+	return &OTLPExporter{url: u, service: service, hc: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp export: status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in the OTLP JSON encoding.
+func otlpRequest(service string, spans []SpanData) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, sd := range spans {
+		s := map[string]any{
+			"traceId":           sd.TraceID,
+			"spanId":            sd.SpanID,
+			"name":              sd.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(sd.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sd.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(sd.Attributes),
+		}
+		if sd.ParentSpanID != "" {
+			s["parentSpanId"] = sd.ParentSpanID
+		}
+		if sd.Error != "" {
+			s["status"] = map[string]any{"code": 2, "message": sd.Error} // STATUS_CODE_ERROR
+		}
+		out = append(out, s)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "audit-workflow/internal/tracing"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var val map[string]any
+		switch x := v.(type) {
+		case string:
+			val = map[string]any{"stringValue": x}
+		case bool:
+			val = map[string]any{"boolValue": x}
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			val = map[string]any{"doubleValue": x}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+// Package tracing records spans for workflow stages, records, model calls
+// and platform requests and exports them over OTLP/HTTP or to a JSONL file.
+// Tracing is off until Setup installs a tracer; until then Start returns a
+// nil span and every Span method is a no-op.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueSize bounds the spans waiting for export; spans beyond it are
+	// dropped rather than blocking the workflow.
+	queueSize = 4096
+	// batchSize and batchInterval bound how many spans, and how long, a
+	// batch collects before it is exported.
+	batchSize     = 256
+	batchInterval = 2 * time.Second
+)
+
+// SpanData is a finished span as handed to an Exporter. Attributes only
+// carry sizes, counts, names and IDs, never prompt or record contents.
+type SpanData struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMS   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	// Error is the message of the error recorded on the span, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Exporter ships finished spans. Export is called from a single goroutine.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer batches finished spans to an Exporter in the background.
+type Tracer struct {
+	exp   Exporter
+	queue chan SpanData
+	// stop is closed by Shutdown. The queue itself is never closed: spans
+	// started before Shutdown may still end, from any goroutine, after it.
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// NewTracer starts a tracer exporting to exp.
+func NewTracer(exp Exporter) *Tracer {
+	t := &Tracer{exp: exp, queue: make(chan SpanData, queueSize), stop: make(chan struct{}), done: make(chan struct{})}
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	tick := time.NewTicker(batchInterval)
+	defer tick.Stop()
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exp.Export(context.Background(), batch); err != nil {
+			slog.Warn("span export failed", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case sd := <-t.queue:
+			batch = append(batch, sd)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		case <-t.stop:
+			// Export what was queued before Shutdown.
+			for {
+				select {
+				case sd := <-t.queue:
+					batch = append(batch, sd)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracer) enqueue(sd SpanData) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	select {
+	case t.queue <- sd:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Shutdown exports the queued spans and shuts the exporter down. Spans
+// ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	var err error
+	t.once.Do(func() {
+		close(t.stop)
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		if n := t.dropped.Load(); n > 0 {
+			slog.Warn("spans dropped, export queue full", "dropped", n)
+		}
+		err = t.exp.Shutdown(ctx)
+	})
+	return err
+}
+
+var current atomic.Pointer[Tracer]
+
+// SetTracer installs t as the process-wide tracer; nil turns tracing off.
+func SetTracer(t *Tracer) {
+	current.Store(t)
+}
+
+// Enabled reports whether a tracer is installed.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Span is an unfinished span. A nil *Span is valid and does nothing.
+type Span struct {
+	t     *Tracer
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// Start begins a span named name as a child of the span in ctx, if any, and
+// returns a context carrying it. kv are attribute key/value pairs as in
+// slog. Without a tracer it returns ctx and a nil span.
+func Start(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	t := current.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{t: t, data: SpanData{Name: name, SpanID: newID(8), Start: time.Now()}}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentSpanID = parent.data.SpanID
+	} else {
+		s.data.TraceID = newID(16)
+	}
+	s.SetAttributes(kv...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanFromContext returns the span started last in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttributes adds key/value pairs to the span.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil || len(kv) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any, len(kv)/2)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.data.Attributes[fmt.Sprint(kv[i])] = attrValue(kv[i+1])
+	}
+}
+
+// RecordError marks the span as failed with err; nil is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call
+// counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.DurationMS = float64(s.data.End.Sub(s.data.Start).Microseconds()) / 1000
+	sd := s.data
+	s.mu.Unlock()
+	s.t.enqueue(sd)
+}
+
+// attrValue keeps strings, bools and numbers and formats anything else.
+func attrValue(v any) any {
+	switch x := v.(type) {
+	case string, bool, int, int64, float64:
+		return x
+	case int32:
+		return int64(x)
+	case uint:
+		return int64(x)
+	case float32:
+		return float64(x)
+	case time.Duration:
+		return x.Seconds()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audit-workflow/internal/config"
+
+	"github.com/cloudwego/eino/compose"
+)
+
+func TestStart_NoTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop", "k", 1)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatalf("expected nil span without a tracer")
+	}
+	span.SetAttributes("k", 2)
+	span.RecordError(errors.New("x"))
+	span.End()
+}
+
+func TestSetup_FileExporterEinoHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.RootConfig{
+		Paths:   config.PathsConfig{StateDir: dir},
+		Tracing: config.TracingConfig{Exporter: "file"},
+	}
+	shutdown, err := Setup(cfg)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	g := compose.NewGraph[string, string]()
+	model := compose.InvokableLambda(func(ctx context.Context, in string) (string, error) {
+		_, span := Start(ctx, "model risk", "gen_ai.prompt.chars", len(in))
+		span.End()
+		return in, nil
+	})
+	if err := g.AddLambdaNode("risk_model", model, compose.WithNodeName("risk_model")); err != nil {
+		t.Fatal(err)
+	}
+	fail := compose.InvokableLambda(func(ctx context.Context, in string) (string, error) {
+		return "", errors.New("boom")
+	})
+	if err := g.AddLambdaNode("parse", fail, compose.WithNodeName("parse")); err != nil {
+		t.Fatal(err)
+	}
+	_ = g.AddEdge(compose.START, "risk_model")
+	_ = g.AddEdge("risk_model", "parse")
+	_ = g.AddEdge("parse", compose.END)
+	r, err := g.Compile(context.Background(), compose.WithGraphName("risk_record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, rec := Start(context.Background(), "record", "audit.record_id", "42")
+	if _, err := r.Invoke(ctx, "secret prompt text"); err == nil {
+		t.Fatalf("expected graph error")
+	}
+	rec.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if Enabled() {
+		t.Fatalf("tracer still installed after shutdown")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "traces", "trace-*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expected one trace file, got %v", files)
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "secret") {
+		t.Fatalf("trace file contains the prompt: %s", raw)
+	}
+	byName := map[string]SpanData{}
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for sc.Scan() {
+		var sd SpanData
+		if err := json.Unmarshal(sc.Bytes(), &sd); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		byName[sd.Name] = sd
+	}
+
+	chain := []string{"model risk", "risk_model", "risk_record", "record"}
+	for i := 0; i < len(chain)-1; i++ {
+		child, parent := byName[chain[i]], byName[chain[i+1]]
+		if child.SpanID == "" || parent.SpanID == "" {
+			t.Fatalf("missing span %q or %q in %v", chain[i], chain[i+1], byName)
+		}
+		if child.ParentSpanID != parent.SpanID || child.TraceID != byName["record"].TraceID {
+			t.Fatalf("%q is not a child of %q", chain[i], chain[i+1])
+		}
+	}
+	if byName["parse"].Error == "" || byName["parse"].ParentSpanID != byName["risk_record"].SpanID {
+		t.Fatalf("unexpected parse span %+v", byName["parse"])
+	}
+	if got := byName["model risk"].Attributes["gen_ai.prompt.chars"]; got != float64(len("secret prompt text")) {
+		t.Fatalf("unexpected prompt size %v", got)
+	}
+	if byName["record"].Attributes["audit.record_id"] != "42" {
+		t.Fatalf("unexpected record attributes %v", byName["record"].Attributes)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &got)
+	}))
+	defer srv.Close()
+
+	exp, err := NewOTLPExporter(srv.URL, "audit-workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracer(exp)
+	SetTracer(tr)
+	ctx, parent := Start(context.Background(), "ai")
+	_, child := Start(ctx, "platform GET /lines/:id", "http.response.status_code", 200)
+	child.RecordError(errors.New("status 500"))
+	child.End()
+	parent.End()
+	SetTracer(nil)
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := got["resourceSpans"].([]any)[0].(map[string]any)
+	spans := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c := spans[0].(map[string]any)
+	if c["parentSpanId"] != spans[1].(map[string]any)["spanId"] || len(c["traceId"].(string)) != 32 {
+		t.Fatalf("unexpected child span %v", c)
+	}
+	if c["status"].(map[string]any)["code"] != float64(2) {
+		t.Fatalf("expected error status, got %v", c["status"])
+	}
+	attr := c["attributes"].([]any)[0].(map[string]any)
+	if attr["key"] != "http.response.status_code" || attr["value"].(map[string]any)["intValue"] != "200" {
+		t.Fatalf("unexpected attribute %v", attr)
+	}
+}
+
+// countingExporter counts the spans it was given.
+type countingExporter struct{ spans int }
+
+func (e *countingExporter) Export(_ context.Context, spans []SpanData) error {
+	e.spans += len(spans)
+	return nil
+}
+
+func (e *countingExporter) Shutdown(context.Context) error { return nil }
+
+func TestTracer_EndAfterShutdown(t *testing.T) {
+	exp := &countingExporter{}
+	tr := NewTracer(exp)
+	SetTracer(tr)
+	defer SetTracer(nil)
+	_, before := Start(context.Background(), "before")
+	_, late := Start(context.Background(), "late")
+	before.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// A span still open at Shutdown, such as a record past the grace
+	// period, ends without panicking and is dropped.
+	late.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exp.spans != 1 {
+		t.Fatalf("exported %d spans, want the one ended before Shutdown", exp.spans)
+	}
+}
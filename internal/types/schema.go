@@ -1,5 +1,13 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type PendingRecord struct {
 	ID   any            `json:"id"`
 	Data map[string]any `json:"data"`
@@ -9,3 +17,20 @@ type RiskRecord struct {
 	ID   any            `json:"id"`
 	Data map[string]any `json:"data"`
 }
+
+// RecordKey renders a record ID the same way whether it was decoded as a
+// float64, a json.Number or produced as an int by fetch, so that IDs read
+// back from state files match the ones a run produced.
+func RecordKey(id any) string {
+	switch v := id.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+	case json.Number:
+		return v.String()
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(fmt.Sprint(id))
+}